@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/blockcypher/cryptosigner/signer"
+	"github.com/blockcypher/cryptosigner/util"
+)
+
+// verifyAudit checks the chain of an audit log, and its HMACs unless -no-hmac
+func verifyAudit(args []string) {
+	fs := flag.NewFlagSet("verify-audit", flag.ExitOnError)
+	noHMAC := fs.Bool("no-hmac", false, "only check the hash chain, for logs written without HMAC")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: cryptosigner verify-audit [-no-hmac] <audit log>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var key []byte
+	if !*noHMAC {
+		pwd := readPassword()
+		masterKey := signer.MasterKey(pwd)
+		util.Wipe(pwd)
+		key = signer.DeriveAuditKey(masterKey)
+		util.Wipe(masterKey)
+	}
+	entries, err := signer.VerifyAuditLog(fs.Arg(0), key)
+	if err != nil {
+		fmt.Println("FAILED after", entries, "entries:", err)
+		os.Exit(1)
+	}
+	fmt.Println("OK,", entries, "entries verified.")
+}
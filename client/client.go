@@ -0,0 +1,336 @@
+// Package client calls the JSON API of a signer, over TLS with an optional client certificate or
+// HMAC signed requests, the signer certificate optionally pinned. API errors are returned as
+// *Error, matched by code with errors.Is.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blockcypher/cryptosigner/signer/api"
+)
+
+// Defaults of the configuration
+const (
+	DefaultTimeout   = 30 * time.Second
+	DefaultRetries   = 2
+	DefaultRetryWait = 250 * time.Millisecond
+)
+
+// maxResponseSize bounds the size of the responses read
+const maxResponseSize = 16 << 20
+
+// Config holds the TLS settings, authentication and retry policy of a client
+type Config struct {
+	// CAFile is a PEM bundle of the CAs of the signer certificate, the system roots when empty
+	CAFile string
+	// CertFile and KeyFile are the client certificate and its key, presented when set
+	CertFile string
+	KeyFile  string
+	// Pins are hex encoded SHA-256 of the SubjectPublicKeyInfo of certificates, see PinOf. The
+	// signer certificate, or a certificate of its verified chain when a CA bundle is set, must match
+	// one of them. Without a CA bundle, the pinned key is trusted alone and the chain isn't verified.
+	Pins []string
+
+	// HMACKeyID and HMACSecret sign requests, for signers behind TLS terminating proxies
+	HMACKeyID  string
+	HMACSecret []byte
+
+	// Timeout bounds each attempt of a call, DefaultTimeout when zero
+	Timeout time.Duration
+	// Retries is the number of retries of a call failing with a network error or an unavailable
+	// signer, DefaultRetries when zero, none when negative. Transfers without request ID are only
+	// retried when the signer answered it didn't create the key.
+	Retries int
+	// RetryWait is the wait before the first retry, doubled for each next one, DefaultRetryWait
+	// when zero
+	RetryWait time.Duration
+}
+
+// Client calls the API of a signer
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	hmacKeyID  string
+	hmacSecret []byte
+	retries    int
+	retryWait  time.Duration
+}
+
+// New creates a client of the signer at the base URL, https://host:port
+func New(baseURL string, config *Config) (*Client, error) {
+	if config == nil {
+		config = &Config{}
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" || len(u.Host) == 0 {
+		return nil, errors.New("Signer URL must be https://host:port")
+	}
+	if len(config.HMACKeyID) > 0 && len(config.HMACSecret) == 0 {
+		return nil, errors.New("HMAC key ID set without secret")
+	}
+	tlsConfig, err := config.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				TLSClientConfig:     tlsConfig,
+				TLSHandshakeTimeout: 10 * time.Second,
+				MaxIdleConnsPerHost: 8,
+				IdleConnTimeout:     90 * time.Second,
+			},
+			Timeout: config.Timeout,
+		},
+		hmacKeyID:  config.HMACKeyID,
+		hmacSecret: config.HMACSecret,
+		retries:    config.Retries,
+		retryWait:  config.RetryWait,
+	}
+	if c.httpClient.Timeout == 0 {
+		c.httpClient.Timeout = DefaultTimeout
+	}
+	if c.retries == 0 {
+		c.retries = DefaultRetries
+	} else if c.retries < 0 {
+		c.retries = 0
+	}
+	if c.retryWait == 0 {
+		c.retryWait = DefaultRetryWait
+	}
+	return c, nil
+}
+
+func (config *Config) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(config.CAFile) > 0 {
+		pem, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("No certificate found in CA bundle " + config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(config.CertFile) > 0 || len(config.KeyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(config.Pins) == 0 {
+		return tlsConfig, nil
+	}
+	pins := make(map[string]bool)
+	for _, pin := range config.Pins {
+		if b, err := hex.DecodeString(pin); err != nil || len(b) != sha256.Size {
+			return nil, errors.New("Pin " + pin + " is not a hex encoded SHA-256")
+		}
+		pins[strings.ToLower(pin)] = true
+	}
+	// without CA the pinned key is the trust anchor, the chain can't be verified. Sessions aren't
+	// resumed, so the pins are checked on every connection.
+	pinOnly := len(config.CAFile) == 0
+	tlsConfig.InsecureSkipVerify = pinOnly
+	tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+		if pinOnly {
+			if len(rawCerts) == 0 {
+				return ErrPinMismatch
+			}
+			leaf, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return err
+			}
+			chains = [][]*x509.Certificate{{leaf}}
+		}
+		for _, chain := range chains {
+			for _, cert := range chain {
+				if pins[PinOf(cert)] {
+					return nil
+				}
+			}
+		}
+		return ErrPinMismatch
+	}
+	return tlsConfig, nil
+}
+
+// PinOf returns the pin of a certificate, the hex encoded SHA-256 of its SubjectPublicKeyInfo
+func PinOf(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(hash[:])
+}
+
+// Transfer creates a key paying only to the target address, and to the fee address if set. With
+// a request ID, the call is retried and returns the key already created for it.
+func (c *Client) Transfer(ctx context.Context, req *api.TransferRequest) (*api.TransferResponse, error) {
+	resp := &api.TransferResponse{}
+	if err := c.call(ctx, "POST", api.Prefix+"transfer", req, resp, len(req.RequestID) > 0); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Sign signs a transaction, or one of its inputs, with the key of the source address
+func (c *Client) Sign(ctx context.Context, req *api.SignRequest) (*api.SignResponse, error) {
+	resp := &api.SignResponse{}
+	if err := c.call(ctx, "POST", api.Prefix+"sign", req, resp, true); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// SignBatch signs several inputs of a transaction, all or none
+func (c *Client) SignBatch(ctx context.Context, req *api.BatchSignRequest) (*api.BatchSignResponse, error) {
+	resp := &api.BatchSignResponse{}
+	if err := c.call(ctx, "POST", api.Prefix+"sign/batch", req, resp, true); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Status returns the version, readiness and key count of the signer
+func (c *Client) Status(ctx context.Context) (*api.StatusResponse, error) {
+	resp := &api.StatusResponse{}
+	if err := c.call(ctx, "GET", "/status", nil, resp, true); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// call sends a request, retried on failures unless the request may have been served and isn't
+// idempotent, and decodes the response or the error
+func (c *Client) call(ctx context.Context, method, path string, in, out interface{}, idempotent bool) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	wait := c.retryWait
+	for attempt := 0; ; attempt++ {
+		served, err := c.do(ctx, method, path, body, out)
+		if err == nil || attempt >= c.retries || ctx.Err() != nil || !retryable(err, served, idempotent) {
+			return err
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		wait *= 2
+	}
+}
+
+// do sends a request once. served is false when the request surely wasn't served: the signer
+// couldn't be reached or answered it was locked.
+func (c *Client) do(ctx context.Context, method, path string, body []byte, out interface{}) (served bool, err error) {
+	req, err := http.NewRequest(method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", "cryptosigner-client/"+api.Version)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if len(c.hmacKeyID) > 0 {
+		if err := c.signRequest(req, body); err != nil {
+			return false, err
+		}
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		var opErr *net.OpError
+		return !errors.As(err, &opErr) || opErr.Op != "dial", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return true, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &Error{Status: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		errResp := &api.ErrorResponse{}
+		if json.Unmarshal(data, errResp) == nil && errResp.Error != nil {
+			apiErr.Code, apiErr.Message, apiErr.Field = errResp.Error.Code, errResp.Error.Message, errResp.Error.Field
+		}
+		// a locked signer refuses requests before serving them
+		return apiErr.Code != api.CodeSignerLocked, apiErr
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return true, errors.New("Invalid signer response: " + err.Error())
+	}
+	return true, nil
+}
+
+// signRequest adds the HMAC headers to a request, with a new nonce
+func (c *Client) signRequest(req *http.Request, body []byte) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonceHex := hex.EncodeToString(nonce)
+	req.Header.Set(api.HeaderKeyID, c.hmacKeyID)
+	req.Header.Set(api.HeaderTimestamp, timestamp)
+	req.Header.Set(api.HeaderNonce, nonceHex)
+	req.Header.Set(api.HeaderSignature, api.SignHMAC(c.hmacSecret, req.Method, req.URL.RequestURI(),
+		timestamp, nonceHex, body))
+	return nil
+}
+
+// retryable tells whether a failed call is worth retrying: network errors and unavailable
+// signers, but not certificate errors. Calls which may have been served are
+// only retried when idempotent.
+func retryable(err error, served, idempotent bool) bool {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Status {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return idempotent || !served
+		}
+		return false
+	}
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) || errors.Is(err, ErrPinMismatch) {
+		return false
+	}
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	if errors.As(err, &unknownAuthority) || errors.As(err, &hostname) || errors.As(err, &invalid) {
+		return false
+	}
+	return idempotent
+}
@@ -0,0 +1,361 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/blockcypher/cryptosigner/signer"
+	"github.com/blockcypher/cryptosigner/signer/api"
+	"github.com/blockcypher/cryptosigner/signer/bitcoin"
+)
+
+const (
+	ADDR1   = "15qx9ug952GWGTNn7Uiv6vode4RcGrRemh"
+	TxData1 = "0100000001000000000000000000000000000000000000000000000000000000000000000000000000" +
+		"1976a9143522825adbc8908d47943b356bf789e4fad20b1c88ac000000000100f2052a010000001976" +
+		"a9143522825adbc8908d47943b356bf789e4fad20b1c88ac00000000"
+	TxData2 = "0100000001000000000000000000000000000000000000000000000000000000000000000000000000" +
+		"1976a914a78ddeb84ba308abb780429d1bcdebce20a153fb88ac000000000100f2052a010000001976" +
+		"a914a78ddeb84ba308abb780429d1bcdebce20a153fb88ac00000000"
+)
+
+// testHandler is a signing handler with a new file store in a temporary directory
+func testHandler(t *testing.T, config *signer.ServerConfig) *signer.SigningHandler {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	store, err := signer.MakeFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hold, err := signer.MakeHold([]byte("test"), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer.NewSigningHandler(hold, config)
+}
+
+// writePEM writes a PEM block in a temporary file and returns its path
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// serverCA writes the certificate of a test server as a CA bundle
+func serverCA(t *testing.T, server *httptest.Server) string {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return writePEM(t, dir, "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+}
+
+func newTestClient(t *testing.T, server *httptest.Server, config *Config) *Client {
+	c, err := New(server.URL, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// testHMAC authenticates test clients as "ops" on servers without authorization table
+func testHMAC() (*signer.HMACAuth, []byte) {
+	secret := bytes.Repeat([]byte{9}, 32)
+	return signer.NewHMACAuth(map[string][]byte{"ops": secret}, signer.DefaultHMACWindow), secret
+}
+
+func TestClient(t *testing.T) {
+	auth, secret := testHMAC()
+	server := httptest.NewTLSServer(testHandler(t, &signer.ServerConfig{HMAC: auth}))
+	defer server.Close()
+	c := newTestClient(t, server, &Config{CAFile: serverCA(t, server), HMACKeyID: "ops", HMACSecret: secret})
+	ctx := context.Background()
+
+	transfer, err := c.Transfer(ctx, &signer.TransferRequest{CoinPrefix: "btc", TargetAddr: ADDR1})
+	if err != nil || len(transfer.Address) == 0 || transfer.CoinPrefix != "btc" {
+		t.Fatal("Transfer failed:", err)
+	}
+	sign, err := c.Sign(ctx, &signer.SignRequest{SourceAddr: transfer.Address, TxData: TxData1})
+	if err != nil || len(sign.Signature) < 100 || len(sign.PublicKey) != 66 {
+		t.Fatal("Sign failed:", err)
+	}
+
+	// TxData1 with its input script emptied
+	tx, _ := bitcoin.ParseTx(mustDecodeHex(TxData1))
+	tx.Inputs[0].Script = nil
+	batch, err := c.SignBatch(ctx, &signer.BatchSignRequest{
+		TxData: hex.EncodeToString(tx.Bytes()),
+		Inputs: []*signer.BatchInputRequest{{InputIndex: 0, SourceAddr: transfer.Address, Prevout: tx.Outpoint(0)}},
+	})
+	if err != nil || len(batch.Signatures) != 1 || batch.Signatures[0].PublicKey != sign.PublicKey {
+		t.Fatal("Batch failed:", err)
+	}
+
+	status, err := c.Status(ctx)
+	if err != nil || !status.Ready || status.Keys != 1 || status.Version != api.Version {
+		t.Fatal("Status failed:", err, status)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	server := httptest.NewTLSServer(testHandler(t, &signer.ServerConfig{}))
+	defer server.Close()
+	c := newTestClient(t, server, &Config{CAFile: serverCA(t, server)})
+	ctx := context.Background()
+
+	transfer, err := c.Transfer(ctx, &signer.TransferRequest{CoinPrefix: "btc", TargetAddr: ADDR1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		call   func() error
+		target error
+		status int
+		field  string
+	}{
+		{func() error {
+			_, err := c.Sign(ctx, &signer.SignRequest{SourceAddr: transfer.Address, TxData: TxData2})
+			return err
+		}, ErrChallengeFailed, http.StatusUnprocessableEntity, "txData"},
+		{func() error {
+			_, err := c.Sign(ctx, &signer.SignRequest{SourceAddr: ADDR1, TxData: TxData1})
+			return err
+		}, ErrUnknownAddress, http.StatusNotFound, "sourceAddr"},
+		{func() error {
+			_, err := c.Transfer(ctx, &signer.TransferRequest{CoinPrefix: "xyz", TargetAddr: ADDR1})
+			return err
+		}, ErrUnknownNetwork, http.StatusBadRequest, "coinPrefix"},
+		{func() error {
+			_, err := c.Transfer(ctx, &signer.TransferRequest{CoinPrefix: "btc", TargetAddr: "1abc"})
+			return err
+		}, ErrInvalidAddress, http.StatusBadRequest, "targetAddr"},
+	}
+	for n, test := range tests {
+		err := test.call()
+		var apiErr *Error
+		if !errors.Is(err, test.target) || !errors.As(err, &apiErr) {
+			t.Errorf("Call %d: got %v, expected %s", n, err, test.target.(*Error).Code)
+			continue
+		}
+		if apiErr.Status != test.status || apiErr.Field != test.field || len(apiErr.Message) == 0 {
+			t.Errorf("Call %d: got %d %q", n, apiErr.Status, apiErr.Field)
+		}
+		if errors.Is(err, ErrForbidden) {
+			t.Errorf("Call %d: matched another code", n)
+		}
+	}
+}
+
+// testCert issues a certificate with the common name, self-signed when parent is nil
+func testCert(t *testing.T, cn string, parent *tls.Certificate) *tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	issuer, signerKey := template, interface{}(key)
+	if parent != nil {
+		issuer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestClientCertificate(t *testing.T) {
+	ca := testCert(t, "test CA", nil)
+	payments := testCert(t, "payments", ca)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+
+	server := httptest.NewUnstartedServer(testHandler(t, &signer.ServerConfig{Auth: signer.AuthTable{
+		"payments": {Operations: []signer.Operation{signer.OpTransfer}, CoinPrefixes: []string{"btc"}},
+	}}))
+	server.TLS = &tls.Config{ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	caFile := serverCA(t, server)
+	dir := filepath.Dir(caFile)
+	keyDER, _ := x509.MarshalECPrivateKey(payments.PrivateKey.(*ecdsa.PrivateKey))
+	c := newTestClient(t, server, &Config{
+		CAFile:   caFile,
+		CertFile: writePEM(t, dir, "payments.pem", "CERTIFICATE", payments.Certificate[0]),
+		KeyFile:  writePEM(t, dir, "payments.key", "EC PRIVATE KEY", keyDER),
+		Retries:  -1,
+	})
+	ctx := context.Background()
+	transfer, err := c.Transfer(ctx, &signer.TransferRequest{CoinPrefix: "btc", TargetAddr: ADDR1})
+	if err != nil {
+		t.Fatal("Transfer failed:", err)
+	}
+	if _, err := c.Sign(ctx, &signer.SignRequest{SourceAddr: transfer.Address, TxData: TxData1}); !errors.Is(err, ErrForbidden) {
+		t.Error("Sign should be forbidden:", err)
+	}
+
+	// without certificate, the handshake fails
+	anonymous := newTestClient(t, server, &Config{CAFile: caFile, Retries: -1})
+	if _, err := anonymous.Status(ctx); err == nil {
+		t.Error("Client without certificate should have been refused")
+	}
+}
+
+func TestClientHMAC(t *testing.T) {
+	secret := bytes.Repeat([]byte{7}, 32)
+	server := httptest.NewTLSServer(testHandler(t, &signer.ServerConfig{
+		HMAC: signer.NewHMACAuth(map[string][]byte{"payments": secret}, signer.DefaultHMACWindow),
+		Auth: signer.AuthTable{"payments": {Operations: []signer.Operation{signer.OpTransfer}, CoinPrefixes: []string{"btc"}}},
+	}))
+	defer server.Close()
+	caFile := serverCA(t, server)
+	ctx := context.Background()
+
+	c := newTestClient(t, server, &Config{CAFile: caFile, HMACKeyID: "payments", HMACSecret: secret})
+	for n := 0; n < 2; n++ {
+		if _, err := c.Transfer(ctx, &signer.TransferRequest{CoinPrefix: "btc", TargetAddr: ADDR1}); err != nil {
+			t.Fatal("Transfer failed:", err)
+		}
+	}
+	if _, err := c.Status(ctx); err != nil {
+		t.Error("Status failed:", err)
+	}
+
+	wrong := newTestClient(t, server, &Config{CAFile: caFile, HMACKeyID: "payments", HMACSecret: bytes.Repeat([]byte{8}, 32)})
+	if _, err := wrong.Status(ctx); !errors.Is(err, ErrUnauthenticated) {
+		t.Error("Wrong secret should have failed:", err)
+	}
+}
+
+// countingHandler counts requests, failing the first ones
+type countingHandler struct {
+	handler  http.Handler
+	requests int32
+	fail     int32
+	// drop closes the connection of failed requests instead of answering them
+	drop bool
+}
+
+func (ch *countingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if atomic.AddInt32(&ch.requests, 1) > ch.fail {
+		ch.handler.ServeHTTP(w, r)
+		return
+	}
+	if ch.drop {
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write([]byte(`{"error":{"code":"signer_locked","message":"Signer locked."}}`))
+}
+
+func TestClientPins(t *testing.T) {
+	auth, secret := testHMAC()
+	counter := &countingHandler{handler: testHandler(t, &signer.ServerConfig{HMAC: auth})}
+	server := httptest.NewTLSServer(counter)
+	defer server.Close()
+	pin := PinOf(server.Certificate())
+	ctx := context.Background()
+
+	// the pinned key alone, without CA, and with the CA
+	for _, config := range []*Config{{Pins: []string{pin}}, {CAFile: serverCA(t, server), Pins: []string{pin}}} {
+		config.HMACKeyID, config.HMACSecret = "ops", secret
+		if _, err := newTestClient(t, server, config).Status(ctx); err != nil {
+			t.Error("Pinned status failed:", err)
+		}
+	}
+
+	other := hex.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	for _, config := range []*Config{{Pins: []string{other}}, {CAFile: serverCA(t, server), Pins: []string{other}}} {
+		before := atomic.LoadInt32(&counter.requests)
+		if _, err := newTestClient(t, server, config).Status(ctx); !errors.Is(err, ErrPinMismatch) {
+			t.Error("Pin mismatch expected:", err)
+		}
+		if atomic.LoadInt32(&counter.requests) != before {
+			t.Error("Request sent despite the pin mismatch")
+		}
+	}
+
+	if _, err := New(server.URL, &Config{Pins: []string{"abcd"}}); err == nil {
+		t.Error("Invalid pin accepted")
+	}
+	if _, err := New("http://localhost:8443", nil); err == nil {
+		t.Error("Plain HTTP accepted")
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		drop      bool
+		requestID string
+		fail      int32
+		ok        bool
+		requests  int32
+	}{
+		// a locked signer didn't create the key, retried
+		{false, "", 2, true, 3},
+		{false, "", 3, false, 3},
+		// a dropped connection may have created the key, only retried with a request ID
+		{true, "", 1, false, 1},
+		{true, "payment-1", 2, true, 3},
+	}
+	for n, test := range tests {
+		counter := &countingHandler{handler: testHandler(t, &signer.ServerConfig{}), fail: test.fail, drop: test.drop}
+		server := httptest.NewTLSServer(counter)
+		c := newTestClient(t, server, &Config{CAFile: serverCA(t, server), RetryWait: time.Millisecond})
+		_, err := c.Transfer(ctx, &signer.TransferRequest{CoinPrefix: "btc", TargetAddr: ADDR1, RequestID: test.requestID})
+		if (err == nil) != test.ok || counter.requests != test.requests {
+			t.Errorf("Test %d: got %v after %d requests", n, err, counter.requests)
+		}
+		if !test.ok && !test.drop && !errors.Is(err, ErrSignerLocked) {
+			t.Errorf("Test %d: signer_locked expected, got %v", n, err)
+		}
+		server.Close()
+	}
+}
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
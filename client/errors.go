@@ -0,0 +1,57 @@
+package client
+
+import (
+	"errors"
+
+	"github.com/blockcypher/cryptosigner/signer/api"
+)
+
+// Error is an error returned by the signer API, with the HTTP status and the machine readable
+// code of the error object
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+	Field   string
+}
+
+func (e *Error) Error() string {
+	if len(e.Message) == 0 {
+		return "signer error " + e.Code
+	}
+	return e.Message
+}
+
+// Is matches the errors of the package with the same code, so errors.Is(err, ErrChallengeFailed)
+// tells why a call failed
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Errors of the API codes, matched with errors.Is
+var (
+	ErrBadRequest        = &Error{Code: api.CodeBadRequest}
+	ErrMissingField      = &Error{Code: api.CodeMissingField}
+	ErrInvalidField      = &Error{Code: api.CodeInvalidField}
+	ErrInvalidAddress    = &Error{Code: api.CodeInvalidAddress}
+	ErrUnknownNetwork    = &Error{Code: api.CodeUnknownNetwork}
+	ErrUnknownAddress    = &Error{Code: api.CodeUnknownAddress}
+	ErrChallengeFailed   = &Error{Code: api.CodeChallengeFailed}
+	ErrMessagesDisabled  = &Error{Code: api.CodeMessagesDisabled}
+	ErrUnauthenticated   = &Error{Code: api.CodeUnauthenticated}
+	ErrForbidden         = &Error{Code: api.CodeForbidden}
+	ErrTooLarge          = &Error{Code: api.CodeTooLarge}
+	ErrNotFound          = &Error{Code: api.CodeNotFound}
+	ErrMethodNotAllowed  = &Error{Code: api.CodeMethodNotAllowed}
+	ErrInternal          = &Error{Code: api.CodeInternal}
+	ErrRequestConflict   = &Error{Code: api.CodeRequestConflict}
+	ErrKeyFrozen         = &Error{Code: api.CodeKeyFrozen}
+	ErrKeyDeleted        = &Error{Code: api.CodeKeyDeleted}
+	ErrInvalidTransition = &Error{Code: api.CodeInvalidTransition}
+	ErrSignerFrozen      = &Error{Code: api.CodeSignerFrozen}
+	ErrSignerLocked      = &Error{Code: api.CodeSignerLocked}
+)
+
+// ErrPinMismatch is returned when the signer certificate doesn't match any pinned key
+var ErrPinMismatch = errors.New("signer certificate doesn't match the pinned keys")
@@ -0,0 +1,540 @@
+// Package config reads the signer configuration from a JSON file, environment variables and
+// command line flags, in increasing precedence, and validates it at startup.
+package config
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blockcypher/cryptosigner/signer"
+	"github.com/blockcypher/cryptosigner/util"
+)
+
+// EnvPrefix prefixes the environment variables overriding settings, CRYPTOSIGNER_STORE_PATH
+// overrides store-path
+const EnvPrefix = "CRYPTOSIGNER_"
+
+// Config is the signer configuration
+type Config struct {
+	// Listen is the address the server listens on, host:port
+	Listen string `json:"listen"`
+	TLS    TLS    `json:"tls"`
+	Store  Store  `json:"store"`
+	// Timeouts of the HTTP server
+	Timeouts Timeouts `json:"timeouts"`
+	// MaxBodyBytes limits the size of request bodies
+	MaxBodyBytes int64 `json:"maxBodyBytes"`
+	Auth         Auth  `json:"auth"`
+	Audit        Audit `json:"audit"`
+	Lock         Lock  `json:"lock"`
+	// Password is where the password is read from, the console by default
+	Password Password `json:"password"`
+	// MessageSigning is the message signing policy: off, per-key or all
+	MessageSigning string `json:"messageSigning"`
+	// Coins holds settings by coin prefix
+	Coins map[string]*Coin `json:"coins"`
+}
+
+// TLS holds the server TLS material and protocol settings
+type TLS struct {
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	// MinVersion and MaxVersion are 1.2 or 1.3
+	MinVersion string `json:"minVersion"`
+	MaxVersion string `json:"maxVersion"`
+	// CipherSuites restricts TLS 1.2 cipher suites, by Go name, all secure ones when empty
+	CipherSuites []string `json:"cipherSuites"`
+}
+
+// Store selects the key store backend and its location
+type Store struct {
+	// Backend is the store type, only file for now
+	Backend string `json:"backend"`
+	Path    string `json:"path"`
+	// DeleteGrace is how long deleted keys are kept before being purged
+	DeleteGrace Duration `json:"deleteGrace"`
+}
+
+// Timeouts of the HTTP server, as Go durations ("10s")
+type Timeouts struct {
+	ReadHeader Duration `json:"readHeader"`
+	Read       Duration `json:"read"`
+	Write      Duration `json:"write"`
+	Idle       Duration `json:"idle"`
+	// Shutdown bounds the time in-flight requests have to complete on SIGTERM or SIGINT
+	Shutdown Duration `json:"shutdown"`
+}
+
+// Auth holds the client authentication settings
+type Auth struct {
+	// ClientCA is the PEM bundle of client certificate CAs
+	ClientCA string `json:"clientCA"`
+	// HMACSecrets is the JSON file of HMAC secrets by key ID
+	HMACSecrets string `json:"hmacSecrets"`
+	// HMACWindow is the accepted clock skew of HMAC signed requests
+	HMACWindow Duration `json:"hmacWindow"`
+	// Table is the JSON authorization table
+	Table string `json:"table"`
+}
+
+// Audit holds the audit log settings
+type Audit struct {
+	// Path of the log, operations are not audited when empty
+	Path string `json:"path"`
+	// HMAC authenticates entries with a key derived from the master key
+	HMAC bool `json:"hmac"`
+}
+
+// Lock holds the runtime locking settings
+type Lock struct {
+	// StartLocked starts the signer without asking for the password, to be unlocked over the
+	// admin API
+	StartLocked bool `json:"startLocked"`
+	// Idle locks the signer when its keys weren't used for that long, never when zero
+	Idle Duration `json:"idle"`
+	// Memory locks the pages holding secrets in memory (mlock) so they're never swapped, Linux and
+	// macOS only
+	Memory bool `json:"memory"`
+}
+
+// Coin holds the settings of a network
+type Coin struct {
+	// Disabled refuses new keys for the network, existing keys still sign
+	Disabled bool `json:"disabled"`
+	// ChainID overrides the chain id of Ethereum family networks
+	ChainID int64 `json:"chainId"`
+}
+
+// Duration is a time.Duration read from a Go duration string
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON reads a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New("duration must be a string such as \"30s\"")
+	}
+	var err error
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
+
+// Default returns the configuration of a signer without configuration: port 8443, signer.crt
+// and signer.key, and the .store directory, all in the current directory.
+func Default() *Config {
+	return &Config{
+		Listen: ":8443",
+		TLS:    TLS{CertFile: "signer.crt", KeyFile: "signer.key", MinVersion: "1.2"},
+		Store:  Store{Backend: "file", Path: signer.DirName, DeleteGrace: Duration{signer.DefaultDeleteGrace}},
+		Timeouts: Timeouts{Duration{10 * time.Second}, Duration{30 * time.Second}, Duration{30 * time.Second},
+			Duration{120 * time.Second}, Duration{30 * time.Second}},
+		MaxBodyBytes:   1 << 20,
+		Auth:           Auth{HMACWindow: Duration{signer.DefaultHMACWindow}},
+		Audit:          Audit{HMAC: true},
+		MessageSigning: "off",
+	}
+}
+
+// settings overridable from the environment and flags, by name
+var settings = map[string]struct {
+	usage string
+	set   func(c *Config, v string) error
+}{
+	"listen":          {"listen address, host:port", func(c *Config, v string) error { c.Listen = v; return nil }},
+	"tls-cert":        {"TLS certificate file", func(c *Config, v string) error { c.TLS.CertFile = v; return nil }},
+	"tls-key":         {"TLS private key file", func(c *Config, v string) error { c.TLS.KeyFile = v; return nil }},
+	"tls-min-version": {"minimum TLS version, 1.2 or 1.3", func(c *Config, v string) error { c.TLS.MinVersion = v; return nil }},
+	"store-backend":   {"key store backend: file", func(c *Config, v string) error { c.Store.Backend = v; return nil }},
+	"store-path":      {"key store directory", func(c *Config, v string) error { c.Store.Path = v; return nil }},
+	"delete-grace": {"how long deleted keys are kept before being purged, e.g. 168h",
+		func(c *Config, v string) (err error) {
+			c.Store.DeleteGrace.Duration, err = time.ParseDuration(v)
+			return
+		}},
+	"client-ca": {"PEM bundle of the CAs of client certificates, requires client certificates when set",
+		func(c *Config, v string) error { c.Auth.ClientCA = v; return nil }},
+	"hmac-secrets": {"JSON file of hex encoded secrets by key ID, accepts HMAC signed requests when set",
+		func(c *Config, v string) error { c.Auth.HMACSecrets = v; return nil }},
+	"auth": {"JSON authorization table granting operations and coin prefixes to client identities",
+		func(c *Config, v string) error { c.Auth.Table = v; return nil }},
+	"audit-log": {"audit log file, operations are not audited when empty",
+		func(c *Config, v string) error { c.Audit.Path = v; return nil }},
+	"start-locked": {"start locked, to be unlocked with the password over the admin API: true or false",
+		func(c *Config, v string) (err error) {
+			c.Lock.StartLocked, err = strconv.ParseBool(v)
+			return
+		}},
+	"idle-lock": {"lock the signer when its keys weren't used for that long, e.g. 15m, never when 0",
+		func(c *Config, v string) (err error) {
+			c.Lock.Idle.Duration, err = time.ParseDuration(v)
+			return
+		}},
+	"password-fd": {"file descriptor to read the password from instead of the console",
+		func(c *Config, v string) (err error) {
+			c.Password.FD, err = strconv.Atoi(v)
+			return
+		}},
+	"password-file": {"file or named pipe to read the password from instead of the console",
+		func(c *Config, v string) error { c.Password.File = v; return nil }},
+	"password-credential": {"systemd credential (LoadCredential=) to read the password from instead of the console",
+		func(c *Config, v string) error { c.Password.Credential = v; return nil }},
+	"mlock": {"lock the pages holding secrets in memory so they're never swapped: true or false",
+		func(c *Config, v string) (err error) {
+			c.Lock.Memory, err = strconv.ParseBool(v)
+			return
+		}},
+	"message-signing": {"which keys may sign messages (proof of reserves): off, per-key or all",
+		func(c *Config, v string) error { c.MessageSigning = v; return nil }},
+}
+
+// Set changes a setting by name
+func (c *Config) Set(name, value string) error {
+	setting, ok := settings[name]
+	if !ok {
+		return errors.New("unknown setting " + name)
+	}
+	return setting.set(c, value)
+}
+
+// EnvName is the environment variable overriding a setting
+func EnvName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// ApplyEnv overrides settings with the environment variables set
+func (c *Config) ApplyEnv(lookupEnv func(string) (string, bool)) error {
+	for name := range settings {
+		if v, ok := lookupEnv(EnvName(name)); ok {
+			if err := c.Set(name, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ReadFile reads a JSON configuration file over the current settings. Unknown fields are errors.
+func (c *Config) ReadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Flags are the command line flags of the configuration. Settings given are applied over the
+// file and environment.
+type Flags struct {
+	// Offline skips the checks of the server settings, for commands run with the signer stopped
+	Offline bool
+	path    string
+	values  map[string]string
+}
+
+type flagValue struct {
+	flags *Flags
+	name  string
+}
+
+func (fv *flagValue) String() string {
+	if fv.flags == nil {
+		return ""
+	}
+	return fv.flags.values[fv.name]
+}
+
+func (fv *flagValue) Set(v string) error {
+	fv.flags.values[fv.name] = v
+	return nil
+}
+
+// RegisterFlags registers -config and a flag per setting
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	flags := &Flags{values: make(map[string]string)}
+	fs.StringVar(&flags.path, "config", "", "JSON configuration file")
+	for name, setting := range settings {
+		fs.Var(&flagValue{flags, name}, name, setting.usage+" (env "+EnvName(name)+")")
+	}
+	return flags
+}
+
+// Load reads the configuration: defaults, configuration file, environment and flags, and
+// validates it
+func (f *Flags) Load() (*Config, error) {
+	c := Default()
+	if len(f.path) > 0 {
+		if err := c.ReadFile(f.path); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.ApplyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	for name, v := range f.values {
+		if err := c.Set(name, v); err != nil {
+			return nil, err
+		}
+	}
+	validate := c.Validate
+	if f.Offline {
+		validate = c.ValidateOffline
+	}
+	if err := validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+var tlsVersions = map[string]uint16{"1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13}
+
+// Validate checks the configuration, returning an error listing every problem found
+func (c *Config) Validate() error {
+	return c.validate(true)
+}
+
+// ValidateOffline checks the configuration but the listen address, TLS and client authentication
+// settings, which files needn't be on a signer run offline
+func (c *Config) ValidateOffline() error {
+	return c.validate(false)
+}
+
+func (c *Config) validate(server bool) error {
+	var problems []string
+	problem := func(setting string, err error) {
+		problems = append(problems, setting+": "+err.Error())
+	}
+
+	if server {
+		if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+			problem("listen", err)
+		}
+		if _, err := tls.LoadX509KeyPair(c.TLS.CertFile, c.TLS.KeyFile); err != nil {
+			problem("tls.certFile/keyFile", err)
+		}
+		if err := c.validateAuth(); err != nil {
+			problem("auth", err)
+		}
+	}
+	if _, err := c.tlsVersions(); err != nil {
+		problem("tls.minVersion/maxVersion", err)
+	}
+	if _, err := c.cipherSuites(); err != nil {
+		problem("tls.cipherSuites", err)
+	}
+	if c.Store.Backend != "file" {
+		problem("store.backend", errors.New("unknown backend "+c.Store.Backend))
+	}
+	if len(c.Store.Path) == 0 {
+		problem("store.path", errors.New("missing"))
+	}
+	if c.Lock.Idle.Duration < 0 {
+		problem("lock.idle", errors.New("must not be negative"))
+	}
+	if c.Store.DeleteGrace.Duration < 0 {
+		problem("store.deleteGrace", errors.New("must not be negative"))
+	}
+	for name, d := range map[string]Duration{"readHeader": c.Timeouts.ReadHeader, "read": c.Timeouts.Read,
+		"write": c.Timeouts.Write, "idle": c.Timeouts.Idle, "shutdown": c.Timeouts.Shutdown} {
+		if d.Duration <= 0 {
+			problem("timeouts."+name, errors.New("must be positive"))
+		}
+	}
+	if c.MaxBodyBytes <= 0 {
+		problem("maxBodyBytes", errors.New("must be positive"))
+	}
+	if _, err := signer.ParseMessagePolicy(c.MessageSigning); err != nil {
+		problem("messageSigning", err)
+	}
+	if err := c.validatePassword(); err != nil {
+		problem("password", err)
+	}
+	for prefix, coin := range c.Coins {
+		network, err := signer.LookupNetwork(prefix)
+		if err != nil {
+			problem("coins."+prefix, err)
+		} else if coin != nil && coin.ChainID != 0 && network.Family != signer.EthereumFamily {
+			problem("coins."+prefix+".chainId", errors.New("only for Ethereum family networks"))
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func (c *Config) validateAuth() error {
+	if c.Auth.HMACWindow.Duration <= 0 {
+		return errors.New("hmacWindow must be positive")
+	}
+	if len(c.Auth.HMACSecrets) > 0 && len(c.Auth.Table) == 0 {
+		return errors.New("HMAC signed requests require an authorization table")
+	}
+	if len(c.Auth.Table) > 0 && len(c.Auth.ClientCA) == 0 && len(c.Auth.HMACSecrets) == 0 {
+		return errors.New("an authorization table requires a client CA or HMAC secrets")
+	}
+	if c.Lock.StartLocked && len(c.Auth.Table) == 0 {
+		return errors.New("startLocked requires an authorization table granting unlock")
+	}
+	_, err := c.ServerConfig()
+	return err
+}
+
+func (c *Config) tlsVersions() ([2]uint16, error) {
+	var versions [2]uint16
+	for n, v := range []string{c.TLS.MinVersion, c.TLS.MaxVersion} {
+		if len(v) == 0 {
+			continue
+		}
+		version, ok := tlsVersions[v]
+		if !ok {
+			return versions, errors.New("unsupported TLS version " + v + ", 1.2 or 1.3")
+		}
+		versions[n] = version
+	}
+	if versions[1] != 0 && versions[0] > versions[1] {
+		return versions, errors.New("minimum version above maximum version")
+	}
+	return versions, nil
+}
+
+func (c *Config) cipherSuites() ([]uint16, error) {
+	if len(c.TLS.CipherSuites) == 0 {
+		return nil, nil
+	}
+	ids := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		ids[suite.Name] = suite.ID
+	}
+	var suites []uint16
+	for _, name := range c.TLS.CipherSuites {
+		id, ok := ids[name]
+		if !ok {
+			return nil, errors.New("unknown or insecure cipher suite " + name)
+		}
+		suites = append(suites, id)
+	}
+	return suites, nil
+}
+
+// ServerConfig builds the server configuration, loading the authentication files
+func (c *Config) ServerConfig() (*signer.ServerConfig, error) {
+	versions, err := c.tlsVersions()
+	if err != nil {
+		return nil, err
+	}
+	suites, err := c.cipherSuites()
+	if err != nil {
+		return nil, err
+	}
+	config := &signer.ServerConfig{
+		Addr:              c.Listen,
+		CertFile:          c.TLS.CertFile,
+		KeyFile:           c.TLS.KeyFile,
+		MinTLSVersion:     versions[0],
+		MaxTLSVersion:     versions[1],
+		CipherSuites:      suites,
+		ReadHeaderTimeout: c.Timeouts.ReadHeader.Duration,
+		ReadTimeout:       c.Timeouts.Read.Duration,
+		WriteTimeout:      c.Timeouts.Write.Duration,
+		IdleTimeout:       c.Timeouts.Idle.Duration,
+		ShutdownTimeout:   c.Timeouts.Shutdown.Duration,
+		MaxBodyBytes:      c.MaxBodyBytes,
+		ClientCAFile:      c.Auth.ClientCA,
+		DeleteGrace:       c.Store.DeleteGrace.Duration,
+		IdleLock:          c.Lock.Idle.Duration,
+	}
+	if len(c.Auth.ClientCA) > 0 {
+		if _, err := ioutil.ReadFile(c.Auth.ClientCA); err != nil {
+			return nil, err
+		}
+	}
+	if len(c.Auth.HMACSecrets) > 0 {
+		secrets, err := signer.LoadHMACSecrets(c.Auth.HMACSecrets)
+		if err != nil {
+			return nil, err
+		}
+		config.HMAC = signer.NewHMACAuth(secrets, c.Auth.HMACWindow.Duration)
+	}
+	if len(c.Auth.Table) > 0 {
+		config.Auth, err = signer.LoadAuthTable(c.Auth.Table)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// MessagePolicy returns the message signing policy
+func (c *Config) MessagePolicy() signer.MessagePolicy {
+	policy, _ := signer.ParseMessagePolicy(c.MessageSigning)
+	return policy
+}
+
+// OpenAuditLog opens the audit log, nil when not configured. Entries are HMAC'd with a key
+// derived from the master key when enabled.
+func (c *Config) OpenAuditLog(masterKey []byte) (*signer.AuditLog, error) {
+	if len(c.Audit.Path) == 0 {
+		return nil, nil
+	}
+	var key []byte
+	if c.Audit.HMAC {
+		key = signer.DeriveAuditKey(masterKey)
+	}
+	return signer.OpenAuditLog(c.Audit.Path, key)
+}
+
+// PendingAuditLog returns the audit log of a signer starting locked, opened on unlock, nil when
+// not configured
+func (c *Config) PendingAuditLog() *signer.AuditLog {
+	if len(c.Audit.Path) == 0 {
+		return nil
+	}
+	return signer.PendingAuditLog(c.Audit.Path, c.Audit.HMAC)
+}
+
+// OpenStore opens the configured key store
+func (c *Config) OpenStore() (signer.Store, error) {
+	return signer.MakeFileStore(c.Store.Path)
+}
+
+// LockMemory locks the pages of the secrets allocated from now on when enabled
+func (c *Config) LockMemory() error {
+	if !c.Lock.Memory {
+		return nil
+	}
+	return util.LockMemory()
+}
+
+// ApplyCoins applies the per coin settings to the network registry
+func (c *Config) ApplyCoins() {
+	for prefix, coin := range c.Coins {
+		network, err := signer.LookupNetwork(prefix)
+		if err != nil || coin == nil {
+			continue
+		}
+		network.Disabled = coin.Disabled
+		if coin.ChainID != 0 {
+			network.ChainID = coin.ChainID
+		}
+	}
+}
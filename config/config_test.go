@@ -0,0 +1,168 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := writeFile(t, dir, "signer.json", `{
+		"listen": ":9443",
+		"tls": {"certFile": "../signer.crt", "keyFile": "../signer.key", "minVersion": "1.3"},
+		"store": {"path": "/var/lib/signer-file"},
+		"timeouts": {"write": "1m"},
+		"coins": {"eth-sepolia": {"chainId": 5}, "doge": {"disabled": true}}
+	}`)
+
+	os.Setenv(EnvName("store-path"), "/var/lib/signer-env")
+	os.Setenv(EnvName("listen"), ":7443")
+	defer os.Unsetenv(EnvName("store-path"))
+	defer os.Unsetenv(EnvName("listen"))
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := RegisterFlags(fs)
+	if err := fs.Parse([]string{"-config", path, "-listen", "127.0.0.1:8443"}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := flags.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Listen != "127.0.0.1:8443" || c.Store.Path != "/var/lib/signer-env" || c.TLS.MinVersion != "1.3" {
+		t.Errorf("Unexpected precedence: %+v", c)
+	}
+	if c.Timeouts.Write.Duration != time.Minute || c.Timeouts.Read.Duration != 30*time.Second {
+		t.Error("Unexpected timeouts:", c.Timeouts)
+	}
+	if c.Coins["eth-sepolia"].ChainID != 5 || !c.Coins["doge"].Disabled {
+		t.Error("Unexpected coins:", c.Coins)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := Default()
+	c.TLS.CertFile, c.TLS.KeyFile = "../signer.crt", "../signer.key"
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Listen = "8443"
+	c.TLS.MinVersion = "1.0"
+	c.TLS.CipherSuites = []string{"TLS_RSA_WITH_RC4_128_SHA"}
+	c.Store.Backend = "s3"
+	c.Timeouts.Idle.Duration = 0
+	c.MessageSigning = "some"
+	c.Auth.Table = "auth.json"
+	c.Coins = map[string]*Coin{"xyz": {}, "btc": {ChainID: 1}}
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("Validation should have failed.")
+	}
+	for _, setting := range []string{"listen", "tls.minVersion", "tls.cipherSuites", "store.backend",
+		"timeouts.idle", "messageSigning", "auth", "coins.xyz", "coins.btc.chainId"} {
+		if !strings.Contains(err.Error(), "\n  "+setting) {
+			t.Error("Missing problem with", setting, "in", err)
+		}
+	}
+}
+
+func TestValidateStartLocked(t *testing.T) {
+	c := Default()
+	c.TLS.CertFile, c.TLS.KeyFile = "../signer.crt", "../signer.key"
+	c.Lock.StartLocked = true
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "startLocked") {
+		t.Error("Starting locked without authorization table should have failed:", err)
+	}
+}
+
+func TestValidateOffline(t *testing.T) {
+	c := Default()
+	c.TLS.CertFile = "missing.crt"
+	c.Auth.Table = "auth.json"
+	if err := c.ValidateOffline(); err != nil {
+		t.Error("Server settings should not be checked offline:", err)
+	}
+	c.Store.Path = ""
+	if err := c.ValidateOffline(); err == nil || !strings.Contains(err.Error(), "store.path") {
+		t.Error("Store settings should be checked offline:", err)
+	}
+}
+
+func TestReadFileUnknownField(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := writeFile(t, dir, "signer.json", `{"listen": ":9443", "storePath": "x"}`)
+	if err := Default().ReadFile(path); err == nil || !strings.Contains(err.Error(), "storePath") {
+		t.Error("Unknown field should have failed:", err)
+	}
+}
+
+func TestReadPassword(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	const pwd = "correct horse  battery staplé ✓"
+
+	c := Default()
+	c.Password.File = writeFile(t, dir, "password", pwd+"\r\nignored\n")
+	if read, err := c.ReadPassword(false); err != nil || string(read) != pwd {
+		t.Errorf("Unexpected password from file: %q %v", read, err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString(pwd)
+	w.Close()
+	c = Default()
+	c.Password.FD = int(r.Fd())
+	if read, err := c.ReadPassword(false); err != nil || string(read) != pwd {
+		t.Errorf("Unexpected password from fd: %q %v", read, err)
+	}
+
+	writeFile(t, dir, "signer-password", pwd+"\n")
+	os.Setenv("CREDENTIALS_DIRECTORY", dir)
+	defer os.Unsetenv("CREDENTIALS_DIRECTORY")
+	c = Default()
+	c.Password.Credential = "signer-password"
+	if read, err := c.ReadPassword(false); err != nil || string(read) != pwd {
+		t.Errorf("Unexpected password from credential: %q %v", read, err)
+	}
+
+	c.Password.File = writeFile(t, dir, "empty", "\n")
+	if err := c.validatePassword(); err == nil {
+		t.Error("Two password sources should be refused")
+	}
+	c.Password.Credential = ""
+	if _, err := c.ReadPassword(false); err == nil {
+		t.Error("Empty password should be refused")
+	}
+	c.Password.File = writeFile(t, dir, "long", strings.Repeat("x", maxPasswordLen+1))
+	if _, err := c.ReadPassword(false); err == nil {
+		t.Error("Too long password should be refused")
+	}
+}
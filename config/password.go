@@ -0,0 +1,153 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/term"
+
+	"github.com/blockcypher/cryptosigner/util"
+)
+
+// Password selects where the password is read from, the console when none is set. Only one
+// source can be set.
+type Password struct {
+	// FD is a file descriptor inherited from the supervisor, 0 for the console
+	FD int `json:"fd"`
+	// File is a file or named pipe holding the password on its first line
+	File string `json:"file"`
+	// Credential is the name of a systemd credential (LoadCredential=), read from
+	// $CREDENTIALS_DIRECTORY
+	Credential string `json:"credential"`
+}
+
+// maxPasswordLen bounds the line read from a password source
+const maxPasswordLen = 1024
+
+// validatePassword checks a single password source is set
+func (c *Config) validatePassword() error {
+	sources := 0
+	for _, set := range []bool{c.Password.FD != 0, len(c.Password.File) > 0, len(c.Password.Credential) > 0} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return errors.New("only one of fd, file and credential can be set")
+	}
+	if c.Password.FD < 0 {
+		return errors.New("fd must not be negative")
+	}
+	if len(c.Password.Credential) > 0 {
+		if len(os.Getenv("CREDENTIALS_DIRECTORY")) == 0 {
+			return errors.New("credential set but CREDENTIALS_DIRECTORY isn't, missing LoadCredential=?")
+		}
+		if filepath.Base(c.Password.Credential) != c.Password.Credential {
+			return errors.New("credential must be a name, not a path")
+		}
+	}
+	return nil
+}
+
+// ReadPassword reads the password from the configured source, or from the console asking twice
+// when confirm is set, for a new store. The password is the whole first line, spaces and non
+// ASCII characters included, without its line ending.
+func (c *Config) ReadPassword(confirm bool) ([]byte, error) {
+	var path string
+	switch {
+	case c.Password.FD != 0:
+		return readPasswordFrom(os.NewFile(uintptr(c.Password.FD), "password-fd"))
+	case len(c.Password.File) > 0:
+		path = c.Password.File
+	case len(c.Password.Credential) > 0:
+		path = filepath.Join(os.Getenv("CREDENTIALS_DIRECTORY"), c.Password.Credential)
+	default:
+		return readConsolePassword(confirm)
+	}
+	// opening a named pipe blocks until a writer opens it
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return readPasswordFrom(f)
+}
+
+// readPasswordFrom reads the password on the first line of a file, closing it
+func readPasswordFrom(f *os.File) ([]byte, error) {
+	if f == nil {
+		return nil, errors.New("Invalid password file descriptor")
+	}
+	defer f.Close()
+	pwd, err := readLine(bufio.NewReaderSize(io.LimitReader(f, maxPasswordLen+1), maxPasswordLen+1))
+	if err != nil {
+		return nil, fmt.Errorf("Could not read password from %s: %w", f.Name(), err)
+	}
+	return pwd, nil
+}
+
+func readConsolePassword(confirm bool) ([]byte, error) {
+	pwd, err := ReadSecret("Enter password: ")
+	if err != nil || !confirm {
+		return pwd, err
+	}
+	again, err := ReadSecret("Confirm password: ")
+	if err != nil {
+		util.Wipe(pwd)
+		return nil, err
+	}
+	defer util.Wipe(again)
+	if subtle.ConstantTimeCompare(pwd, again) != 1 {
+		util.Wipe(pwd)
+		return nil, errors.New("Passwords don't match")
+	}
+	return pwd, nil
+}
+
+// stdin is shared by the secrets read from a console that isn't a terminal, one per line
+var stdin = bufio.NewReaderSize(os.Stdin, maxPasswordLen+1)
+
+// ReadSecret prompts for a secret on the console, read without echo from a terminal or as a line
+// from a pipe
+func ReadSecret(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return readLine(stdin)
+	}
+	secret, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("Empty secret")
+	}
+	return secret, nil
+}
+
+// readLine reads a line without its line ending, at most maxPasswordLen bytes
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		util.Wipe(line)
+		return nil, errors.New("Line too long")
+	}
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return nil, err
+	}
+	trimmed := bytes.TrimRight(line, "\r\n")
+	secret := util.NewSecret(len(trimmed))
+	copy(secret, trimmed)
+	// the reader buffer held the secret
+	util.Wipe(line)
+	if len(secret) == 0 {
+		return nil, errors.New("Empty secret")
+	}
+	return secret, nil
+}
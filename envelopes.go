@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/blockcypher/cryptosigner/signer"
+)
+
+// signEnvelopes signs the envelopes of a directory, offline, into an output directory
+func signEnvelopes(args []string) {
+	fs, flags := newFlagSet("sign-envelopes", "<input directory> <output directory>")
+	noManifest := fs.Bool("no-manifest", false, "sign envelopes without a manifest, unchecked")
+	keyFile := fs.String("manifest-key", "", "file of the hex encoded key authenticating the manifests")
+	conf := loadConfig(fs, flags, args, 2)
+	key := loadManifestKey(*keyFile)
+
+	hold, audit := openHold(conf)
+	defer audit.Close()
+	summary, err := offlineHandler(hold, audit).SignEnvelopes(cliClient, fs.Arg(0), fs.Arg(1), key, !*noManifest)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := hold.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if !summary.Verified {
+		fmt.Println("No manifest, envelopes unchecked.")
+	} else if key == nil {
+		fmt.Println("Manifests not authenticated, no manifest key.")
+	}
+	fmt.Println(summary.Signed, "envelopes signed,", summary.Failed, "failed, written to", fs.Arg(1))
+	if summary.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// manifest writes the manifest of a directory of envelopes, or checks it with -verify
+func manifest(args []string) {
+	fs := flag.NewFlagSet("manifest", flag.ExitOnError)
+	verify := fs.Bool("verify", false, "check the envelopes match the manifest instead of writing it")
+	keyFile := fs.String("key", "", "file of the hex encoded key authenticating the manifest")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: cryptosigner manifest [-verify] [-key <file>] <directory>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	key := loadManifestKey(*keyFile)
+
+	if !*verify {
+		manifest, err := signer.WriteManifest(fs.Arg(0), key)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Manifest of", len(manifest.Files), "envelopes written.")
+		return
+	}
+	manifest, err := signer.VerifyManifest(fs.Arg(0), key)
+	if err != nil {
+		fmt.Println("FAILED:", err)
+		os.Exit(1)
+	}
+	fmt.Println("OK,", len(manifest.Files), "envelopes verified.")
+}
+
+// loadManifestKey reads the manifest key of a file, nil without file
+func loadManifestKey(path string) []byte {
+	if len(path) == 0 {
+		return nil
+	}
+	key, err := signer.LoadManifestKey(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return key
+}
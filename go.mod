@@ -5,8 +5,7 @@ go 1.14
 require (
 	github.com/btcsuite/btcd v0.23.1 // indirect
 	github.com/btcsuite/btcd/btcec/v2 v2.2.0
-	github.com/btcsuite/btcutil v1.0.2
 	github.com/ethereum/go-ethereum v1.10.19
 	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
-
+	golang.org/x/term v0.10.0
 )
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/blockcypher/cryptosigner/signer"
+)
+
+// cliClient is the client name of operations from the command line in the audit log
+const cliClient = "cli"
+
+// offlineHandler is the handler serving commands as the API would, without authorization
+func offlineHandler(hold *signer.Hold, audit *signer.AuditLog) *signer.SigningHandler {
+	return signer.NewSigningHandler(hold, &signer.ServerConfig{Audit: audit})
+}
+
+// newKey creates a key with the signer stopped, for a transfer to the target addresses
+func newKey(args []string) {
+	fs, flags := newFlagSet("new-key", "<target address>")
+	coinPrefix := fs.String("coin", "btc", "coin prefix of the key")
+	feeAddr := fs.String("fee", "", "change address the key may also pay to")
+	allowMessages := fs.Bool("messages", false, "allow the key to sign messages, under the per-key policy")
+	requestID := fs.String("request-id", "", "request ID making the creation idempotent")
+	conf := loadConfig(fs, flags, args, 1)
+
+	hold, audit := openHold(conf)
+	defer audit.Close()
+	resp, err := offlineHandler(hold, audit).Transfer(cliClient, &signer.TransferRequest{
+		CoinPrefix:    *coinPrefix,
+		TargetAddr:    fs.Arg(0),
+		FeeAddr:       *feeAddr,
+		AllowMessages: *allowMessages,
+		RequestID:     *requestID,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := hold.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	printJSON(resp)
+}
+
+// signFile signs a hex encoded transaction read from a file, or the standard input when -
+func signFile(args []string) {
+	fs, flags := newFlagSet("sign", "<source address> <transaction file>")
+	input := fs.Int("input", -1, "index of the input to sign, with -amount for segwit and Bitcoin Cash")
+	amount := fs.Uint64("amount", 0, "amount of the output spent by the input, in satoshis")
+	conf := loadConfig(fs, flags, args, 2)
+
+	var txData []byte
+	var err error
+	if fs.Arg(1) == "-" {
+		txData, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		txData, err = ioutil.ReadFile(fs.Arg(1))
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	req := &signer.SignRequest{SourceAddr: fs.Arg(0), TxData: string(bytes.TrimSpace(txData))}
+	if *input >= 0 {
+		req.InputIndex, req.Amount = input, amount
+	}
+
+	hold, audit := openHold(conf)
+	defer audit.Close()
+	resp, err := offlineHandler(hold, audit).Sign(cliClient, req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// use counts are saved
+	if err := hold.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	printJSON(resp)
+}
+
+// inspect prints the metadata of a key, its challenge decoded, without the password
+func inspect(args []string) {
+	fs, flags := newFlagSet("inspect", "<address>")
+	conf := loadConfig(fs, flags, args, 1)
+
+	info, err := openLockedHold(conf).KeyInfo(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	printJSON(info)
+}
+
+// export prints the metadata of the keys of the store, without the password
+func export(args []string) {
+	fs, flags := newFlagSet("export", "")
+	coinPrefixes := fs.String("coins", "", "comma separated coin prefixes of the keys exported, all when empty")
+	state := fs.String("state", "", "state of the keys exported, all when empty")
+	conf := loadConfig(fs, flags, args, 0)
+	if len(*state) > 0 && !signer.ValidKeyState(*state) {
+		log.Fatal("Unknown key state ", *state)
+	}
+
+	filter := &signer.KeyFilter{State: *state}
+	if len(*coinPrefixes) > 0 {
+		filter.CoinPrefixes = strings.Split(*coinPrefixes, ",")
+	}
+	hold := openLockedHold(conf)
+	keys := []*signer.KeyInfo{}
+	for after := ""; ; {
+		page, next := hold.ListKeys(filter, after, signer.MaxKeysLimit)
+		keys = append(keys, page...)
+		if len(next) == 0 {
+			break
+		}
+		after = next
+	}
+	printJSON(keys)
+}
+
+// keyState moves a key to a lifecycle state in the store, with the signer stopped
+func keyState(args []string) {
+	fs, flags := newFlagSet("key-state", "<address> <active|frozen|retired|deleted>")
+	conf := loadConfig(fs, flags, args, 2)
+	addr, state := fs.Arg(0), fs.Arg(1)
+	if !signer.ValidKeyState(state) {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	hold, audit := openHold(conf)
+	defer audit.Close()
+	entry := &signer.AuditEntry{Event: "state", Client: cliClient, Source: addr, State: state,
+		Outcome: signer.OutcomeStateChanged}
+	info, err := hold.SetKeyState(addr, state)
+	if err != nil {
+		entry.Outcome = signer.OutcomeError
+	} else {
+		entry.CoinPrefix = info.CoinPrefix
+	}
+	if err := audit.Record(entry, err); err != nil {
+		log.Fatal(err)
+	}
+	if err := hold.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("state |", addr, "->", state, "|", cliClient)
+}
@@ -1,34 +1,241 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
+	"os"
+	"runtime"
+	"strings"
 
+	"github.com/blockcypher/cryptosigner/config"
 	"github.com/blockcypher/cryptosigner/signer"
+	"github.com/blockcypher/cryptosigner/signer/api"
 	"github.com/blockcypher/cryptosigner/util"
 )
 
+// command is a subcommand of the command line
+type command struct {
+	name    string
+	summary string
+	run     func(args []string)
+}
+
+var commands = []*command{
+	{"serve", "unlock the signer and serve the HTTPS API, the default", serve},
+	{"init", "initialise a new store with its password or shares", initStore},
+	{"new-key", "create a key paying only to target addresses, offline", newKey},
+	{"sign", "sign a transaction read from a file with the key of an address", signFile},
+	{"inspect", "print the metadata and challenge of a stored key", inspect},
+	{"export", "print the metadata of all stored keys, never their private keys", export},
+	{"sign-envelopes", "sign the envelopes of a directory into an output directory, air-gapped", signEnvelopes},
+	{"manifest", "write or verify the manifest of a directory of envelopes", manifest},
+	{"verify-store", "check every stored key decrypts to the key of its address", verifyStore},
+	{"key-state", "move a key to a lifecycle state", keyState},
+	{"split-key", "split a random master key in shares for M-of-N unlock, for a store without keys", splitKey},
+	{"verify-audit", "check the chain and HMACs of an audit log", verifyAudit},
+	{"version", "print the version", printVersion},
+}
+
 func main() {
-	fmt.Print("Enter password: ")
-	var pwd string
-	fmt.Scanln(&pwd)
-	if len(pwd) == 0 {
-		log.Fatal("Could not read.")
+	args := os.Args[1:]
+	// without a command, or with flags only, serve as older versions did
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		serve(args)
+		return
+	}
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			cmd.run(args[1:])
+			return
+		}
+	}
+	if args[0] != "help" {
+		fmt.Fprintln(os.Stderr, "Unknown command", args[0])
+	}
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: cryptosigner <command> [options] [arguments]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-15s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun cryptosigner <command> -h for the options of a command.")
+}
+
+// newFlagSet creates the flag set of a command, with the configuration flags. Commands but serve
+// run offline.
+func newFlagSet(name, arguments string) (*flag.FlagSet, *config.Flags) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	flags := config.RegisterFlags(fs)
+	flags.Offline = name != "serve"
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: cryptosigner", name, "[options]", arguments)
+		fs.PrintDefaults()
+	}
+	return fs, flags
+}
+
+// loadConfig parses the arguments of a command and loads the configuration. Exits with the usage
+// unless there are nargs positional arguments.
+func loadConfig(fs *flag.FlagSet, flags *config.Flags, args []string, nargs int) *config.Config {
+	fs.Parse(args)
+	if fs.NArg() != nargs {
+		fs.Usage()
+		os.Exit(2)
+	}
+	conf, err := flags.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	conf.ApplyCoins()
+	if err := conf.LockMemory(); err != nil {
+		log.Fatal(err)
 	}
+	return conf
+}
 
-	ecdsaSigner := &util.ECDSASigner{}
-	store, err := signer.MakeFileStore()
+// serve unlocks the signer, unless starting locked, and serves the API until stopped
+func serve(args []string) {
+	fs, flags := newFlagSet("serve", "")
+	conf := loadConfig(fs, flags, args, 0)
+	serverConfig, err := conf.ServerConfig()
 	if err != nil {
-		log.Println(err)
-		return
+		log.Fatal(err)
 	}
 
-	hold, err := signer.MakeHold(pwd, store, ecdsaSigner)
+	store, err := conf.OpenStore()
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
+	var hold *signer.Hold
+	if conf.Lock.StartLocked {
+		// a locked signer can't take its first password over the API, init saves its verifier
+		initialized, initErr := signer.Initialized(store)
+		if initErr != nil {
+			log.Fatal(initErr)
+		}
+		if !initialized {
+			log.Fatal("Store ", conf.Store.Path, " not initialised, run cryptosigner init first.")
+		}
+		hold, err = signer.MakeLockedHold(store)
+		log.Println("Started locked, unlock with POST /v1/admin/unlock or /v1/admin/shares.")
+		serverConfig.Audit = conf.PendingAuditLog()
+	} else {
+		masterKey := readMasterKey(conf, store)
+		hold, err = signer.MakeHoldFromKey(masterKey, store)
+		if err == nil {
+			serverConfig.Audit, err = conf.OpenAuditLog(masterKey)
+		}
+		util.Wipe(masterKey)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	hold.SetMessagePolicy(conf.MessagePolicy())
+
 	log.Println("Starting server")
-	signer.StartServer(hold)
+	if err := signer.StartServer(hold, serverConfig); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// openHold opens the store and unlocks its hold, with the audit log of offline operations
+func openHold(conf *config.Config) (*signer.Hold, *signer.AuditLog) {
+	store, err := conf.OpenStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+	masterKey := readMasterKey(conf, store)
+	defer util.Wipe(masterKey)
+	hold, err := signer.MakeHoldFromKey(masterKey, store)
+	if err != nil {
+		log.Fatal(err)
+	}
+	hold.SetMessagePolicy(conf.MessagePolicy())
+	audit, err := conf.OpenAuditLog(masterKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return hold, audit
+}
+
+// openLockedHold opens the store in a locked hold, for commands only reading key metadata
+func openLockedHold(conf *config.Config) *signer.Hold {
+	store, err := conf.OpenStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+	hold, err := signer.MakeLockedHold(store)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return hold
+}
+
+// readMasterKey reads the master key: shares from operators when the store master key is split,
+// the password otherwise
+func readMasterKey(conf *config.Config, store signer.Store) []byte {
+	settings, err := signer.LoadShareSettings(store)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if settings == nil {
+		// a new store is encrypted with the password entered, confirmed to catch typos
+		initialized, err := signer.Initialized(store)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pwd, err := conf.ReadPassword(!initialized)
+		if err != nil {
+			log.Fatal("Could not read password: ", err)
+		}
+		defer util.Wipe(pwd)
+		return signer.MasterKey(pwd)
+	}
+	fmt.Fprintln(os.Stderr, "Master key split in", settings.Shares, "shares,", settings.Threshold, "needed.")
+	shares := make([][]byte, settings.Threshold)
+	for n := range shares {
+		share, err := config.ReadSecret(fmt.Sprintf("Enter share %d of %d: ", n+1, settings.Threshold))
+		if err != nil {
+			log.Fatal("Could not read share: ", err)
+		}
+		shares[n] = share
+	}
+	masterKey, err := signer.CombineShares(shares)
+	for _, share := range shares {
+		util.Wipe(share)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return masterKey
+}
+
+// readPassword reads the password from the console, for commands without a store
+func readPassword() []byte {
+	pwd, err := config.ReadSecret("Enter password: ")
+	if err != nil {
+		log.Fatal("Could not read password: ", err)
+	}
+	return pwd
+}
+
+// printJSON prints a value as indented JSON on the standard output
+func printJSON(v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(data))
+}
+
+func printVersion(args []string) {
+	fmt.Println("cryptosigner", api.Version, runtime.Version())
 }
@@ -0,0 +1,485 @@
+package signer
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/blockcypher/cryptosigner/signer/api"
+	"github.com/blockcypher/cryptosigner/signer/bitcoin"
+)
+
+// Versioned JSON API, next to the legacy form-encoded endpoints. Both share the operations below,
+// validation failures are reported with the messages the form endpoints have always returned.
+
+// Wire types and codes of the API, defined in package api so clients don't import the signer
+
+// APIPrefix is the path prefix of the JSON API
+const APIPrefix = api.Prefix
+
+// APIError is an error reported to API clients: HTTP status, machine readable code, message and
+// the request field that failed, if any
+type APIError = api.Error
+
+// Error codes of the API
+const (
+	CodeBadRequest        = api.CodeBadRequest
+	CodeMissingField      = api.CodeMissingField
+	CodeInvalidField      = api.CodeInvalidField
+	CodeInvalidAddress    = api.CodeInvalidAddress
+	CodeUnknownNetwork    = api.CodeUnknownNetwork
+	CodeUnknownAddress    = api.CodeUnknownAddress
+	CodeChallengeFailed   = api.CodeChallengeFailed
+	CodeMessagesDisabled  = api.CodeMessagesDisabled
+	CodeUnauthenticated   = api.CodeUnauthenticated
+	CodeForbidden         = api.CodeForbidden
+	CodeTooLarge          = api.CodeTooLarge
+	CodeNotFound          = api.CodeNotFound
+	CodeMethodNotAllowed  = api.CodeMethodNotAllowed
+	CodeInternal          = api.CodeInternal
+	CodeRequestConflict   = api.CodeRequestConflict
+	CodeKeyFrozen         = api.CodeKeyFrozen
+	CodeKeyDeleted        = api.CodeKeyDeleted
+	CodeInvalidTransition = api.CodeInvalidTransition
+	CodeSignerFrozen      = api.CodeSignerFrozen
+	CodeSignerLocked      = api.CodeSignerLocked
+	CodeWrongPassword     = api.CodeWrongPassword
+	CodeWrongShares       = api.CodeWrongShares
+	CodeShareSubmitted    = api.CodeShareSubmitted
+	CodeNotSplit          = api.CodeNotSplit
+	CodeNotInitialized    = api.CodeNotInitialized
+	CodeSplit             = api.CodeSplit
+)
+
+// Requests and responses of the API
+type (
+	TransferRequest     = api.TransferRequest
+	TransferResponse    = api.TransferResponse
+	SignRequest         = api.SignRequest
+	SignResponse        = api.SignResponse
+	BatchSignRequest    = api.BatchSignRequest
+	BatchInputRequest   = api.BatchInputRequest
+	BatchSignResponse   = api.BatchSignResponse
+	InputSignature      = api.InputSignature
+	SignMessageRequest  = api.SignMessageRequest
+	SignMessageResponse = api.SignMessageResponse
+	ErrorResponse       = api.ErrorResponse
+	StatusResponse      = api.StatusResponse
+)
+
+func apiError(status int, code, msg, field string) *APIError {
+	return &APIError{Status: status, Code: code, Message: msg, Field: field}
+}
+
+func badRequest(code, field, msg string) *APIError {
+	return apiError(http.StatusBadRequest, code, msg, field)
+}
+
+// Transfer creates a key as POST /v1/transfer does, for callers in process such as the command
+// line. Errors are APIErrors for invalid requests.
+func (sh *SigningHandler) Transfer(client string, req *TransferRequest) (*TransferResponse, error) {
+	return sh.transfer(client, req)
+}
+
+// Sign signs as POST /v1/sign does, for callers in process
+func (sh *SigningHandler) Sign(client string, req *SignRequest) (*SignResponse, error) {
+	return sh.sign(client, req)
+}
+
+func (sh *SigningHandler) transfer(client string, req *TransferRequest) (resp *TransferResponse, err error) {
+	coinFamily := UnknownCoinFamily
+	entry := &AuditEntry{Event: "transfer", Client: client}
+	defer func(start time.Time) {
+		if err = sh.record(entry, OutcomeCreated, err); err != nil {
+			resp = nil
+		}
+		sh.metrics.Observe("transfer", coinFamily, requestOutcome(OutcomeCreated, err), time.Since(start))
+	}(time.Now())
+
+	if err := sh.authorize(client, OpTransfer, ""); err != nil {
+		return nil, err
+	}
+	network, err := transferNetwork(req.CoinPrefix, req.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	coinFamily, entry.CoinPrefix = network.Family, network.Name
+	if err := sh.authorize(client, OpTransfer, network.Name); err != nil {
+		return nil, err
+	}
+
+	// Ethereum does not have change addresses
+	if network.Family == EthereumFamily && len(req.FeeAddr) != 0 {
+		return nil, badRequest(CodeInvalidField, "feeAddr", "Invalid change address param for EthereumFamily")
+	}
+
+	if len(req.TargetAddr) == 0 {
+		return nil, badRequest(CodeMissingField, "targetAddr", "Missing target address for transfer.")
+	}
+	targetAddr, err := network.ValidateAddress(req.TargetAddr)
+	if err != nil {
+		return nil, badRequest(CodeInvalidAddress, "targetAddr", "Invalid target address: "+err.Error()+".")
+	}
+	addrs := []string{targetAddr}
+	if len(req.FeeAddr) > 0 {
+		feeAddr, err := network.ValidateAddress(req.FeeAddr)
+		if err != nil {
+			return nil, badRequest(CodeInvalidAddress, "feeAddr", "Invalid fee address: "+err.Error()+".")
+		}
+		addrs = append(addrs, feeAddr)
+	}
+
+	if len(req.RequestID) > 0 && !ValidRequestID(req.RequestID) {
+		return nil, badRequest(CodeInvalidField, "requestId", "Invalid request ID.")
+	}
+
+	log.Println(addrs)
+	entry.Targets = addrs
+	opts := &KeyOptions{AllowMessages: req.AllowMessages, RequestID: req.RequestID,
+		AddressType: bitcoin.AddressType(req.AddressType)}
+	addr, err := sh.hold.NewKey(NewNetworkChallenge(addrs, network), network, opts)
+	if errors.Is(err, ErrAddressType) {
+		return nil, badRequest(CodeInvalidField, "addressType", "Unsupported address type: "+req.AddressType+".")
+	} else if err != nil {
+		return nil, err
+	}
+	entry.Source = addr
+	log.Println("transfer |", addr, "->", targetAddr, clientLog(client))
+	return &TransferResponse{Address: addr, CoinPrefix: network.Name}, nil
+}
+
+func (sh *SigningHandler) sign(client string, req *SignRequest) (resp *SignResponse, err error) {
+	coinFamily := UnknownCoinFamily
+	entry := &AuditEntry{Event: "sign", Client: client, Source: req.SourceAddr}
+	defer func(start time.Time) {
+		if err = sh.record(entry, OutcomeSigned, err); err != nil {
+			resp = nil
+		}
+		sh.metrics.Observe("sign", coinFamily, requestOutcome(OutcomeSigned, err), time.Since(start))
+	}(time.Now())
+
+	if err := sh.authorize(client, OpSign, ""); err != nil {
+		return nil, err
+	}
+	if len(req.SourceAddr) == 0 || len(req.TxData) == 0 {
+		field := "sourceAddr"
+		if len(req.SourceAddr) > 0 {
+			field = "txData"
+		}
+		return nil, badRequest(CodeMissingField, field, "Missing source address or tx data to sign.")
+	}
+	log.Println("sign     |", req.SourceAddr, clientLog(client))
+	if network, err := sh.hold.KeyNetwork(req.SourceAddr); err == nil {
+		coinFamily, entry.CoinPrefix = network.Family, network.Name
+	}
+	if err := sh.authorizeKey(client, OpSign, req.SourceAddr); err != nil {
+		return nil, err
+	}
+
+	txData, err := hex.DecodeString(req.TxData)
+	if err != nil {
+		return nil, badRequest(CodeInvalidField, "txData", "Bad hex encoding.")
+	}
+	entry.DataHash = dataHash(txData)
+
+	var spent *SpentOutput
+	if req.InputIndex != nil || req.Amount != nil {
+		if req.InputIndex == nil || req.Amount == nil {
+			return nil, badRequest(CodeInvalidField, "inputIndex", "Invalid input index or amount.")
+		}
+		spent = &SpentOutput{InputIndex: *req.InputIndex, Amount: *req.Amount}
+	}
+
+	sig, pubkey, err := sh.hold.SignInput(req.SourceAddr, txData, spent)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("sign     | ok")
+	return &SignResponse{Signature: hex.EncodeToString(sig), PublicKey: hex.EncodeToString(pubkey)}, nil
+}
+
+func (sh *SigningHandler) signBatch(client string, req *BatchSignRequest) (resp *BatchSignResponse, err error) {
+	coinFamily := UnknownCoinFamily
+	var entries []*AuditEntry
+	defer func(start time.Time) {
+		for _, entry := range entries {
+			if err = sh.record(entry, OutcomeSigned, err); err != nil {
+				resp = nil
+			}
+		}
+		sh.metrics.Observe("signbatch", coinFamily, requestOutcome(OutcomeSigned, err), time.Since(start))
+	}(time.Now())
+
+	if err := sh.authorize(client, OpSign, ""); err != nil {
+		return nil, err
+	}
+	if len(req.TxData) == 0 || len(req.Inputs) == 0 {
+		field := "txData"
+		if len(req.TxData) > 0 {
+			field = "inputs"
+		}
+		return nil, badRequest(CodeMissingField, field, "Missing tx data or inputs to sign.")
+	}
+	txData, err := hex.DecodeString(req.TxData)
+	if err != nil {
+		return nil, badRequest(CodeInvalidField, "txData", "Bad hex encoding.")
+	}
+
+	inputs := make([]*BatchInput, len(req.Inputs))
+	for n, in := range req.Inputs {
+		if in == nil || len(in.SourceAddr) == 0 {
+			return nil, badRequest(CodeMissingField, "inputs", "Missing source address of input.")
+		}
+		entry := &AuditEntry{Event: "signbatch", Client: client, Source: in.SourceAddr, DataHash: dataHash(txData)}
+		entries = append(entries, entry)
+		if network, err := sh.hold.KeyNetwork(in.SourceAddr); err == nil {
+			coinFamily, entry.CoinPrefix = network.Family, network.Name
+		}
+		if err := sh.authorizeKey(client, OpSign, in.SourceAddr); err != nil {
+			return nil, err
+		}
+		inputs[n] = &BatchInput{Address: in.SourceAddr,
+			SpentOutput: SpentOutput{InputIndex: in.InputIndex, Outpoint: in.Prevout}}
+		if in.Amount != nil {
+			inputs[n].Amount = *in.Amount
+		}
+	}
+	log.Println("batch    |", len(inputs), "inputs", clientLog(client))
+
+	sigs, pubkeys, err := sh.hold.SignBatch(txData, inputs)
+	if errors.Is(err, ErrInvalidInput) {
+		return nil, badRequest(CodeInvalidField, "inputs", err.Error())
+	} else if err != nil {
+		return nil, err
+	}
+	log.Println("batch    | ok")
+	resp = &BatchSignResponse{Signatures: make([]*InputSignature, len(inputs))}
+	for n, in := range req.Inputs {
+		resp.Signatures[n] = &InputSignature{InputIndex: in.InputIndex, SourceAddr: in.SourceAddr,
+			Signature: hex.EncodeToString(sigs[n]), PublicKey: hex.EncodeToString(pubkeys[n])}
+	}
+	return resp, nil
+}
+
+func (sh *SigningHandler) signMessage(client string, req *SignMessageRequest) (resp *SignMessageResponse, err error) {
+	coinFamily := UnknownCoinFamily
+	entry := &AuditEntry{Event: "signmessage", Client: client, Source: req.SourceAddr,
+		DataHash: dataHash([]byte(req.Message))}
+	defer func(start time.Time) {
+		if err = sh.record(entry, OutcomeSigned, err); err != nil {
+			resp = nil
+		}
+		sh.metrics.Observe("signmessage", coinFamily, requestOutcome(OutcomeSigned, err), time.Since(start))
+	}(time.Now())
+
+	if err := sh.authorize(client, OpSign, ""); err != nil {
+		return nil, err
+	}
+	if len(req.SourceAddr) == 0 || len(req.Message) == 0 {
+		field := "sourceAddr"
+		if len(req.SourceAddr) > 0 {
+			field = "message"
+		}
+		return nil, badRequest(CodeMissingField, field, "Missing source address or message to sign.")
+	}
+	log.Println("message  |", req.SourceAddr, clientLog(client))
+	if network, err := sh.hold.KeyNetwork(req.SourceAddr); err == nil {
+		coinFamily, entry.CoinPrefix = network.Family, network.Name
+	}
+	if err := sh.authorizeKey(client, OpSign, req.SourceAddr); err != nil {
+		return nil, err
+	}
+
+	sig, err := sh.hold.SignMessage(req.SourceAddr, []byte(req.Message))
+	if err != nil {
+		return nil, err
+	}
+	log.Println("message  | ok")
+	return &SignMessageResponse{Signature: base64.StdEncoding.EncodeToString(sig)}, nil
+}
+
+// authorize checks the client is allowed the operation on the coin prefix, when an authorization
+// table is configured. Without table, admin, unfreeze and unlock still require a client identity.
+// Denials are logged.
+func (sh *SigningHandler) authorize(client string, op Operation, coinPrefix string) error {
+	if sh.auth == nil {
+		if len(client) == 0 && (op == OpAdmin || op == OpUnfreeze || op == OpUnlock) {
+			log.Println("denied   |", op)
+			return unauthenticated("Client not authenticated.")
+		}
+		return nil
+	}
+	err := sh.auth.Authorize(client, op, coinPrefix)
+	if err != nil {
+		log.Println("denied   |", op, coinPrefix, clientLog(client))
+	}
+	return err
+}
+
+// authorizeKey checks the client is allowed the operation on the network of the key of an address
+func (sh *SigningHandler) authorizeKey(client string, op Operation, addr string) error {
+	if sh.auth == nil {
+		return nil
+	}
+	network, err := sh.hold.KeyNetwork(addr)
+	if err != nil {
+		return err
+	}
+	return sh.authorize(client, op, network.Name)
+}
+
+// record appends the audit entry of an operation with its outcome. The operation fails if it
+// can't be recorded.
+func (sh *SigningHandler) record(entry *AuditEntry, success string, err error) error {
+	entry.Outcome = requestOutcome(success, err)
+	return sh.audit.Record(entry, err)
+}
+
+func clientLog(client string) string {
+	if len(client) == 0 {
+		return ""
+	}
+	return "| client " + client
+}
+
+// serveAPI serves the JSON endpoints under APIPrefix
+func (sh *SigningHandler) serveAPI(w http.ResponseWriter, r *http.Request, client string) {
+	var result interface{}
+	var err error
+	switch strings.TrimPrefix(r.URL.Path, APIPrefix) {
+	case "transfer":
+		req := &TransferRequest{}
+		if err = decodeJSON(r, req); err == nil {
+			if len(req.CoinPrefix) == 0 {
+				err = badRequest(CodeMissingField, "coinPrefix", "Missing coin prefix.")
+			} else {
+				result, err = sh.transfer(client, req)
+			}
+		}
+	case "sign":
+		req := &SignRequest{}
+		if err = decodeJSON(r, req); err == nil {
+			result, err = sh.sign(client, req)
+		}
+	case "sign/batch":
+		req := &BatchSignRequest{}
+		if err = decodeJSON(r, req); err == nil {
+			result, err = sh.signBatch(client, req)
+		}
+	case "signmessage":
+		req := &SignMessageRequest{}
+		if err = decodeJSON(r, req); err == nil {
+			result, err = sh.signMessage(client, req)
+		}
+	case "admin/unlock":
+		req := &UnlockRequest{}
+		if err = decodeJSON(r, req); err == nil {
+			result, err = sh.unlock(client, req)
+		}
+	case "admin/shares":
+		req := &ShareRequest{}
+		if err = decodeJSON(r, req); err == nil {
+			result, err = sh.submitShare(client, req)
+		}
+	case "admin/lock":
+		if r.Method != "POST" {
+			err = apiError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed.", "")
+		} else {
+			result, err = sh.lock(client)
+		}
+	case "admin/freeze", "admin/unfreeze":
+		if r.Method != "POST" {
+			err = apiError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed.", "")
+		} else {
+			result, err = sh.setFrozen(client, r.URL.Path == APIPrefix+"admin/freeze")
+		}
+	default:
+		path := strings.TrimPrefix(r.URL.Path, APIPrefix)
+		if path == "admin/keys" || strings.HasPrefix(path, "admin/keys/") {
+			result, err = sh.serveAdminKeys(r, client, path)
+		} else {
+			err = apiError(http.StatusNotFound, CodeNotFound, "Not found.", "")
+		}
+	}
+
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// decodeJSON reads a POST request body into v, unknown fields are refused
+func decodeJSON(r *http.Request, v interface{}) error {
+	if r.Method != "POST" {
+		return apiError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed.", "")
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if bodyTooLarge(err) {
+		return errBodyTooLarge()
+	}
+	if err != nil {
+		return badRequest(CodeBadRequest, "", "Invalid JSON body: "+err.Error()+".")
+	}
+	return nil
+}
+
+// toAPIError maps the errors of the hold to API errors
+func toAPIError(err error) *APIError {
+	var apiErr *APIError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.Is(err, ErrUnknownAddress):
+		return apiError(http.StatusNotFound, CodeUnknownAddress, err.Error(), "sourceAddr")
+	case errors.Is(err, ErrChallengeFailed):
+		return apiError(http.StatusUnprocessableEntity, CodeChallengeFailed, err.Error(), "txData")
+	case errors.Is(err, ErrMessageSigningDisabled):
+		return apiError(http.StatusForbidden, CodeMessagesDisabled, err.Error(), "sourceAddr")
+	case errors.Is(err, ErrInvalidInput):
+		return apiError(http.StatusBadRequest, CodeInvalidField, err.Error(), "inputIndex")
+	case errors.Is(err, ErrRequestConflict):
+		return apiError(http.StatusConflict, CodeRequestConflict, err.Error(), "requestId")
+	case errors.Is(err, ErrLocked):
+		return apiError(http.StatusServiceUnavailable, CodeSignerLocked, err.Error(), "")
+	case errors.Is(err, ErrWrongPassword):
+		return apiError(http.StatusForbidden, CodeWrongPassword, err.Error(), "password")
+	case errors.Is(err, ErrWrongShares):
+		return apiError(http.StatusForbidden, CodeWrongShares, err.Error(), "share")
+	case errors.Is(err, ErrShareSubmitted):
+		return apiError(http.StatusConflict, CodeShareSubmitted, err.Error(), "share")
+	case errors.Is(err, ErrNotSplit):
+		return apiError(http.StatusConflict, CodeNotSplit, err.Error(), "")
+	case errors.Is(err, ErrSplit):
+		return apiError(http.StatusConflict, CodeSplit, err.Error(), "")
+	case errors.Is(err, ErrNotInitialized):
+		return apiError(http.StatusConflict, CodeNotInitialized, err.Error(), "")
+	case errors.Is(err, ErrSignerFrozen):
+		return apiError(http.StatusLocked, CodeSignerFrozen, err.Error(), "")
+	case errors.Is(err, ErrKeyFrozen):
+		return apiError(http.StatusLocked, CodeKeyFrozen, err.Error(), "sourceAddr")
+	case errors.Is(err, ErrKeyDeleted):
+		return apiError(http.StatusGone, CodeKeyDeleted, err.Error(), "sourceAddr")
+	case errors.Is(err, ErrInvalidTransition):
+		return apiError(http.StatusConflict, CodeInvalidTransition, err.Error(), "state")
+	}
+	return apiError(http.StatusInternalServerError, CodeInternal, err.Error(), "")
+}
+
+func writeAPIError(w http.ResponseWriter, err error) {
+	apiErr := toAPIError(err)
+	writeJSON(w, apiErr.Status, &ErrorResponse{Error: apiErr})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
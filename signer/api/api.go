@@ -0,0 +1,145 @@
+// Package api holds the wire types, error codes and request signing of the JSON API of the
+// signer, shared by the signer and its clients without the signing code and its dependencies.
+package api
+
+// Prefix is the path prefix of the JSON API
+const Prefix = "/v1/"
+
+// Version of the signer, set at build time with -ldflags "-X .../signer/api.Version=..."
+var Version = "dev"
+
+// Error is an error reported to API clients: HTTP status, machine readable code, message and
+// the request field that failed, if any
+type Error struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Error codes of the API
+const (
+	CodeBadRequest        = "bad_request"
+	CodeMissingField      = "missing_field"
+	CodeInvalidField      = "invalid_field"
+	CodeInvalidAddress    = "invalid_address"
+	CodeUnknownNetwork    = "unknown_network"
+	CodeUnknownAddress    = "unknown_address"
+	CodeChallengeFailed   = "challenge_failed"
+	CodeMessagesDisabled  = "message_signing_disabled"
+	CodeUnauthenticated   = "unauthenticated"
+	CodeForbidden         = "forbidden"
+	CodeTooLarge          = "request_too_large"
+	CodeNotFound          = "not_found"
+	CodeMethodNotAllowed  = "method_not_allowed"
+	CodeInternal          = "internal_error"
+	CodeRequestConflict   = "request_conflict"
+	CodeKeyFrozen         = "key_frozen"
+	CodeKeyDeleted        = "key_deleted"
+	CodeInvalidTransition = "invalid_transition"
+	CodeSignerFrozen      = "signer_frozen"
+	CodeSignerLocked      = "signer_locked"
+	CodeWrongPassword     = "wrong_password"
+	CodeWrongShares       = "wrong_shares"
+	CodeShareSubmitted    = "share_submitted"
+	CodeNotSplit          = "not_split"
+	CodeNotInitialized    = "not_initialized"
+	CodeSplit             = "master_key_split"
+)
+
+// TransferRequest asks for a new source address paying only to the target (and fee) address
+type TransferRequest struct {
+	CoinPrefix    string `json:"coinPrefix"`
+	TargetAddr    string `json:"targetAddr"`
+	FeeAddr       string `json:"feeAddr,omitempty"`
+	AllowMessages bool   `json:"allowMessages,omitempty"`
+	// RequestID optionally makes the transfer idempotent, a retry with the same ID and
+	// parameters gets the same address back
+	RequestID string `json:"requestId,omitempty"`
+	// AddressType optionally selects the address of the new key, p2pkh (default) or p2wpkh on
+	// networks with segwit
+	AddressType string `json:"addressType,omitempty"`
+	// Prefix is the raw P2PKH version byte sent by legacy form clients
+	Prefix string `json:"-"`
+}
+
+// TransferResponse holds the new source address
+type TransferResponse struct {
+	Address    string `json:"address"`
+	CoinPrefix string `json:"coinPrefix"`
+}
+
+// SignRequest asks for the signature of hex encoded transaction data by a source address. The input
+// index and amount of the spent output are only required by some coins.
+type SignRequest struct {
+	SourceAddr string  `json:"sourceAddr"`
+	TxData     string  `json:"txData"`
+	InputIndex *int    `json:"inputIndex,omitempty"`
+	Amount     *uint64 `json:"amount,omitempty"`
+}
+
+// SignResponse holds the hex encoded signature and public key
+type SignResponse struct {
+	Signature string `json:"signature"`
+	PublicKey string `json:"publicKey"`
+}
+
+// BatchSignRequest asks for the signatures of inputs of a whole unsigned transaction
+type BatchSignRequest struct {
+	TxData string               `json:"txData"`
+	Inputs []*BatchInputRequest `json:"inputs"`
+}
+
+// BatchInputRequest is an input to sign with the key of a source address. The output it spends
+// is optionally checked against the input, its amount is only required by some coins.
+type BatchInputRequest struct {
+	InputIndex int     `json:"inputIndex"`
+	SourceAddr string  `json:"sourceAddr"`
+	Prevout    string  `json:"prevout,omitempty"`
+	Amount     *uint64 `json:"amount,omitempty"`
+}
+
+// BatchSignResponse holds the signatures, in the order of the inputs
+type BatchSignResponse struct {
+	Signatures []*InputSignature `json:"signatures"`
+}
+
+// InputSignature is the hex encoded signature and public key of an input
+type InputSignature struct {
+	InputIndex int    `json:"inputIndex"`
+	SourceAddr string `json:"sourceAddr"`
+	Signature  string `json:"signature"`
+	PublicKey  string `json:"publicKey"`
+}
+
+// SignMessageRequest asks for the signature of a message by a source address
+type SignMessageRequest struct {
+	SourceAddr string `json:"sourceAddr"`
+	Message    string `json:"message"`
+}
+
+// SignMessageResponse holds the base64 encoded message signature
+type SignMessageResponse struct {
+	Signature string `json:"signature"`
+}
+
+// ErrorResponse is the body of API error responses
+type ErrorResponse struct {
+	Error *Error `json:"error"`
+}
+
+// StatusResponse is the body of /status
+type StatusResponse struct {
+	Version       string   `json:"version"`
+	UptimeSeconds int64    `json:"uptimeSeconds"`
+	CoinFamilies  []string `json:"coinFamilies"`
+	CoinPrefixes  []string `json:"coinPrefixes"`
+	StoreBackend  string   `json:"storeBackend"`
+	Ready         bool     `json:"ready"`
+	Frozen        bool     `json:"frozen"`
+	Keys          int      `json:"keys"`
+}
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Headers of HMAC signed requests
+const (
+	HeaderKeyID     = "X-Signer-Key-Id"
+	HeaderTimestamp = "X-Signer-Timestamp"
+	HeaderNonce     = "X-Signer-Nonce"
+	HeaderSignature = "X-Signer-Signature"
+)
+
+// SignHMAC returns the hex encoded signature of a request, the HMAC-SHA256 with the secret of
+// its method, request URI, timestamp, nonce and hex encoded body SHA-256, newline separated
+func SignHMAC(secret []byte, method, uri, timestamp, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method + "\n" + uri + "\n" + timestamp + "\n" + nonce + "\n"))
+	mac.Write([]byte(hex.EncodeToString(bodyHash[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,878 @@
+package signer
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+
+	"github.com/blockcypher/cryptosigner/signer/api"
+	"github.com/blockcypher/cryptosigner/signer/bitcoin"
+	"github.com/blockcypher/cryptosigner/util"
+)
+
+func apiPost(t *testing.T, sh *SigningHandler, path, body string, v interface{}) int {
+	return apiPostAs(t, sh, "", path, body, v)
+}
+
+// apiPostAs posts as a client authenticated by a certificate with the common name
+func apiPostAs(t *testing.T, sh *SigningHandler, client, path, body string, v interface{}) int {
+	return serveAs(t, sh, client, httptest.NewRequest("POST", path, strings.NewReader(body)), v)
+}
+
+// serveAs serves a request of a client authenticated by a certificate with the common name
+func serveAs(t *testing.T, sh *SigningHandler, client string, req *http.Request, v interface{}) int {
+	if len(client) > 0 {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: client}}
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	}
+	rec := httptest.NewRecorder()
+	sh.ServeHTTP(rec, req)
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatal(err, rec.Body.String())
+		}
+	}
+	return rec.Code
+}
+
+func TestAPITransferAndSign(t *testing.T) {
+	sh := &SigningHandler{hold: testHold()}
+
+	transfer := &TransferResponse{}
+	status := apiPost(t, sh, "/v1/transfer", `{"coinPrefix":"btc","targetAddr":"`+ADDR1+`"}`, transfer)
+	if status != http.StatusOK || len(transfer.Address) == 0 || transfer.CoinPrefix != "btc" {
+		t.Fatal("Transfer failed:", status)
+	}
+
+	errResp := &ErrorResponse{}
+	status = apiPost(t, sh, "/v1/sign", `{"sourceAddr":"`+transfer.Address+`","txData":"`+TxData2+`"}`, errResp)
+	if status != http.StatusUnprocessableEntity || errResp.Error.Code != CodeChallengeFailed {
+		t.Error("Challenge should have failed:", status)
+	}
+
+	sign := &SignResponse{}
+	status = apiPost(t, sh, "/v1/sign", `{"sourceAddr":"`+transfer.Address+`","txData":"`+TxData1+`"}`, sign)
+	if status != http.StatusOK || len(sign.Signature) < 100 || len(sign.PublicKey) != 66 {
+		t.Error("Sign failed:", status)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	sh := &SigningHandler{hold: testHold()}
+	tests := []struct {
+		path, body string
+		status     int
+		code       string
+		field      string
+	}{
+		{"/v1/transfer", `{"targetAddr":"` + ADDR1 + `"}`, 400, CodeMissingField, "coinPrefix"},
+		{"/v1/transfer", `{"coinPrefix":"nope","targetAddr":"` + ADDR1 + `"}`, 400, CodeUnknownNetwork, "coinPrefix"},
+		{"/v1/transfer", `{"coinPrefix":"ltc","targetAddr":"` + ADDR1 + `"}`, 400, CodeInvalidAddress, "targetAddr"},
+		{"/v1/transfer", `{"coinPrefix":"btc","target":"x"}`, 400, CodeBadRequest, ""},
+		{"/v1/transfer", `{"coinPrefix":"doge","targetAddr":"DH5yaieqoZN36fDVciNyRueRGvGLR3mr7L","addressType":"p2wpkh"}`, 400, CodeInvalidField, "addressType"},
+		{"/v1/sign", `{"sourceAddr":"` + ADDR1 + `"}`, 400, CodeMissingField, "txData"},
+		{"/v1/sign", `{"sourceAddr":"` + ADDR1 + `","txData":"zz"}`, 400, CodeInvalidField, "txData"},
+		{"/v1/sign", `{"sourceAddr":"` + ADDR1 + `","txData":"00"}`, 404, CodeUnknownAddress, "sourceAddr"},
+		{"/v1/nope", `{}`, 404, CodeNotFound, ""},
+	}
+	for _, test := range tests {
+		errResp := &ErrorResponse{}
+		status := apiPost(t, sh, test.path, test.body, errResp)
+		if status != test.status || errResp.Error.Code != test.code || errResp.Error.Field != test.field {
+			t.Errorf("%s %s: got %d %+v", test.path, test.body, status, errResp.Error)
+		}
+	}
+}
+
+func TestFormTransfer(t *testing.T) {
+	sh := &SigningHandler{hold: testHold()}
+	req := httptest.NewRequest("POST", "/transfer", strings.NewReader("coinPrefix=btc&targetAddr="+ADDR1))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	sh.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Body.String(), "1") {
+		t.Error("Form transfer failed:", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest("POST", "/transfer", strings.NewReader("coinPrefix=nope&targetAddr="+ADDR1))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	sh.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest || rec.Body.String() != "Unknown coin prefix." {
+		t.Error("Unexpected form error:", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAPIAuthorization(t *testing.T) {
+	sh := &SigningHandler{hold: testHold(), auth: AuthTable{
+		"payments": {Operations: []Operation{OpTransfer, OpSign}, CoinPrefixes: []string{"btc"}},
+		"ops":      {Operations: []Operation{OpSign}, CoinPrefixes: []string{AnyCoinPrefix}},
+	}}
+	transferBody := `{"coinPrefix":"btc","targetAddr":"` + ADDR1 + `"}`
+
+	errResp := &ErrorResponse{}
+	if status := apiPost(t, sh, "/v1/transfer", transferBody, errResp); status != http.StatusUnauthorized ||
+		errResp.Error.Code != CodeUnauthenticated {
+		t.Error("Unauthenticated transfer should have failed:", status)
+	}
+	if status := apiPostAs(t, sh, "ops", "/v1/transfer", transferBody, errResp); status != http.StatusForbidden ||
+		errResp.Error.Code != CodeForbidden {
+		t.Error("Transfer should not be allowed:", status)
+	}
+	ltcBody := `{"coinPrefix":"ltc","targetAddr":"LM2WMpR1Rp6j3Sa59cMXMs1SPzj9eXpGc1"}`
+	if status := apiPostAs(t, sh, "payments", "/v1/transfer", ltcBody, errResp); status != http.StatusForbidden {
+		t.Error("Transfer on ltc should not be allowed:", status)
+	}
+
+	transfer := &TransferResponse{}
+	if status := apiPostAs(t, sh, "payments", "/v1/transfer", transferBody, transfer); status != http.StatusOK {
+		t.Fatal("Transfer failed:", status)
+	}
+	signBody := `{"sourceAddr":"` + transfer.Address + `","txData":"` + TxData1 + `"}`
+	for _, client := range []string{"payments", "ops"} {
+		if status := apiPostAs(t, sh, client, "/v1/sign", signBody, &SignResponse{}); status != http.StatusOK {
+			t.Error("Sign failed for", client, status)
+		}
+	}
+	if status := apiPostAs(t, sh, "unknown", "/v1/sign", signBody, errResp); status != http.StatusForbidden {
+		t.Error("Sign should not be allowed:", status)
+	}
+}
+
+func TestAPIAdminWithoutTable(t *testing.T) {
+	sh := &SigningHandler{hold: testHold()}
+	errResp := &ErrorResponse{}
+	for _, path := range []string{"/v1/admin/freeze", "/v1/admin/unfreeze", "/v1/admin/lock"} {
+		if status := apiPost(t, sh, path, "", errResp); status != http.StatusUnauthorized ||
+			errResp.Error.Code != CodeUnauthenticated {
+			t.Error(path, "should require a client identity:", status)
+		}
+	}
+	if status := apiPost(t, sh, "/v1/admin/unlock", `{"password":"test"}`, errResp); status != http.StatusUnauthorized {
+		t.Error("Unlock should require a client identity:", status)
+	}
+	if status := apiGet(t, sh, "", "/v1/admin/keys", errResp); status != http.StatusUnauthorized {
+		t.Error("Key listing should require a client identity:", status)
+	}
+	if status := apiGet(t, sh, "ops", "/v1/admin/keys", &KeyListResponse{}); status != http.StatusOK {
+		t.Error("Key listing failed for an identified client:", status)
+	}
+}
+
+func hmacRequest(secret []byte, keyID, path, body, nonce string, at time.Time) *http.Request {
+	req := httptest.NewRequest("POST", path, strings.NewReader(body))
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	req.Header.Set(HeaderKeyID, keyID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderNonce, nonce)
+	req.Header.Set(HeaderSignature, SignHMAC(secret, "POST", path, timestamp, nonce, []byte(body)))
+	return req
+}
+
+func TestAPIHMAC(t *testing.T) {
+	secret := bytes.Repeat([]byte{7}, 32)
+	hmacAuth := NewHMACAuth(map[string][]byte{"payments": secret}, DefaultHMACWindow)
+	now := time.Unix(1700000000, 0)
+	hmacAuth.now = func() time.Time { return now }
+	sh := &SigningHandler{hold: testHold(), hmac: hmacAuth, auth: AuthTable{
+		"payments": {Operations: []Operation{OpTransfer}, CoinPrefixes: []string{"btc"}},
+	}}
+	body := `{"coinPrefix":"btc","targetAddr":"` + ADDR1 + `"}`
+
+	tests := []struct {
+		req    *http.Request
+		status int
+	}{
+		{hmacRequest(secret, "payments", "/v1/transfer", body, "nonce-0001", now), http.StatusOK},
+		{hmacRequest(secret, "payments", "/v1/transfer", body, "nonce-0001", now), http.StatusUnauthorized},
+		{hmacRequest(secret, "payments", "/v1/transfer", body, "nonce-0002", now.Add(-10*time.Minute)), http.StatusUnauthorized},
+		{hmacRequest(secret, "payments", "/v1/transfer", body, "nonce-0003", now.Add(10*time.Minute)), http.StatusUnauthorized},
+		{hmacRequest(secret, "other", "/v1/transfer", body, "nonce-0004", now), http.StatusUnauthorized},
+		{hmacRequest(bytes.Repeat([]byte{8}, 32), "payments", "/v1/transfer", body, "nonce-0005", now), http.StatusUnauthorized},
+		{hmacRequest(secret, "payments", "/v1/sign", `{"sourceAddr":"x","txData":"00"}`, "nonce-0006", now), http.StatusForbidden},
+		{hmacRequest(secret, "payments", "/v1/transfer", body, "nonce-0007", now.Add(4*time.Minute)), http.StatusOK},
+	}
+	for n, test := range tests {
+		rec := httptest.NewRecorder()
+		sh.ServeHTTP(rec, test.req)
+		if rec.Code != test.status {
+			t.Errorf("Request %d: got %d, expected %d %s", n, rec.Code, test.status, rec.Body.String())
+		}
+	}
+
+	// body changed after signing
+	req := hmacRequest(secret, "payments", "/v1/transfer", body, "nonce-0008", now)
+	req.Body = ioutil.NopCloser(strings.NewReader(strings.Replace(body, "btc", "bcy", 1)))
+	rec := httptest.NewRecorder()
+	sh.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Error("Tampered body should have failed:", rec.Code)
+	}
+}
+
+func TestAPIBodyTooLarge(t *testing.T) {
+	sh := &SigningHandler{hold: testHold(), maxBody: 64}
+	errResp := &ErrorResponse{}
+	body := `{"coinPrefix":"btc","targetAddr":"` + ADDR1 + `","feeAddr":"` + ADDR2 + `"}`
+	if status := apiPost(t, sh, "/v1/transfer", body, errResp); status != http.StatusRequestEntityTooLarge ||
+		errResp.Error.Code != CodeTooLarge {
+		t.Error("Large body should have failed:", status)
+	}
+
+	// without content length
+	req := httptest.NewRequest("POST", "/v1/transfer", ioutil.NopCloser(strings.NewReader(body)))
+	req.ContentLength = -1
+	rec := httptest.NewRecorder()
+	sh.ServeHTTP(rec, req)
+	errResp = &ErrorResponse{}
+	json.Unmarshal(rec.Body.Bytes(), errResp)
+	if rec.Code != http.StatusRequestEntityTooLarge || errResp.Error == nil || errResp.Error.Code != CodeTooLarge {
+		t.Error("Large body should have failed:", rec.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/transfer", ioutil.NopCloser(strings.NewReader("coinPrefix=btc&targetAddr="+ADDR1+"&feeAddr="+ADDR2)))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.ContentLength = -1
+	rec = httptest.NewRecorder()
+	sh.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Error("Large form should have failed:", rec.Code)
+	}
+}
+
+func TestShutdownDrains(t *testing.T) {
+	store := MakeTestStore()
+	hold, _ := MakeHold([]byte("test"), store)
+	started, release := make(chan bool), make(chan bool)
+	httpServer := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		<-release
+		w.Write([]byte("done"))
+	})}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go httpServer.Serve(ln)
+
+	body := make(chan string)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		body <- string(data)
+	}()
+	<-started
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		release <- true
+	}()
+	if err := shutdown(httpServer, hold, 5*time.Second); err != nil {
+		t.Error(err)
+	}
+	if b := <-body; b != "done" {
+		t.Error("In-flight request not completed:", b)
+	}
+	if store.flushes != 1 {
+		t.Error("Store not flushed.")
+	}
+}
+
+type unreachableStore struct {
+	*TestStore
+}
+
+func (us *unreachableStore) Ping() error {
+	return errors.New("store unreachable")
+}
+
+func apiGet(t *testing.T, sh *SigningHandler, client, path string, v interface{}) int {
+	return serveAs(t, sh, client, httptest.NewRequest("GET", path, nil), v)
+}
+
+func TestHealth(t *testing.T) {
+	sh := NewSigningHandler(testHold(), &ServerConfig{Auth: AuthTable{
+		"ops": {Operations: []Operation{OpTransfer}, CoinPrefixes: []string{"btc"}},
+	}})
+	transfer := &TransferResponse{}
+	apiPostAs(t, sh, "ops", "/v1/transfer", `{"coinPrefix":"btc","targetAddr":"`+ADDR1+`"}`, transfer)
+
+	if status := apiGet(t, sh, "", "/healthz", nil); status != http.StatusOK {
+		t.Error("Unexpected health status:", status)
+	}
+	ready := &ReadyResponse{}
+	if status := apiGet(t, sh, "", "/readyz", ready); status != http.StatusOK || !ready.Ready || ready.Keys != 1 {
+		t.Errorf("Unexpected readiness: %d %+v", status, ready)
+	}
+
+	if status := apiGet(t, sh, "", "/status", &ErrorResponse{}); status != http.StatusUnauthorized {
+		t.Error("Status should require authentication:", status)
+	}
+	if status := apiGet(t, sh, "unknown", "/status", &ErrorResponse{}); status != http.StatusForbidden {
+		t.Error("Status should require a known client:", status)
+	}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/status", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+		{Subject: pkix.Name{CommonName: "ops"}}}}}
+	sh.ServeHTTP(rec, req)
+	status := &StatusResponse{}
+	json.Unmarshal(rec.Body.Bytes(), status)
+	if rec.Code != http.StatusOK || status.Version != api.Version || status.Keys != 1 || status.StoreBackend != "custom" ||
+		len(status.CoinFamilies) != 4 {
+		t.Errorf("Unexpected status: %d %+v", rec.Code, status)
+	}
+	if strings.Contains(rec.Body.String(), transfer.Address) {
+		t.Error("Status exposes addresses.")
+	}
+
+	// without authorization table, any authenticated client
+	sh = &SigningHandler{hold: testHold()}
+	if status := apiGet(t, sh, "", "/status", &ErrorResponse{}); status != http.StatusUnauthorized {
+		t.Error("Status should require authentication without authorization table:", status)
+	}
+	if status := apiGet(t, sh, "ops", "/status", &StatusResponse{}); status != http.StatusOK {
+		t.Error("Unexpected status:", status)
+	}
+
+	hold, _ := MakeHold([]byte("test"), &unreachableStore{MakeTestStore()})
+	sh = &SigningHandler{hold: hold}
+	if status := apiGet(t, sh, "", "/readyz", ready); status != http.StatusServiceUnavailable || ready.Ready ||
+		ready.StoreError != "store unreachable" {
+		t.Errorf("Unexpected readiness: %d %+v", status, ready)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	sh := NewSigningHandler(testHold(), &ServerConfig{})
+	transfer := &TransferResponse{}
+	apiPost(t, sh, "/v1/transfer", `{"coinPrefix":"btc","targetAddr":"`+ADDR1+`"}`, transfer)
+	apiPost(t, sh, "/v1/sign", `{"sourceAddr":"`+transfer.Address+`","txData":"`+TxData1+`"}`, nil)
+	apiPost(t, sh, "/v1/sign", `{"sourceAddr":"`+transfer.Address+`","txData":"`+TxData2+`"}`, nil)
+	apiPost(t, sh, "/v1/sign", `{"sourceAddr":"`+ADDR2+`","txData":"`+TxData2+`"}`, nil)
+	apiPost(t, sh, "/v1/sign", `{"sourceAddr":"`+transfer.Address+`","txData":"zz"}`, nil)
+
+	rec := httptest.NewRecorder()
+	sh.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	metrics := rec.Body.String()
+	for _, line := range []string{
+		`signer_requests_total{operation="transfer",coin_family="bitcoin",outcome="created"} 1`,
+		`signer_requests_total{operation="sign",coin_family="bitcoin",outcome="signed"} 1`,
+		`signer_requests_total{operation="sign",coin_family="bitcoin",outcome="challenge_failed"} 1`,
+		`signer_requests_total{operation="sign",coin_family="unknown",outcome="unknown_address"} 1`,
+		`signer_requests_total{operation="sign",coin_family="bitcoin",outcome="bad_request"} 1`,
+		`signer_request_duration_seconds_bucket{operation="sign",coin_family="bitcoin",le="+Inf"} 3`,
+		`signer_request_duration_seconds_count{operation="transfer",coin_family="bitcoin"} 1`,
+		`signer_keys_loaded 1`,
+	} {
+		if !strings.Contains(metrics, line+"\n") {
+			t.Error("Missing metric:", line)
+		}
+	}
+	if strings.Contains(metrics, "signer_store_size_bytes 0\n") {
+		t.Error("Store size not updated.")
+	}
+}
+
+func TestMetricsWithoutCollection(t *testing.T) {
+	sh := &SigningHandler{hold: testHold()}
+	rec := httptest.NewRecorder()
+	sh.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "signer_keys_loaded 0\n") ||
+		!strings.Contains(rec.Body.String(), "signer_locked 0\n") {
+		t.Error("Gauges not written:", rec.Code, rec.Body.String())
+	}
+}
+
+func TestMetricsDecryptError(t *testing.T) {
+	store := MakeTestStore()
+	hold, _ := MakeHold([]byte("test"), store)
+	addr, err := hold.NewKey(NewSignatureChallenge([]string{ADDR1}, BitcoinFamily), btcNetwork(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MakeHold([]byte("wrong"), store); !errors.Is(err, ErrWrongPassword) {
+		t.Error("Wrong password should have failed:", err)
+	}
+	hold.lookup(addr).encryptedPrivate = make([]byte, 64)
+	_, _, err = hold.Sign(addr, mustDecodeHex(TxData1))
+	if requestOutcome(OutcomeSigned, err) != OutcomeDecryptError {
+		t.Error("Unexpected outcome for", err)
+	}
+}
+
+func mustDecodeHex(s string) []byte {
+	data, _ := hex.DecodeString(s)
+	return data
+}
+
+func TestAPISignBatch(t *testing.T) {
+	sh := &SigningHandler{hold: testHold()}
+	transfer := &TransferResponse{}
+	apiPost(t, sh, "/v1/transfer", `{"coinPrefix":"btc","targetAddr":"`+ADDR1+`"}`, transfer)
+
+	// TxData1 with its input script emptied
+	tx, _ := bitcoin.ParseTx(mustDecodeHex(TxData1))
+	tx.Inputs[0].Script = nil
+	body := `{"txData":"` + hex.EncodeToString(tx.Bytes()) + `","inputs":[{"inputIndex":0,"sourceAddr":"` +
+		transfer.Address + `","prevout":"` + tx.Outpoint(0) + `"}]}`
+	batch := &BatchSignResponse{}
+	if status := apiPost(t, sh, "/v1/sign/batch", body, batch); status != http.StatusOK || len(batch.Signatures) != 1 {
+		t.Fatal("Batch failed:", status)
+	}
+
+	// the legacy digest, with the script code of the key in place
+	sig, _ := hex.DecodeString(batch.Signatures[0].Signature)
+	parsed, _ := ecdsa.ParseDERSignature(sig)
+	pub, _ := hex.DecodeString(batch.Signatures[0].PublicKey)
+	pubkey, _ := btcec.ParsePubKey(pub)
+	tx.Inputs[0].Script = bitcoin.P2PKHScript(util.Hash160(pub))
+	if !parsed.Verify(util.DoubleHash(append(tx.Bytes(), 1, 0, 0, 0)), pubkey) {
+		t.Error("Invalid batch signature.")
+	}
+	tx.Inputs[0].Script = nil
+
+	errResp := &ErrorResponse{}
+	body = `{"txData":"` + hex.EncodeToString(tx.Bytes()) + `","inputs":[]}`
+	if status := apiPost(t, sh, "/v1/sign/batch", body, errResp); status != http.StatusBadRequest ||
+		errResp.Error.Field != "inputs" {
+		t.Error("Empty batch should have failed:", status)
+	}
+
+	input := `{"inputIndex":0,"sourceAddr":"` + transfer.Address + `","prevout":"` + tx.Outpoint(0) + `"}`
+	for _, inputs := range []string{
+		input + `,` + input,
+		`{"inputIndex":0,"sourceAddr":"` + transfer.Address + `","prevout":"` + strings.Repeat("11", 32) + `:0"}`,
+	} {
+		errResp = &ErrorResponse{}
+		body = `{"txData":"` + hex.EncodeToString(tx.Bytes()) + `","inputs":[` + inputs + `]}`
+		if status := apiPost(t, sh, "/v1/sign/batch", body, errResp); status != http.StatusBadRequest ||
+			errResp.Error.Code != CodeInvalidField || errResp.Error.Field != "inputs" {
+			t.Error("Invalid batch should have failed:", status, errResp.Error)
+		}
+	}
+}
+
+func TestAPIKeyInventory(t *testing.T) {
+	store := MakeTestStore()
+	hold, _ := MakeHold([]byte("test"), store)
+	sh := &SigningHandler{hold: hold, auth: AuthTable{
+		"ops":      {Operations: []Operation{OpTransfer, OpSign, OpAdmin}, CoinPrefixes: []string{AnyCoinPrefix}},
+		"btcadmin": {Operations: []Operation{OpAdmin}, CoinPrefixes: []string{"btc"}},
+		"payments": {Operations: []Operation{OpTransfer}, CoinPrefixes: []string{AnyCoinPrefix}},
+	}}
+	var btcAddrs []string
+	for i := 0; i < 3; i++ {
+		transfer := &TransferResponse{}
+		apiPostAs(t, sh, "ops", "/v1/transfer", `{"coinPrefix":"btc","targetAddr":"`+ADDR1+`"}`, transfer)
+		btcAddrs = append(btcAddrs, transfer.Address)
+	}
+	apiPostAs(t, sh, "ops", "/v1/transfer", `{"coinPrefix":"btc","targetAddr":"`+ADDR2+`"}`, nil)
+	apiPostAs(t, sh, "ops", "/v1/transfer", `{"coinPrefix":"eth","targetAddr":"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"}`, nil)
+	apiPostAs(t, sh, "ops", "/v1/sign", `{"sourceAddr":"`+btcAddrs[0]+`","txData":"`+TxData1+`"}`, nil)
+
+	info := &KeyInfo{}
+	if status := apiGet(t, sh, "ops", "/v1/admin/keys/"+btcAddrs[0], info); status != http.StatusOK {
+		t.Fatal("Key lookup failed:", status)
+	}
+	if info.CoinFamily != "bitcoin" || info.CoinPrefix != "btc" || info.State != StateActive || info.Uses != 1 ||
+		info.Created == nil || len(info.Challenge.Addresses) != 1 || info.Challenge.Addresses[0] != ADDR1 {
+		t.Errorf("Unexpected key info: %+v", info)
+	}
+	// the use count is persisted
+	reloaded, _ := MakeHold([]byte("test"), store)
+	if reloadedInfo, _ := reloaded.KeyInfo(btcAddrs[0]); reloadedInfo.Uses != 1 || !reloadedInfo.Created.Equal(*info.Created) {
+		t.Errorf("Unexpected reloaded key info: %+v", reloadedInfo)
+	}
+
+	list := &KeyListResponse{}
+	apiGet(t, sh, "ops", "/v1/admin/keys", list)
+	if len(list.Keys) != 5 || len(list.Next) > 0 {
+		t.Error("Unexpected key list:", len(list.Keys), list.Next)
+	}
+	apiGet(t, sh, "ops", "/v1/admin/keys?target="+ADDR1+"&coinFamily=bitcoin&limit=2", list)
+	if len(list.Keys) != 2 || len(list.Next) == 0 {
+		t.Fatal("Unexpected first page:", len(list.Keys), list.Next)
+	}
+	page := &KeyListResponse{}
+	apiGet(t, sh, "ops", "/v1/admin/keys?target="+ADDR1+"&coinFamily=bitcoin&limit=2&after="+list.Next, page)
+	if len(page.Keys) != 1 || len(page.Next) > 0 || page.Keys[0].Address <= list.Keys[1].Address {
+		t.Error("Unexpected second page:", len(page.Keys), page.Next)
+	}
+	apiGet(t, sh, "btcadmin", "/v1/admin/keys", list)
+	if len(list.Keys) != 4 {
+		t.Error("Unexpected key list for btc admin:", len(list.Keys))
+	}
+	apiGet(t, sh, "ops", "/v1/admin/keys?createdAfter="+time.Now().Add(time.Hour).Format(time.RFC3339), list)
+	if len(list.Keys) != 0 {
+		t.Error("Unexpected key list in the future:", len(list.Keys))
+	}
+
+	var raw map[string][]map[string]interface{}
+	apiGet(t, sh, "ops", "/v1/admin/keys", &raw)
+	for field := range raw["keys"][0] {
+		if strings.Contains(strings.ToLower(field), "priv") || strings.Contains(strings.ToLower(field), "encrypted") {
+			t.Error("Key material exposed:", field)
+		}
+	}
+
+	errResp := &ErrorResponse{}
+	for path, status := range map[string]int{
+		"/v1/admin/keys?limit=0":           http.StatusBadRequest,
+		"/v1/admin/keys?coinFamily=nope":   http.StatusBadRequest,
+		"/v1/admin/keys?createdAfter=then": http.StatusBadRequest,
+		"/v1/admin/keys/" + ADDR2:          http.StatusNotFound,
+	} {
+		if got := apiGet(t, sh, "ops", path, errResp); got != status {
+			t.Error(path, "got", got)
+		}
+	}
+	if got := apiGet(t, sh, "payments", "/v1/admin/keys", errResp); got != http.StatusForbidden {
+		t.Error("Listing should require admin:", got)
+	}
+}
+
+func TestAPITransferRequestID(t *testing.T) {
+	store := MakeTestStore()
+	hold, _ := MakeHold([]byte("test"), store)
+	sh := &SigningHandler{hold: hold}
+	body := `{"coinPrefix":"btc","targetAddr":"` + ADDR1 + `","requestId":"order-42"}`
+
+	first, again := &TransferResponse{}, &TransferResponse{}
+	apiPost(t, sh, "/v1/transfer", body, first)
+	if status := apiPost(t, sh, "/v1/transfer", body, again); status != http.StatusOK || again.Address != first.Address {
+		t.Error("Retried transfer should return the same address:", status, first.Address, again.Address)
+	}
+	if hold.KeyCount() != 1 {
+		t.Error("Retried transfer created another key:", hold.KeyCount())
+	}
+
+	errResp := &ErrorResponse{}
+	status := apiPost(t, sh, "/v1/transfer", `{"coinPrefix":"btc","targetAddr":"`+ADDR2+`","requestId":"order-42"}`, errResp)
+	if status != http.StatusConflict || errResp.Error.Code != CodeRequestConflict || errResp.Error.Field != "requestId" {
+		t.Error("Conflicting request ID should have failed:", status, errResp.Error)
+	}
+	status = apiPost(t, sh, "/v1/transfer", `{"coinPrefix":"btc","targetAddr":"`+ADDR1+`","requestId":"order 42"}`, errResp)
+	if status != http.StatusBadRequest || errResp.Error.Field != "requestId" {
+		t.Error("Invalid request ID should have failed:", status, errResp.Error)
+	}
+
+	// the request ID is persisted with the key
+	sh = &SigningHandler{hold: mustMakeHold(t, store)}
+	if apiPost(t, sh, "/v1/transfer", body, again); again.Address != first.Address {
+		t.Error("Request ID not persisted:", first.Address, again.Address)
+	}
+	form := httptest.NewRequest("POST", "/transfer", strings.NewReader("coinPrefix=btc&targetAddr="+ADDR1+"&requestId=order-42"))
+	form.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	sh.ServeHTTP(rec, form)
+	if rec.Code != http.StatusOK || rec.Body.String() != first.Address {
+		t.Error("Retried form transfer should return the same address:", rec.Code, rec.Body.String())
+	}
+}
+
+func mustMakeHold(t *testing.T, store Store) *Hold {
+	hold, err := MakeHold([]byte("test"), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hold
+}
+
+func TestAPIKeyState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	audit, err := OpenAuditLog(filepath.Join(dir, "audit.log"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+	sh := &SigningHandler{hold: testHold(), audit: audit, auth: AuthTable{
+		"ops":      {Operations: []Operation{OpTransfer, OpSign, OpAdmin}, CoinPrefixes: []string{AnyCoinPrefix}},
+		"ethadmin": {Operations: []Operation{OpAdmin}, CoinPrefixes: []string{"eth"}},
+	}}
+	transfer := &TransferResponse{}
+	apiPostAs(t, sh, "ops", "/v1/transfer", `{"coinPrefix":"btc","targetAddr":"`+ADDR1+`"}`, transfer)
+	signBody := `{"sourceAddr":"` + transfer.Address + `","txData":"` + TxData1 + `"}`
+	statePath := "/v1/admin/keys/" + transfer.Address + "/state"
+
+	if status := apiPostAs(t, sh, "ethadmin", statePath, `{"state":"frozen"}`, nil); status != http.StatusForbidden {
+		t.Error("Admin of another coin should not freeze the key:", status)
+	}
+	info := &KeyInfo{}
+	if status := apiPostAs(t, sh, "ops", statePath, `{"state":"frozen"}`, info); status != http.StatusOK || info.State != StateFrozen {
+		t.Fatal("Freeze failed:", status, info.State)
+	}
+	errResp := &ErrorResponse{}
+	if status := apiPostAs(t, sh, "ops", "/v1/sign", signBody, errResp); status != http.StatusLocked || errResp.Error.Code != CodeKeyFrozen {
+		t.Error("Frozen key should not sign:", status, errResp.Error)
+	}
+
+	apiPostAs(t, sh, "ops", statePath, `{"state":"deleted"}`, info)
+	if status := apiPostAs(t, sh, "ops", "/v1/sign", signBody, errResp); status != http.StatusGone || errResp.Error.Code != CodeKeyDeleted {
+		t.Error("Deleted key should not sign:", status, errResp.Error)
+	}
+	if status := apiPostAs(t, sh, "ops", statePath, `{"state":"retired"}`, errResp); status != http.StatusConflict || errResp.Error.Code != CodeInvalidTransition {
+		t.Error("Deleted key should not be retired:", status, errResp.Error)
+	}
+	if status := apiPostAs(t, sh, "ops", statePath, `{"state":"gone"}`, errResp); status != http.StatusBadRequest || errResp.Error.Field != "state" {
+		t.Error("Unknown state should have failed:", status, errResp.Error)
+	}
+	info = &KeyInfo{}
+	if status := apiPostAs(t, sh, "ops", statePath, `{"state":"active"}`, info); status != http.StatusOK || info.Deleted != nil {
+		t.Error("Restore failed:", status, info)
+	}
+	if status := apiPostAs(t, sh, "ops", "/v1/sign", signBody, nil); status != http.StatusOK {
+		t.Error("Restored key should sign:", status)
+	}
+
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "audit.log"))
+	if strings.Count(string(data), `"outcome":"state_changed"`) != 3 || !strings.Contains(string(data), `"state":"deleted"`) {
+		t.Error("State changes not audited:", string(data))
+	}
+}
+
+func TestAPIFreeze(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, _ := MakeFileStore(dir)
+	auth := AuthTable{
+		"ops":      {Operations: []Operation{OpTransfer, OpSign, OpAdmin}, CoinPrefixes: []string{AnyCoinPrefix}},
+		"security": {Operations: []Operation{OpUnfreeze}},
+	}
+	sh := &SigningHandler{hold: mustMakeHold(t, store), auth: auth}
+	transfer := &TransferResponse{}
+	apiPostAs(t, sh, "ops", "/v1/transfer", `{"coinPrefix":"btc","targetAddr":"`+ADDR1+`"}`, transfer)
+	signBody := `{"sourceAddr":"` + transfer.Address + `","txData":"` + TxData1 + `"}`
+
+	frozen := &FreezeResponse{}
+	if status := apiPostAs(t, sh, "ops", "/v1/admin/freeze", "", frozen); status != http.StatusOK || !frozen.Frozen {
+		t.Fatal("Freeze failed:", status)
+	}
+	errResp := &ErrorResponse{}
+	if status := apiPostAs(t, sh, "ops", "/v1/sign", signBody, errResp); status != http.StatusLocked || errResp.Error.Code != CodeSignerFrozen {
+		t.Error("Frozen signer should not sign:", status, errResp.Error)
+	}
+	if status := apiPostAs(t, sh, "ops", "/v1/transfer", `{"coinPrefix":"btc","targetAddr":"`+ADDR1+`"}`, nil); status != http.StatusOK {
+		t.Error("Frozen signer should create keys:", status)
+	}
+	if ready := sh.ready(); !ready.Ready || !ready.Frozen {
+		t.Error("Unexpected readiness:", ready)
+	}
+
+	// the freeze survives a restart, and only an unfreeze grant lifts it
+	sh = &SigningHandler{hold: mustMakeHold(t, store), auth: auth}
+	if status := apiPostAs(t, sh, "ops", "/v1/sign", signBody, nil); status != http.StatusLocked {
+		t.Error("Freeze not persisted:", status)
+	}
+	if status := apiPostAs(t, sh, "ops", "/v1/admin/unfreeze", "", nil); status != http.StatusForbidden {
+		t.Error("Admin should not unfreeze:", status)
+	}
+	if status := apiPostAs(t, sh, "security", "/v1/admin/unfreeze", "", frozen); status != http.StatusOK || frozen.Frozen {
+		t.Fatal("Unfreeze failed:", status)
+	}
+	if status := apiPostAs(t, sh, "ops", "/v1/sign", signBody, nil); status != http.StatusOK {
+		t.Error("Unfrozen signer should sign:", status)
+	}
+
+	sigs, stop := make(chan os.Signal, 1), make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		freezeOnSignal(sh.hold, nil, sigs, stop)
+		close(done)
+	}()
+	sigs <- os.Interrupt
+	for i := 0; i < 100 && !sh.hold.Frozen(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(stop)
+	<-done
+	if !sh.hold.Frozen() {
+		t.Error("Signal should have frozen the signer")
+	}
+}
+
+func TestAPILockUnlock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, _ := MakeFileStore(filepath.Join(dir, "store"))
+	addr, _ := mustMakeHold(t, store).NewKey(NewSignatureChallenge([]string{ADDR1}, BitcoinFamily), btcNetwork(), nil)
+	if _, err := MakeHold([]byte("wrong"), store); !errors.Is(err, ErrWrongPassword) {
+		t.Error("Wrong password should have been refused by the verifier:", err)
+	}
+
+	hold, err := MakeLockedHold(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditPath := filepath.Join(dir, "audit.log")
+	sh := &SigningHandler{hold: hold, audit: PendingAuditLog(auditPath, true), auth: AuthTable{
+		"ops":       {Operations: []Operation{OpSign, OpAdmin}, CoinPrefixes: []string{AnyCoinPrefix}},
+		"keyholder": {Operations: []Operation{OpUnlock}},
+	}}
+	signBody := `{"sourceAddr":"` + addr + `","txData":"` + TxData1 + `"}`
+
+	errResp := &ErrorResponse{}
+	if status := apiPostAs(t, sh, "ops", "/v1/sign", signBody, errResp); status != http.StatusServiceUnavailable || errResp.Error.Code != CodeSignerLocked {
+		t.Error("Locked signer should not sign:", status, errResp.Error)
+	}
+	if ready := sh.ready(); ready.Ready || ready.Unlocked {
+		t.Error("Locked signer should not be ready:", ready)
+	}
+	if status := apiPostAs(t, sh, "ops", "/v1/admin/unlock", `{"password":"test"}`, nil); status != http.StatusForbidden {
+		t.Error("Admin should not unlock:", status)
+	}
+	if status := apiPostAs(t, sh, "keyholder", "/v1/admin/unlock", `{"password":"wrong"}`, errResp); status != http.StatusForbidden || errResp.Error.Code != CodeWrongPassword {
+		t.Error("Wrong password should have failed:", status, errResp.Error)
+	}
+	lock := &LockResponse{}
+	if status := apiPostAs(t, sh, "keyholder", "/v1/admin/unlock", `{"password":"test"}`, lock); status != http.StatusOK || lock.Locked {
+		t.Fatal("Unlock failed:", status)
+	}
+	if status := apiPostAs(t, sh, "ops", "/v1/sign", signBody, nil); status != http.StatusOK {
+		t.Error("Unlocked signer should sign:", status)
+	}
+	if status := apiPostAs(t, sh, "ops", "/v1/admin/lock", "", lock); status != http.StatusOK || !lock.Locked {
+		t.Error("Lock failed:", status)
+	}
+	if status := apiPostAs(t, sh, "ops", "/v1/sign", signBody, nil); status != http.StatusServiceUnavailable {
+		t.Error("Locked signer should not sign:", status)
+	}
+
+	// the audit log is opened and keyed on the first unlock
+	sh.audit.Close()
+	if entries, err := VerifyAuditLog(auditPath, DeriveAuditKey(MasterKey([]byte("test")))); err != nil || entries != 4 {
+		t.Error("Unexpected audit log:", entries, err)
+	}
+
+	// a new store started locked can't choose its password over the API
+	empty, _ := MakeFileStore(filepath.Join(dir, "empty"))
+	emptyHold, err := MakeLockedHold(empty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emptySh := &SigningHandler{hold: emptyHold, auth: sh.auth}
+	if status := apiPostAs(t, emptySh, "keyholder", "/v1/admin/unlock", `{"password":"any"}`, errResp); status != http.StatusConflict ||
+		errResp.Error.Code != CodeNotInitialized || !emptyHold.Locked() {
+		t.Error("First unlock over the API should have been refused:", status, errResp.Error)
+	}
+	if initialized, _ := Initialized(empty); initialized {
+		t.Error("Refused unlock saved a verifier")
+	}
+
+	hold.Unlock([]byte("test"))
+	if hold.LockIfIdle(time.Hour) || hold.Locked() {
+		t.Error("Hold locked before its idle timeout")
+	}
+	held := hold.masterKey
+	if !hold.LockIfIdle(0) || !hold.Locked() {
+		t.Error("Idle hold not locked")
+	}
+	if !bytes.Equal(held, make([]byte, len(held))) {
+		t.Error("Master key not wiped on lock")
+	}
+}
+
+func TestAPIUnlockShares(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, _ := MakeFileStore(filepath.Join(dir, "store"))
+	addr, _ := mustMakeHold(t, store).NewKey(NewSignatureChallenge([]string{ADDR1}, BitcoinFamily), btcNetwork(), nil)
+	shares, err := SplitMasterKey(store, MasterKey([]byte("test")), 3, 2)
+	if err != nil || len(shares) != 3 {
+		t.Fatal("Split failed:", shares, err)
+	}
+	if settings, err := LoadShareSettings(store); err != nil || settings.Threshold != 2 || settings.Shares != 3 {
+		t.Error("Unexpected share settings:", settings, err)
+	}
+
+	hold, err := MakeLockedHold(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh := &SigningHandler{hold: hold, audit: PendingAuditLog(filepath.Join(dir, "audit.log"), true), auth: AuthTable{
+		"ops":     {Operations: []Operation{OpSign}, CoinPrefixes: []string{AnyCoinPrefix}},
+		"holder1": {Operations: []Operation{OpUnlock}},
+		"holder2": {Operations: []Operation{OpUnlock}},
+	}}
+	shareBody := func(share string) string { return `{"share":"` + share + `"}` }
+
+	// the password doesn't unlock a split store alone
+	errResp := &ErrorResponse{}
+	if status := apiPostAs(t, sh, "holder1", "/v1/admin/unlock", `{"password":"test"}`, errResp); status != http.StatusConflict ||
+		errResp.Error.Code != CodeSplit || !hold.Locked() {
+		t.Error("Password unlock of a split store should have been refused:", status, errResp.Error)
+	}
+	if err := hold.Unlock([]byte("test")); !errors.Is(err, ErrSplit) {
+		t.Error("Password unlock of a split store should have been refused:", err)
+	}
+
+	if status := apiPostAs(t, sh, "ops", "/v1/admin/shares", shareBody(shares[0]), nil); status != http.StatusForbidden {
+		t.Error("Signing client should not submit shares:", status)
+	}
+	resp := &ShareResponse{}
+	if status := apiPostAs(t, sh, "holder1", "/v1/admin/shares", shareBody(shares[0]), resp); status != http.StatusOK || !resp.Locked || resp.Submitted != 1 || resp.Threshold != 2 {
+		t.Fatal("Share submission failed:", status, resp)
+	}
+	if status := apiPostAs(t, sh, "holder1", "/v1/admin/shares", shareBody(shares[1]), errResp); status != http.StatusConflict || errResp.Error.Code != CodeShareSubmitted {
+		t.Error("Second share of a client should be refused:", status, errResp.Error)
+	}
+
+	// a corrupted share combines into a wrong master key
+	wrong := []byte(shares[1])
+	if wrong[0] == '0' {
+		wrong[0] = '1'
+	} else {
+		wrong[0] = '0'
+	}
+	if status := apiPostAs(t, sh, "holder2", "/v1/admin/shares", shareBody(string(wrong)), errResp); status != http.StatusForbidden || errResp.Error.Code != CodeWrongShares {
+		t.Error("Wrong shares should have been refused:", status, errResp.Error)
+	}
+	if !hold.Locked() {
+		t.Fatal("Wrong shares unlocked the signer")
+	}
+
+	apiPostAs(t, sh, "holder1", "/v1/admin/shares", shareBody(shares[2]), nil)
+	resp = &ShareResponse{}
+	if status := apiPostAs(t, sh, "holder2", "/v1/admin/shares", shareBody(shares[1]), resp); status != http.StatusOK || resp.Locked {
+		t.Fatal("Shares should have unlocked the signer:", status, resp)
+	}
+	signBody := `{"sourceAddr":"` + addr + `","txData":"` + TxData1 + `"}`
+	if status := apiPostAs(t, sh, "ops", "/v1/sign", signBody, nil); status != http.StatusOK {
+		t.Error("Unlocked signer should sign:", status)
+	}
+	sh.audit.Close()
+}
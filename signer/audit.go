@@ -0,0 +1,334 @@
+package signer
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/blockcypher/cryptosigner/util"
+)
+
+// Tamper evident audit log of key creations and signatures. Entries are appended as JSON lines,
+// each holding the hash of the previous line, and when keyed an HMAC with a key derived from the
+// master key. A head file next to the log holds the sequence number and hash of the last entry,
+// so truncating the log is detected as well as editing, removing or reordering entries.
+
+// AuditEntry is an entry of the audit log
+type AuditEntry struct {
+	Seq        uint64    `json:"seq"`
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	Client     string    `json:"client,omitempty"`
+	CoinPrefix string    `json:"coinPrefix,omitempty"`
+	Source     string    `json:"source,omitempty"`
+	Targets    []string  `json:"targets,omitempty"`
+	// DataHash is the hex SHA-256 of the transaction data or message signed
+	DataHash string `json:"dataHash,omitempty"`
+	// State is the state a key is moved to
+	State   string `json:"state,omitempty"`
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+	// Prev is the hex SHA-256 of the previous line, zeros for the first entry
+	Prev string `json:"prev"`
+	// MAC is the hex HMAC-SHA256 of the entry without it, when keyed
+	MAC string `json:"mac,omitempty"`
+}
+
+type auditHead struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+	MAC  string `json:"mac,omitempty"`
+}
+
+var zeroHash = hex.EncodeToString(make([]byte, sha256.Size))
+
+// ErrAuditTampered is returned when verifying a log that was edited or truncated
+var ErrAuditTampered = errors.New("audit log tampered")
+
+// AuditLog appends entries to an audit log file
+type AuditLog struct {
+	lock     sync.Mutex
+	file     *os.File
+	headPath string
+	key      []byte
+	seq      uint64
+	last     string
+	// path and keying of a pending log, opened on unlock
+	path  string
+	keyed bool
+}
+
+// MasterKey derives the 32 bytes master key of the hold from the password
+func MasterKey(pass []byte) []byte {
+	passh := sha256.Sum256(pass)
+	masterKey := util.NewSecret(len(passh))
+	copy(masterKey, passh[:])
+	util.Wipe(passh[:])
+	return masterKey
+}
+
+// DeriveAuditKey derives the HMAC key of the audit log from the master key
+func DeriveAuditKey(masterKey []byte) []byte {
+	key := make([]byte, 32)
+	io.ReadFull(hkdf.New(sha256.New, masterKey, nil, []byte("cryptosigner audit log")), key)
+	return key
+}
+
+// OpenAuditLog opens or creates an audit log, continuing its chain. Entries are HMAC'd when key
+// isn't nil. An existing log is checked against its head, and with the key its HMACs, so a log
+// truncated or edited while the signer was stopped is refused. The log may be one entry ahead of
+// its head, written before a crash left the head behind.
+func OpenAuditLog(path string, key []byte) (*AuditLog, error) {
+	seq, last, prev, err := readAuditLog(path, key)
+	if err != nil {
+		return nil, err
+	}
+	head, err := readAuditHead(path, key)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case head == nil && seq == 0:
+	case head == nil:
+		return nil, fmt.Errorf("%w: missing head", ErrAuditTampered)
+	case head.Seq == seq && head.Hash == last:
+	case head.Seq+1 == seq && head.Hash == prev:
+		log.Println("Audit log head one entry behind, updated.")
+	default:
+		return nil, fmt.Errorf("%w: log ends at entry %d, head at entry %d", ErrAuditTampered, seq, head.Seq)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	audit := &AuditLog{file: file, headPath: path + ".head", key: key, seq: seq, last: last}
+	if head != nil && head.Seq != seq {
+		if err := audit.writeHead(); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return audit, nil
+}
+
+// PendingAuditLog returns an audit log opened by Open once the master key is known, for signers
+// starting locked. Nothing is recorded until then.
+func PendingAuditLog(path string, keyed bool) *AuditLog {
+	return &AuditLog{path: path, keyed: keyed}
+}
+
+// Open opens a pending log with the master key, HMAC'ing entries with a key derived from it if
+// keyed. Logs already open are left as they are.
+func (a *AuditLog) Open(masterKey []byte) error {
+	if a == nil {
+		return nil
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.file != nil {
+		return nil
+	}
+	var key []byte
+	if a.keyed {
+		key = DeriveAuditKey(masterKey)
+	}
+	opened, err := OpenAuditLog(a.path, key)
+	if err != nil {
+		return err
+	}
+	a.file, a.headPath, a.key, a.seq, a.last = opened.file, opened.headPath, opened.key, opened.seq, opened.last
+	return nil
+}
+
+func newAuditScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return scanner
+}
+
+func lineHash(line []byte) string {
+	h := sha256.Sum256(line)
+	return hex.EncodeToString(h[:])
+}
+
+func auditMAC(key, data []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Record appends an entry for an operation that returned opErr, and returns opErr. If the entry
+// can't be written, the operation has to fail: the write error is returned instead. A nil log
+// records nothing, neither does a pending log before it is opened.
+func (a *AuditLog) Record(entry *AuditEntry, opErr error) error {
+	if a == nil {
+		return opErr
+	}
+	if opErr != nil {
+		entry.Error = opErr.Error()
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.file == nil {
+		log.Println("Not audited, audit log opened on unlock:", entry.Event, entry.Client)
+		return opErr
+	}
+	entry.Seq = a.seq + 1
+	entry.Time = time.Now().UTC()
+	entry.Prev = a.last
+	entry.MAC = ""
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if a.key != nil {
+		entry.MAC = auditMAC(a.key, line)
+		line, _ = json.Marshal(entry)
+	}
+
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("audit log: %w", err)
+	}
+	if err := a.file.Sync(); err != nil {
+		return fmt.Errorf("audit log: %w", err)
+	}
+	a.seq, a.last = entry.Seq, lineHash(line)
+	if err := a.writeHead(); err != nil {
+		return fmt.Errorf("audit log head: %w", err)
+	}
+	return opErr
+}
+
+func (a *AuditLog) writeHead() error {
+	head := &auditHead{Seq: a.seq, Hash: a.last}
+	if a.key != nil {
+		data, _ := json.Marshal(head)
+		head.MAC = auditMAC(a.key, data)
+	}
+	data, _ := json.Marshal(head)
+	tmp := a.headPath + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.headPath)
+}
+
+// Close closes the log file
+func (a *AuditLog) Close() error {
+	if a == nil || a.file == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+// VerifyAuditLog checks the chain of a log against its head file, and the HMACs of entries and
+// head when key isn't nil. Returns the number of entries verified.
+func VerifyAuditLog(path string, key []byte) (uint64, error) {
+	if _, err := os.Stat(path); err != nil {
+		return 0, err
+	}
+	seq, last, _, err := readAuditLog(path, key)
+	if err != nil {
+		return seq, err
+	}
+	head, err := readAuditHead(path, key)
+	if err != nil {
+		return seq, err
+	}
+	if head == nil {
+		if seq == 0 {
+			return 0, nil
+		}
+		return seq, fmt.Errorf("%w: missing head", ErrAuditTampered)
+	}
+	if head.Seq != seq || head.Hash != last {
+		return seq, fmt.Errorf("%w: log ends at entry %d, head at entry %d", ErrAuditTampered, seq, head.Seq)
+	}
+	return seq, nil
+}
+
+// readAuditLog checks the chain of a log, and the HMACs of its entries when key isn't nil.
+// Returns the sequence number and hash of the last entry, and the hash it chains to. A missing
+// log is empty.
+func readAuditLog(path string, key []byte) (seq uint64, last, prev string, err error) {
+	last, prev = zeroHash, zeroHash
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, last, prev, nil
+	} else if err != nil {
+		return 0, "", "", err
+	}
+	defer file.Close()
+
+	scanner := newAuditScanner(file)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		var entry AuditEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return seq, "", "", fmt.Errorf("%w: unreadable entry after %d", ErrAuditTampered, seq)
+		}
+		if entry.Seq != seq+1 {
+			return seq, "", "", fmt.Errorf("%w: entry %d follows %d", ErrAuditTampered, entry.Seq, seq)
+		}
+		if entry.Prev != last {
+			return seq, "", "", fmt.Errorf("%w: entry %d does not chain to the previous entry", ErrAuditTampered, entry.Seq)
+		}
+		if key != nil {
+			mac := entry.MAC
+			entry.MAC = ""
+			data, _ := json.Marshal(&entry)
+			if !hmac.Equal([]byte(mac), []byte(auditMAC(key, data))) {
+				return seq, "", "", fmt.Errorf("%w: invalid HMAC of entry %d", ErrAuditTampered, entry.Seq)
+			}
+		}
+		seq, last, prev = entry.Seq, lineHash(line), last
+	}
+	if err := scanner.Err(); err != nil {
+		return seq, "", "", err
+	}
+	return seq, last, prev, nil
+}
+
+// readAuditHead reads the head of a log, checking its HMAC when key isn't nil. Returns nil when
+// there is no head.
+func readAuditHead(path string, key []byte) (*auditHead, error) {
+	data, err := ioutil.ReadFile(path + ".head")
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	head := &auditHead{}
+	if err := json.Unmarshal(data, head); err != nil {
+		return nil, fmt.Errorf("%w: unreadable head", ErrAuditTampered)
+	}
+	if key != nil {
+		mac := head.MAC
+		head.MAC = ""
+		data, _ := json.Marshal(head)
+		if !hmac.Equal([]byte(mac), []byte(auditMAC(key, data))) {
+			return nil, fmt.Errorf("%w: invalid HMAC of head", ErrAuditTampered)
+		}
+		head.MAC = mac
+	}
+	return head, nil
+}
+
+// dataHash is the hex SHA-256 of data recorded in audit entries
+func dataHash(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,148 @@
+package signer
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	key := DeriveAuditKey(MasterKey([]byte("test")))
+
+	audit, err := OpenAuditLog(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh := &SigningHandler{hold: testHold(), audit: audit}
+	transfer := &TransferResponse{}
+	apiPostAs(t, sh, "payments", "/v1/transfer", `{"coinPrefix":"btc","targetAddr":"`+ADDR1+`"}`, transfer)
+	apiPost(t, sh, "/v1/sign", `{"sourceAddr":"`+transfer.Address+`","txData":"`+TxData1+`"}`, nil)
+	apiPost(t, sh, "/v1/sign", `{"sourceAddr":"`+transfer.Address+`","txData":"`+TxData2+`"}`, nil)
+	audit.Close()
+
+	// reopened, the chain continues
+	audit, err = OpenAuditLog(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh.audit = audit
+	apiPost(t, sh, "/v1/sign", `{"sourceAddr":"`+ADDR2+`","txData":"`+TxData2+`"}`, nil)
+	audit.Close()
+
+	if entries, err := VerifyAuditLog(path, key); err != nil || entries != 4 {
+		t.Fatal("Verification failed:", entries, err)
+	}
+	if _, err := VerifyAuditLog(path, DeriveAuditKey(MasterKey([]byte("wrong")))); !errors.Is(err, ErrAuditTampered) {
+		t.Error("Wrong key should have failed:", err)
+	}
+	if entries, err := VerifyAuditLog(path, nil); err != nil || entries != 4 {
+		t.Error("Chain verification failed:", entries, err)
+	}
+
+	data, _ := ioutil.ReadFile(path)
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	for _, check := range []string{`"client":"payments"`, `"targets":["` + ADDR1 + `"]`, `"outcome":"created"`,
+		`"outcome":"signed"`, `"outcome":"challenge_failed"`, `"outcome":"unknown_address"`, `"dataHash":"`} {
+		if !bytes.Contains(data, []byte(check)) {
+			t.Error("Missing in log:", check)
+		}
+	}
+
+	tampered := map[string][]byte{
+		"edited":    bytes.Replace(data, []byte(`"outcome":"challenge_failed"`), []byte(`"outcome":"signed"`), 1),
+		"removed":   bytes.Join([][]byte{lines[0], lines[2], lines[3]}, nil),
+		"truncated": bytes.Join(lines[:3], nil),
+	}
+	for name, content := range tampered {
+		ioutil.WriteFile(path, content, 0600)
+		if _, err := VerifyAuditLog(path, nil); !errors.Is(err, ErrAuditTampered) {
+			t.Error(name, "log should have failed:", err)
+		} else if name == "truncated" && !strings.Contains(err.Error(), "head at entry 4") {
+			t.Error("Unexpected error:", err)
+		}
+	}
+}
+
+func TestAuditReopenTruncated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	key := DeriveAuditKey(MasterKey([]byte("test")))
+	audit, err := OpenAuditLog(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < 3; n++ {
+		audit.Record(&AuditEntry{Event: "lock", Outcome: OutcomeLocked}, nil)
+	}
+	audit.Close()
+	data, _ := ioutil.ReadFile(path)
+	lines := bytes.SplitAfter(data, []byte("\n"))
+
+	// a crash between the entry and the head leaves the head one entry behind
+	head, _ := ioutil.ReadFile(path + ".head")
+	audit, err = OpenAuditLog(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit.Record(&AuditEntry{Event: "lock", Outcome: OutcomeLocked}, nil)
+	audit.Close()
+	ioutil.WriteFile(path+".head", head, 0600)
+	if audit, err = OpenAuditLog(path, key); err != nil {
+		t.Fatal("Log one entry ahead of its head should have been reopened:", err)
+	}
+	audit.Close()
+	if entries, err := VerifyAuditLog(path, key); err != nil || entries != 4 {
+		t.Error("Verification failed:", entries, err)
+	}
+
+	// truncated while stopped, the log is refused and stays detected
+	ioutil.WriteFile(path, bytes.Join(lines[:2], nil), 0600)
+	if _, err := OpenAuditLog(path, key); !errors.Is(err, ErrAuditTampered) {
+		t.Error("Truncated log should have been refused:", err)
+	}
+	if _, err := VerifyAuditLog(path, key); !errors.Is(err, ErrAuditTampered) {
+		t.Error("Truncated log should have failed verification:", err)
+	}
+	os.Remove(path)
+	if _, err := OpenAuditLog(path, key); !errors.Is(err, ErrAuditTampered) {
+		t.Error("Removed log should have been refused:", err)
+	}
+}
+
+func TestAuditWriteFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	audit, err := OpenAuditLog(filepath.Join(dir, "audit.log"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit.Close()
+
+	// no signature is returned unless recorded
+	sh := &SigningHandler{hold: testHold()}
+	transfer := &TransferResponse{}
+	apiPost(t, sh, "/v1/transfer", `{"coinPrefix":"btc","targetAddr":"`+ADDR1+`"}`, transfer)
+	sh.audit = audit
+	sign := &SignResponse{}
+	status := apiPost(t, sh, "/v1/sign", `{"sourceAddr":"`+transfer.Address+`","txData":"`+TxData1+`"}`, sign)
+	if status != 500 || len(sign.Signature) > 0 {
+		t.Error("Sign should have failed:", status)
+	}
+}
@@ -0,0 +1,135 @@
+package signer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+)
+
+// Client authentication and authorization. Clients are identified by the common name of the
+// certificate they present, the authorization table grants each identity operations on coin
+// prefixes.
+
+// Operation is an operation a client can be authorized for
+type Operation string
+
+// Operations of the signer
+const (
+	// OpTransfer creates keys (/transfer)
+	OpTransfer Operation = "transfer"
+	// OpSign signs transactions and messages (/sign, /signmessage)
+	OpSign Operation = "sign"
+	// OpAdmin manages the signer and its keys
+	OpAdmin Operation = "admin"
+	// OpUnfreeze lifts a freeze of all signing, granted apart from admin
+	OpUnfreeze Operation = "unfreeze"
+	// OpUnlock unlocks the signer with its password, granted apart from admin
+	OpUnlock Operation = "unlock"
+)
+
+// AnyCoinPrefix in the coin prefixes of a grant allows all networks
+const AnyCoinPrefix = "*"
+
+// Grant lists the operations and coin prefixes allowed to a client
+type Grant struct {
+	Operations   []Operation `json:"operations"`
+	CoinPrefixes []string    `json:"coinPrefixes"`
+}
+
+// Allows tells whether the grant covers an operation on a coin prefix. An empty coin prefix is
+// for operations not tied to a network.
+func (g *Grant) Allows(op Operation, coinPrefix string) bool {
+	opAllowed := false
+	for _, o := range g.Operations {
+		opAllowed = opAllowed || o == op
+	}
+	if !opAllowed {
+		return false
+	}
+	if len(coinPrefix) == 0 {
+		return true
+	}
+	for _, prefix := range g.CoinPrefixes {
+		if prefix == AnyCoinPrefix || prefix == coinPrefix {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthTable maps client identities to their grant
+type AuthTable map[string]*Grant
+
+// LoadAuthTable reads an authorization table from a JSON file, an object keyed by client identity:
+//
+//	{"payments": {"operations": ["transfer", "sign"], "coinPrefixes": ["btc", "eth"]}}
+func LoadAuthTable(path string) (AuthTable, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var table AuthTable
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, err
+	}
+	for identity, grant := range table {
+		if grant == nil {
+			return nil, errors.New("Missing grant for client " + identity)
+		}
+		for _, op := range grant.Operations {
+			if op != OpTransfer && op != OpSign && op != OpAdmin && op != OpUnfreeze && op != OpUnlock {
+				return nil, errors.New("Unknown operation " + string(op) + " for client " + identity)
+			}
+		}
+		for _, prefix := range grant.CoinPrefixes {
+			if _, err := LookupNetwork(prefix); err != nil && prefix != AnyCoinPrefix {
+				return nil, errors.New("Unknown coin prefix " + prefix + " for client " + identity)
+			}
+		}
+	}
+	return table, nil
+}
+
+// Authorize checks that the client identity is granted the operation on the coin prefix
+func (t AuthTable) Authorize(identity string, op Operation, coinPrefix string) error {
+	if len(identity) == 0 {
+		return apiError(http.StatusUnauthorized, CodeUnauthenticated, "Client not authenticated.", "")
+	}
+	grant := t[identity]
+	if grant == nil || !grant.Allows(op, coinPrefix) {
+		msg := "Client " + identity + " not allowed to " + string(op)
+		if len(coinPrefix) > 0 {
+			msg += " on " + coinPrefix
+		}
+		return apiError(http.StatusForbidden, CodeForbidden, msg+".", "")
+	}
+	return nil
+}
+
+// ClientIdentity returns the identity of the client of a request, the common name of its verified
+// certificate, or an empty string
+func ClientIdentity(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+// requireClientCerts requires clients to present a certificate signed by one of the CAs of the
+// bundle
+func requireClientCerts(tlsConfig *tls.Config, caFile string) error {
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return errors.New("No certificate found in client CA bundle " + caFile)
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	return nil
+}
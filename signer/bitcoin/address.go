@@ -0,0 +1,159 @@
+package bitcoin
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	"github.com/blockcypher/cryptosigner/util"
+)
+
+// AddressType is the kind of output script an address pays to
+type AddressType string
+
+// Address types of Bitcoin family networks
+const (
+	P2PKH  AddressType = "p2pkh"
+	P2SH   AddressType = "p2sh"
+	P2WPKH AddressType = "p2wpkh"
+	P2WSH  AddressType = "p2wsh"
+	P2TR   AddressType = "p2tr"
+)
+
+// Address validation errors
+var (
+	ErrAddressFormat   = errors.New("invalid address encoding")
+	ErrBase58Checksum  = errors.New("invalid base58check checksum")
+	ErrBech32Checksum  = errors.New("invalid bech32 checksum")
+	ErrBech32Variant   = errors.New("bech32 variant does not match witness version")
+	ErrWitnessProgram  = errors.New("invalid witness program")
+	ErrVersionByte     = errors.New("address version byte does not match network")
+	ErrHRP             = errors.New("address human readable part does not match network")
+	ErrUnsupportedType = errors.New("address type not supported by network")
+)
+
+// Params are the address encoding parameters of a Bitcoin family network
+type Params struct {
+	P2PKHVersion byte
+	P2SHVersion  byte
+	// Bech32HRP is empty for networks without segwit
+	Bech32HRP string
+	// CashAddrPrefix is empty for networks without CashAddr
+	CashAddrPrefix string
+	AddressTypes   []AddressType
+	// MessageMagic prefixes the messages of BIP137 signatures, DefaultMessageMagic when empty
+	MessageMagic string
+}
+
+// Supports checks whether the network accepts the address type
+func (p *Params) Supports(addrType AddressType) bool {
+	for _, t := range p.AddressTypes {
+		if t == addrType {
+			return true
+		}
+	}
+	return false
+}
+
+// DecodeAddress returns the type and output script of an address on the network.
+// The address must be a valid base58check, bech32/bech32m or CashAddr address,
+// encoded for the network.
+func DecodeAddress(addr string, params *Params) (AddressType, []byte, error) {
+	// legacy addresses are at most 34 characters long, CashAddrs 42 without prefix
+	if len(params.CashAddrPrefix) > 0 && (strings.IndexByte(addr, ':') >= 0 || len(addr) == 42) {
+		addrType, hash, err := decodeCashAddr(addr, params.CashAddrPrefix)
+		if err != nil {
+			return "", nil, err
+		}
+		if !params.Supports(addrType) {
+			return "", nil, ErrUnsupportedType
+		}
+		if addrType == P2SH {
+			return P2SH, P2SHScript(hash), nil
+		}
+		return P2PKH, P2PKHScript(hash), nil
+	}
+
+	hrp := params.Bech32HRP
+	if len(hrp) > 0 && strings.HasPrefix(strings.ToLower(addr), hrp+"1") {
+		_, version, program, err := decodeSegwitAddr(addr)
+		if err != nil {
+			return "", nil, err
+		}
+		var addrType AddressType
+		switch {
+		case version == 0 && len(program) == 20:
+			addrType = P2WPKH
+		case version == 0 && len(program) == 32:
+			addrType = P2WSH
+		case version == 1 && len(program) == 32:
+			addrType = P2TR
+		default:
+			return "", nil, ErrUnsupportedType
+		}
+		if !params.Supports(addrType) {
+			return "", nil, ErrUnsupportedType
+		}
+		return addrType, witnessScript(version, program), nil
+	}
+	if _, _, _, err := decodeSegwitAddr(addr); err == nil {
+		// valid segwit address, for another network
+		return "", nil, ErrHRP
+	}
+
+	decoded, err := base58CheckDecode(addr)
+	if err != nil {
+		return "", nil, err
+	}
+	switch {
+	case decoded[0] == params.P2PKHVersion && params.Supports(P2PKH):
+		return P2PKH, P2PKHScript(decoded[1:]), nil
+	case decoded[0] == params.P2SHVersion && params.Supports(P2SH):
+		return P2SH, P2SHScript(decoded[1:]), nil
+	}
+	return "", nil, ErrVersionByte
+}
+
+// base58CheckDecode decodes a 25 bytes base58check address: version byte and
+// hash160, checksum removed
+func base58CheckDecode(addr string) ([]byte, error) {
+	decoded := base58Decode(addr)
+	if len(decoded) != 25 {
+		return nil, ErrAddressFormat
+	}
+	if !bytes.Equal(util.DoubleHash(decoded[:21])[:4], decoded[21:]) {
+		return nil, ErrBase58Checksum
+	}
+	return decoded[:21], nil
+}
+
+// VerifyOutputs checks that the transaction pays exactly to the addresses, in
+// order, and nothing else
+func VerifyOutputs(addresses []string, params *Params, toSign []byte) bool {
+	tx, err := ParseTx(toSign)
+	if err != nil || len(tx.Outputs) != len(addresses) {
+		return false
+	}
+	for n, addr := range addresses {
+		_, script, err := DecodeAddress(addr, params)
+		if err != nil || !bytes.Equal(script, tx.Outputs[n].Script) {
+			return false
+		}
+	}
+	return true
+}
+
+// P2SHScript builds a pay to script hash output script
+func P2SHScript(hash160 []byte) []byte {
+	script := []byte{169, 20} // OP_HASH160 PUSH20
+	script = append(script, hash160...)
+	return append(script, 135) // OP_EQUAL
+}
+
+func witnessScript(version byte, program []byte) []byte {
+	op := version
+	if version > 0 {
+		op = 0x50 + version // OP_1 to OP_16
+	}
+	return append([]byte{op, byte(len(program))}, program...)
+}
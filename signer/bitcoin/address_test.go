@@ -0,0 +1,90 @@
+package bitcoin
+
+import "testing"
+
+var btcParams = &Params{P2PKHVersion: 0x00, P2SHVersion: 0x05, Bech32HRP: "bc",
+	AddressTypes: []AddressType{P2PKH, P2SH, P2WPKH, P2WSH, P2TR}}
+
+func TestDecodeAddress(t *testing.T) {
+	vectors := []struct {
+		addr     string
+		addrType AddressType
+		err      error
+	}{
+		{"15qx9ug952GWGTNn7Uiv6vode4RcGrRemh", P2PKH, nil},
+		{"3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy", P2SH, nil},
+		{"BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", P2WPKH, nil},
+		{"bc1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qccfmv3", P2WSH, nil},
+		{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", P2TR, nil},
+		{"15qx9ug952GWGTNn7Uiv6vode4RcGrRemi", "", ErrBase58Checksum},
+		{"15qx9ug952GWGTNn7Uiv6vode4RcGrRem0", "", ErrAddressFormat},
+		{"mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn", "", ErrVersionByte},
+		{"tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx", "", ErrHRP},
+		{"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5", "", ErrBech32Checksum},
+		{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqh2y7hd", "", ErrBech32Variant},
+		{"bc1zw508d6qejxtdg4y5r3zarvaryvaxxpcs", "", ErrUnsupportedType},
+	}
+	for _, v := range vectors {
+		addrType, _, err := DecodeAddress(v.addr, btcParams)
+		if addrType != v.addrType || err != v.err {
+			t.Errorf("%s: got %q, %v", v.addr, addrType, err)
+		}
+	}
+
+	doge := &Params{P2PKHVersion: 0x1e, P2SHVersion: 0x16, AddressTypes: []AddressType{P2PKH, P2SH}}
+	if _, _, err := DecodeAddress("BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", doge); err != ErrHRP {
+		t.Error("Segwit address accepted on a network without segwit.", err)
+	}
+}
+
+func TestSegwitAddrRoundTrip(t *testing.T) {
+	for _, addr := range []string{
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4",
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0",
+	} {
+		hrp, version, program, err := decodeSegwitAddr(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encoded := encodeSegwitAddr(hrp, version, program); encoded != addr {
+			t.Error("Round trip failed", encoded)
+		}
+	}
+}
+
+func TestCashAddr(t *testing.T) {
+	bch := &Params{P2PKHVersion: 0x00, P2SHVersion: 0x05, CashAddrPrefix: "bitcoincash",
+		AddressTypes: []AddressType{P2PKH, P2SH}}
+	vectors := []struct {
+		cashAddr, legacy string
+		addrType         AddressType
+	}{
+		{"bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a", "1BpEi6DfDAUFd7GtittLSdBeYJvcoaVggu", P2PKH},
+		{"bitcoincash:ppm2qsznhks23z7629mms6s4cwef74vcwvn0h829pq", "3CWFddi6m4ndiGyKqzYvsFYagqDLPVMTzC", P2SH},
+	}
+	for _, v := range vectors {
+		addrType, script, err := DecodeAddress(v.cashAddr, bch)
+		if err != nil || addrType != v.addrType {
+			t.Fatal(v.cashAddr, err)
+		}
+		_, legacyScript, err := DecodeAddress(v.legacy, bch)
+		if err != nil || string(script) != string(legacyScript) {
+			t.Error("CashAddr does not match legacy address", v.legacy, err)
+		}
+		// prefix is optional, case is not mixed
+		if _, _, err := DecodeAddress(v.cashAddr[12:], bch); err != nil {
+			t.Error(err)
+		}
+		decoded, _ := base58CheckDecode(v.legacy)
+		if encoded := EncodeCashAddr("bitcoincash", decoded[1:], addrType == P2SH); encoded != v.cashAddr {
+			t.Error("Unexpected encoding", encoded)
+		}
+	}
+
+	if _, _, err := DecodeAddress("bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6c", bch); err != ErrCashAddrChecksum {
+		t.Error("Checksum should fail.", err)
+	}
+	if _, _, err := DecodeAddress("bchtest:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a", bch); err != ErrHRP {
+		t.Error("Prefix should not match.", err)
+	}
+}
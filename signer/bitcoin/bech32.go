@@ -0,0 +1,156 @@
+package bitcoin
+
+import (
+	"errors"
+	"strings"
+)
+
+// Bech32 (BIP173) and bech32m (BIP350) encoding of segwit addresses
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// Checksum constants of the two bech32 variants
+const (
+	bech32Const  = 1
+	bech32mConst = 0x2bc830a3
+)
+
+var bech32Generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= bech32Generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	exp := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		exp = append(exp, hrp[i]>>5)
+	}
+	exp = append(exp, 0)
+	for i := 0; i < len(hrp); i++ {
+		exp = append(exp, hrp[i]&31)
+	}
+	return exp
+}
+
+// bech32Decode returns the HRP, the 5 bits data without checksum and the
+// checksum constant it matched
+func bech32Decode(s string) (string, []byte, uint32, error) {
+	if len(s) > 90 {
+		return "", nil, 0, ErrAddressFormat
+	}
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, 0, ErrAddressFormat
+	}
+	s = strings.ToLower(s)
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, 0, ErrAddressFormat
+	}
+	hrp := s[:pos]
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, 0, ErrAddressFormat
+		}
+	}
+	data := make([]byte, 0, len(s)-pos-1)
+	for i := pos + 1; i < len(s); i++ {
+		d := strings.IndexByte(bech32Charset, s[i])
+		if d < 0 {
+			return "", nil, 0, ErrAddressFormat
+		}
+		data = append(data, byte(d))
+	}
+	constant := bech32Polymod(append(bech32HRPExpand(hrp), data...))
+	if constant != bech32Const && constant != bech32mConst {
+		return "", nil, 0, ErrBech32Checksum
+	}
+	return hrp, data[:len(data)-6], constant, nil
+}
+
+func bech32Encode(hrp string, data []byte, constant uint32) string {
+	values := append(bech32HRPExpand(hrp), data...)
+	polymod := bech32Polymod(append(values, 0, 0, 0, 0, 0, 0)) ^ constant
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, d := range data {
+		sb.WriteByte(bech32Charset[d])
+	}
+	for i := 0; i < 6; i++ {
+		sb.WriteByte(bech32Charset[(polymod>>uint(5*(5-i)))&31])
+	}
+	return sb.String()
+}
+
+// convertBits regroups bits, from 8 to 5 bits groups with padding when
+// encoding, from 5 to 8 bits without when decoding
+func convertBits(data []byte, from, to uint, pad bool) ([]byte, error) {
+	acc, bits := uint32(0), uint(0)
+	maxv := uint32(1)<<to - 1
+	out := make([]byte, 0, len(data)*int(from)/int(to)+1)
+	for _, v := range data {
+		if uint32(v)>>from != 0 {
+			return nil, errors.New("invalid data range")
+		}
+		acc = acc<<from | uint32(v)
+		bits += from
+		for bits >= to {
+			bits -= to
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(to-bits)&maxv))
+		}
+	} else if bits >= from || acc<<(to-bits)&maxv != 0 {
+		return nil, errors.New("invalid padding")
+	}
+	return out, nil
+}
+
+// decodeSegwitAddr returns the HRP, witness version and program of a segwit
+// address, checking the checksum variant matches the version
+func decodeSegwitAddr(addr string) (string, byte, []byte, error) {
+	hrp, data, constant, err := bech32Decode(addr)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	if len(data) == 0 || data[0] > 16 {
+		return "", 0, nil, ErrWitnessProgram
+	}
+	program, err := convertBits(data[1:], 5, 8, false)
+	if err != nil || len(program) < 2 || len(program) > 40 {
+		return "", 0, nil, ErrWitnessProgram
+	}
+	version := data[0]
+	if version == 0 && len(program) != 20 && len(program) != 32 {
+		return "", 0, nil, ErrWitnessProgram
+	}
+	if (version == 0) != (constant == bech32Const) {
+		return "", 0, nil, ErrBech32Variant
+	}
+	return hrp, version, program, nil
+}
+
+// encodeSegwitAddr encodes a witness program as a bech32, or bech32m from
+// version 1, address
+func encodeSegwitAddr(hrp string, version byte, program []byte) string {
+	conv, _ := convertBits(program, 8, 5, true)
+	constant := uint32(bech32Const)
+	if version > 0 {
+		constant = bech32mConst
+	}
+	return bech32Encode(hrp, append([]byte{version}, conv...), constant)
+}
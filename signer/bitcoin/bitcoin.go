@@ -4,8 +4,6 @@ import (
 	"bytes"
 	"math/big"
 
-	"github.com/btcsuite/btcutil/bech32"
-
 	"github.com/blockcypher/cryptosigner/util"
 )
 
@@ -208,14 +206,27 @@ func base58Encode(b []byte) string {
 }
 
 func fromBech32Addr(addr string) (string, []byte, error) {
-	hrp, decoded, err := bech32.Decode(addr)
-	if err != nil {
-		return "", nil, err
-	}
-	// strip version
-	conv, err := bech32.ConvertBits(decoded[1:], 5, 8, false)
-	if err != nil {
-		return "", nil, err
-	}
-	return hrp, conv, nil
+	hrp, _, program, err := decodeSegwitAddr(addr)
+	return hrp, program, err
+}
+
+// IsSegwitAddress checks whether addr is a bech32 encoded segwit address
+func IsSegwitAddress(addr string) bool {
+	_, _, _, err := decodeSegwitAddr(addr)
+	return err == nil
+}
+
+// EncodeSegwitAddress encodes a witness program as a bech32, or bech32m from version 1, address
+func EncodeSegwitAddress(hrp string, version byte, program []byte) string {
+	return encodeSegwitAddr(hrp, version, program)
+}
+
+// Base58Encode encodes bytes with the bitcoin base58 alphabet, no checksum
+func Base58Encode(b []byte) string {
+	return base58Encode(b)
+}
+
+// Base58Decode decodes a base58 string, no checksum. Invalid strings decode to no bytes.
+func Base58Decode(s string) []byte {
+	return base58Decode(s)
 }
@@ -0,0 +1,113 @@
+package bitcoin
+
+import (
+	"errors"
+	"strings"
+)
+
+// CashAddr encoding of Bitcoin Cash addresses
+
+// ErrCashAddrChecksum is returned for a CashAddr with an invalid checksum
+var ErrCashAddrChecksum = errors.New("invalid cashaddr checksum")
+
+// CashAddr type bits of the version byte
+const (
+	cashAddrP2PKH = 0
+	cashAddrP2SH  = 1
+)
+
+func cashAddrPolymod(values []byte) uint64 {
+	c := uint64(1)
+	for _, d := range values {
+		c0 := byte(c >> 35)
+		c = (c&0x07ffffffff)<<5 ^ uint64(d)
+		if c0&0x01 != 0 {
+			c ^= 0x98f2bc8e61
+		}
+		if c0&0x02 != 0 {
+			c ^= 0x79b76d99e2
+		}
+		if c0&0x04 != 0 {
+			c ^= 0xf33e5fb3c4
+		}
+		if c0&0x08 != 0 {
+			c ^= 0xae2eabe2a8
+		}
+		if c0&0x10 != 0 {
+			c ^= 0x1e4f43e470
+		}
+	}
+	return c ^ 1
+}
+
+func cashAddrPrefixExpand(prefix string) []byte {
+	exp := make([]byte, 0, len(prefix)+1)
+	for i := 0; i < len(prefix); i++ {
+		exp = append(exp, prefix[i]&31)
+	}
+	return append(exp, 0)
+}
+
+// EncodeCashAddr encodes a 20 bytes hash as a P2PKH (or P2SH) CashAddr, with its prefix
+func EncodeCashAddr(prefix string, hash160 []byte, p2sh bool) string {
+	version := byte(cashAddrP2PKH << 3) // size bits 0 for 160 bits hashes
+	if p2sh {
+		version = cashAddrP2SH << 3
+	}
+	payload, _ := convertBits(append([]byte{version}, hash160...), 8, 5, true)
+
+	values := append(cashAddrPrefixExpand(prefix), payload...)
+	polymod := cashAddrPolymod(append(values, 0, 0, 0, 0, 0, 0, 0, 0))
+	var sb strings.Builder
+	sb.WriteString(prefix)
+	sb.WriteByte(':')
+	for _, d := range payload {
+		sb.WriteByte(bech32Charset[d])
+	}
+	for i := 0; i < 8; i++ {
+		sb.WriteByte(bech32Charset[(polymod>>uint(5*(7-i)))&31])
+	}
+	return sb.String()
+}
+
+// decodeCashAddr returns the type and hash of a CashAddr. The prefix is
+// optional in addr, defaultPrefix is assumed when missing.
+func decodeCashAddr(addr, defaultPrefix string) (AddressType, []byte, error) {
+	if strings.ToLower(addr) != addr && strings.ToUpper(addr) != addr {
+		return "", nil, ErrAddressFormat
+	}
+	addr = strings.ToLower(addr)
+	prefix, payload := defaultPrefix, addr
+	if pos := strings.IndexByte(addr, ':'); pos >= 0 {
+		prefix, payload = addr[:pos], addr[pos+1:]
+	}
+	if prefix != defaultPrefix {
+		return "", nil, ErrHRP
+	}
+	if len(payload) != 42 {
+		// only 160 bits hashes are in use
+		return "", nil, ErrAddressFormat
+	}
+	data := make([]byte, len(payload))
+	for i := 0; i < len(payload); i++ {
+		d := strings.IndexByte(bech32Charset, payload[i])
+		if d < 0 {
+			return "", nil, ErrAddressFormat
+		}
+		data[i] = byte(d)
+	}
+	if cashAddrPolymod(append(cashAddrPrefixExpand(prefix), data...)) != 0 {
+		return "", nil, ErrCashAddrChecksum
+	}
+	decoded, err := convertBits(data[:len(data)-8], 5, 8, false)
+	if err != nil || len(decoded) != 21 || decoded[0]&0x07 != 0 {
+		return "", nil, ErrAddressFormat
+	}
+	switch decoded[0] >> 3 {
+	case cashAddrP2PKH:
+		return P2PKH, decoded[1:], nil
+	case cashAddrP2SH:
+		return P2SH, decoded[1:], nil
+	}
+	return "", nil, ErrUnsupportedType
+}
@@ -0,0 +1,95 @@
+package bitcoin
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+
+	"github.com/blockcypher/cryptosigner/util"
+)
+
+// Message signing for Bitcoin family coins. Legacy addresses use the compact
+// signature format of BIP137, segwit addresses the "simple" format of BIP322.
+
+// DefaultMessageMagic is the message prefix of Bitcoin, also used by its forks and test chains
+const DefaultMessageMagic = "Bitcoin Signed Message:\n"
+
+const bip322Tag = "BIP0322-signed-message"
+
+// MessageHash is the digest signed by a BIP137 message signature, the message prefixed with the
+// magic of the network, DefaultMessageMagic when empty
+func MessageHash(magic string, message []byte) []byte {
+	if len(magic) == 0 {
+		magic = DefaultMessageMagic
+	}
+	buf := new(bytes.Buffer)
+	writeVarBytes(buf, []byte(magic))
+	writeVarBytes(buf, message)
+	return util.DoubleHash(buf.Bytes())
+}
+
+// SignMessageCompact produces a BIP137 signature of message for the compressed
+// P2PKH address of the private key, with the message magic of its network. The
+// 65 bytes result is usually base64 encoded before being handed out.
+func SignMessageCompact(private []byte, magic string, message []byte) ([]byte, error) {
+	privkey, _ := btcec.PrivKeyFromBytes(private)
+	defer privkey.Zero()
+	// header byte is 31-34 for compressed P2PKH keys
+	return ecdsa.SignCompact(privkey, MessageHash(magic, message), true)
+}
+
+// SignMessageBIP322 produces a BIP322 simple signature of message for a P2WPKH
+// address: the serialized witness of the virtual to_sign transaction.
+func SignMessageBIP322(private []byte, addr string, message []byte) ([]byte, error) {
+	privkey, pubkey := btcec.PrivKeyFromBytes(private)
+	defer privkey.Zero()
+	_, version, program, err := decodeSegwitAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	pubkeyBytes := pubkey.SerializeCompressed()
+	pubkeyHash := util.Hash160(pubkeyBytes)
+	if version != 0 || !bytes.Equal(program, pubkeyHash) {
+		return nil, errors.New("address is not the P2WPKH address of the key")
+	}
+
+	toSign := bip322ToSign(bip322ToSpend(P2WPKHScript(pubkeyHash), message))
+	sigHash := toSign.WitnessSigHash(0, P2PKHScript(pubkeyHash), 0, SigHashAll)
+	sig := append(ecdsa.Sign(privkey, sigHash).Serialize(), SigHashAll)
+
+	witness := new(bytes.Buffer)
+	writeVarInt(witness, 2)
+	writeVarBytes(witness, sig)
+	writeVarBytes(witness, pubkeyBytes)
+	return witness.Bytes(), nil
+}
+
+// bip322MessageHash is the tagged hash committed to by the to_spend transaction
+func bip322MessageHash(message []byte) []byte {
+	tag := sha256.Sum256([]byte(bip322Tag))
+	h := sha256.New()
+	h.Write(tag[:])
+	h.Write(tag[:])
+	h.Write(message)
+	return h.Sum(nil)
+}
+
+func bip322ToSpend(scriptPubKey, message []byte) *Tx {
+	scriptSig := append([]byte{0, 32}, bip322MessageHash(message)...) // OP_0 PUSH32
+	return &Tx{
+		Inputs:  []*TxIn{{PrevIndex: 0xffffffff, Script: scriptSig}},
+		Outputs: []*TxOut{{Script: scriptPubKey}},
+	}
+}
+
+func bip322ToSign(toSpend *Tx) *Tx {
+	in := &TxIn{}
+	copy(in.PrevHash[:], toSpend.Hash())
+	return &Tx{
+		Inputs:  []*TxIn{in},
+		Outputs: []*TxOut{{Script: []byte{106}}}, // OP_RETURN
+	}
+}
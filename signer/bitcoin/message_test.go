@@ -0,0 +1,135 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+
+	"github.com/blockcypher/cryptosigner/util"
+)
+
+// Address of the BIP322 test vectors
+const bip322Addr = "bc1q9vza2e8x573nczrlzms0wvx3gsqjx7vavgkx0l"
+
+func TestBIP322Hashes(t *testing.T) {
+	vectors := []struct{ msg, msgHash, toSpend, toSign string }{
+		{"",
+			"c90c269c4f8fcbe6880f72a721ddfbf1914268a794cbb21cfafee13770ae19f1",
+			"c5680aa69bb8d860bf82d4e9cd3504b55dde018de765a91bb566283c545a99a7",
+			"1e9654e951a5ba44c8604c4de6c67fd78a27e81dcadcfe1edf638ba3aaebaed6"},
+		{"Hello World",
+			"f0eb03b1a75ac6d9847f55c624a99169b5dccba2a31f5b23bea77ba270de0a7a",
+			"b79d196740ad5217771c1098fc4a4b51e0535c32236c71f1ea4d61a2d603352b",
+			"88737ae86f2077145f93cc4b153ae9a1cb8d56afa511988c149c5c8c9d93bddf"},
+	}
+	_, _, program, _ := decodeSegwitAddr(bip322Addr)
+	for _, v := range vectors {
+		if h := hex.EncodeToString(bip322MessageHash([]byte(v.msg))); h != v.msgHash {
+			t.Errorf("message hash for %q: %s", v.msg, h)
+		}
+		toSpend := bip322ToSpend(P2WPKHScript(program), []byte(v.msg))
+		if h := txid(toSpend); h != v.toSpend {
+			t.Errorf("to_spend txid for %q: %s", v.msg, h)
+		}
+		if h := txid(bip322ToSign(toSpend)); h != v.toSign {
+			t.Errorf("to_sign txid for %q: %s", v.msg, h)
+		}
+	}
+}
+
+func TestWitnessSigHash(t *testing.T) {
+	// native P2WPKH example from BIP143
+	data, _ := hex.DecodeString("0100000002fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f" +
+		"0000000000eeffffffef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff" +
+		"02202cb206000000001976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976a9143bde42" +
+		"dbee7e4dbe6a21b2d50ce2f0167faa815988ac11000000")
+	tx, err := ParseTx(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkh, _ := hex.DecodeString("1d0f172a0ecb48aee1be1f2687d2963ae33f71a1")
+	sigHash := tx.WitnessSigHash(1, P2PKHScript(pkh), 600000000, SigHashAll)
+	if hex.EncodeToString(sigHash) != "c37af31116d1b27caf68aae9e3ac82f1477929014d5b917657d0eb49478cb670" {
+		t.Error("Unexpected sighash", hex.EncodeToString(sigHash))
+	}
+}
+
+func TestSignMessageBIP322(t *testing.T) {
+	priv, _ := hex.DecodeString("bb051cd0dda0246f33c5a9e133ebd8e7bc02a92af6c41adc131ccd7826c5affd")
+	pkh := util.Hash160(util.PubKeyFromPrivate(priv))
+	addr := encodeSegwitAddr("bc", 0, pkh)
+	msg := []byte("Hello World")
+	witness, err := SignMessageBIP322(priv, addr, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// witness is the item count, the DER signature with its hash type and the public key
+	if witness[0] != 2 || int(witness[1])+int(witness[witness[1]+2])+3 != len(witness) {
+		t.Fatal("Invalid witness.", hex.EncodeToString(witness))
+	}
+	sig, err := ecdsa.ParseDERSignature(witness[2 : 2+witness[1]-1])
+	if err != nil || witness[1+witness[1]] != SigHashAll {
+		t.Fatal("Invalid signature.", err)
+	}
+	pubkey, err := btcec.ParsePubKey(witness[3+witness[1]:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	toSign := bip322ToSign(bip322ToSpend(P2WPKHScript(pkh), msg))
+	if !sig.Verify(toSign.WitnessSigHash(0, P2PKHScript(pkh), 0, SigHashAll), pubkey) {
+		t.Error("Signature does not verify.")
+	}
+
+	if _, err := SignMessageBIP322(priv, bip322Addr+"x", msg); err == nil {
+		t.Error("Should not sign for an invalid address.")
+	}
+	if _, err := SignMessageBIP322(priv, "bc1qumnwpsyz0sresdl6yv4e7qlrg6nq0uy5vvdtrw", msg); err == nil {
+		t.Error("Should not sign for another address.")
+	}
+}
+
+func TestSignMessageCompact(t *testing.T) {
+	priv, _ := hex.DecodeString("bb051cd0dda0246f33c5a9e133ebd8e7bc02a92af6c41adc131ccd7826c5affd")
+	msg := []byte("proof of reserves 2026-10-19")
+	sig, err := SignMessageCompact(priv, "", msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sig) != 65 || sig[0] < 31 || sig[0] > 34 {
+		t.Fatal("Invalid compact signature header.")
+	}
+	pubkey, compressed, err := ecdsa.RecoverCompact(sig, MessageHash(DefaultMessageMagic, msg))
+	if err != nil || !compressed {
+		t.Fatal("Could not recover public key.", err)
+	}
+	if EncodeAddress(util.Hash160(pubkey.SerializeCompressed()), 0) !=
+		EncodeAddress(util.Hash160(util.PubKeyFromPrivate(priv)), 0) {
+		t.Error("Recovered key does not match.")
+	}
+}
+
+func TestMessageHashMagic(t *testing.T) {
+	msg := []byte("proof of reserves 2026-10-19")
+	bitcoin, litecoin := MessageHash(DefaultMessageMagic, msg), MessageHash("Litecoin Signed Message:\n", msg)
+	if !bytes.Equal(MessageHash("", msg), bitcoin) || bytes.Equal(bitcoin, litecoin) {
+		t.Error("Message hash doesn't depend on the magic.")
+	}
+	priv, _ := hex.DecodeString("bb051cd0dda0246f33c5a9e133ebd8e7bc02a92af6c41adc131ccd7826c5affd")
+	sig, _ := SignMessageCompact(priv, "Litecoin Signed Message:\n", msg)
+	if pubkey, _, err := ecdsa.RecoverCompact(sig, litecoin); err != nil ||
+		!bytes.Equal(pubkey.SerializeCompressed(), util.PubKeyFromPrivate(priv)) {
+		t.Error("Signature not made with the magic.", err)
+	}
+}
+
+func txid(tx *Tx) string {
+	h := tx.Hash()
+	for i := 0; i < len(h)/2; i++ {
+		h[i], h[len(h)-1-i] = h[len(h)-1-i], h[i]
+	}
+	return hex.EncodeToString(h)
+}
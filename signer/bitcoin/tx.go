@@ -0,0 +1,321 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strconv"
+
+	"github.com/blockcypher/cryptosigner/util"
+)
+
+// Minimal transaction model for Bitcoin family coins, enough to parse the
+// transactions we are asked to sign and to compute signature digests.
+
+// Signature hash types the signer produces
+const (
+	SigHashAll = 0x01
+	// SigHashForkID marks Bitcoin Cash replay protected signatures
+	SigHashForkID = 0x40
+)
+
+var errTxTruncated = errors.New("transaction data truncated")
+
+// TxIn is a transaction input
+type TxIn struct {
+	PrevHash  [32]byte
+	PrevIndex uint32
+	Script    []byte
+	Sequence  uint32
+	Witness   [][]byte
+}
+
+// TxOut is a transaction output
+type TxOut struct {
+	Value  uint64
+	Script []byte
+}
+
+// Tx is a transaction
+type Tx struct {
+	Version  uint32
+	Inputs   []*TxIn
+	Outputs  []*TxOut
+	LockTime uint32
+}
+
+// ParseTx reads a serialized transaction. Both the legacy and the segwit
+// serializations are accepted, trailing data is an error.
+func ParseTx(data []byte) (*Tx, error) {
+	r := &txReader{data: data}
+	tx := &Tx{Version: r.uint32()}
+
+	count := r.varInt()
+	segwit := false
+	if count == 0 && r.remaining() > 0 && r.data[r.pos] == 1 {
+		// segwit marker and flag
+		r.pos++
+		segwit = true
+		count = r.varInt()
+	}
+	if count > uint64(r.remaining()/41) {
+		return nil, errTxTruncated
+	}
+	tx.Inputs = make([]*TxIn, count)
+	for n := range tx.Inputs {
+		in := &TxIn{}
+		copy(in.PrevHash[:], r.bytes(32))
+		in.PrevIndex = r.uint32()
+		in.Script = r.varBytes()
+		in.Sequence = r.uint32()
+		tx.Inputs[n] = in
+	}
+
+	count = r.varInt()
+	if count > uint64(r.remaining()/9) {
+		return nil, errTxTruncated
+	}
+	tx.Outputs = make([]*TxOut, count)
+	for n := range tx.Outputs {
+		out := &TxOut{}
+		out.Value = r.uint64()
+		out.Script = r.varBytes()
+		tx.Outputs[n] = out
+	}
+
+	if segwit {
+		for _, in := range tx.Inputs {
+			items := r.varInt()
+			if items > uint64(r.remaining()) {
+				return nil, errTxTruncated
+			}
+			in.Witness = make([][]byte, items)
+			for n := range in.Witness {
+				in.Witness[n] = r.varBytes()
+			}
+		}
+	}
+	tx.LockTime = r.uint32()
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.remaining() != 0 {
+		return nil, errors.New("trailing data after transaction")
+	}
+	return tx, nil
+}
+
+// Bytes serializes the transaction without witness data
+func (tx *Tx) Bytes() []byte {
+	return tx.serialize(false)
+}
+
+// WitnessBytes serializes the transaction including witness data if any input has some
+func (tx *Tx) WitnessBytes() []byte {
+	for _, in := range tx.Inputs {
+		if len(in.Witness) > 0 {
+			return tx.serialize(true)
+		}
+	}
+	return tx.serialize(false)
+}
+
+// Hash is the transaction id, in internal byte order
+func (tx *Tx) Hash() []byte {
+	return util.DoubleHash(tx.Bytes())
+}
+
+func (tx *Tx) serialize(witness bool) []byte {
+	buf := new(bytes.Buffer)
+	writeUint32(buf, tx.Version)
+	if witness {
+		buf.Write([]byte{0, 1})
+	}
+	writeVarInt(buf, uint64(len(tx.Inputs)))
+	for _, in := range tx.Inputs {
+		writeOutpoint(buf, in)
+		writeVarBytes(buf, in.Script)
+		writeUint32(buf, in.Sequence)
+	}
+	writeVarInt(buf, uint64(len(tx.Outputs)))
+	for _, out := range tx.Outputs {
+		writeOutput(buf, out)
+	}
+	if witness {
+		for _, in := range tx.Inputs {
+			writeVarInt(buf, uint64(len(in.Witness)))
+			for _, item := range in.Witness {
+				writeVarBytes(buf, item)
+			}
+		}
+	}
+	writeUint32(buf, tx.LockTime)
+	return buf.Bytes()
+}
+
+// SigHash computes the legacy signature digest of input idx, spending an output locked by
+// scriptCode: the transaction with the script code in place of the input script, other input
+// scripts empty, followed by the hash type.
+func (tx *Tx) SigHash(idx int, scriptCode []byte, hashType uint32) []byte {
+	inputs := make([]*TxIn, len(tx.Inputs))
+	for n, in := range tx.Inputs {
+		clone := *in
+		clone.Script = nil
+		if n == idx {
+			clone.Script = scriptCode
+		}
+		inputs[n] = &clone
+	}
+	stripped := &Tx{tx.Version, inputs, tx.Outputs, tx.LockTime}
+	buf := bytes.NewBuffer(stripped.serialize(false))
+	writeUint32(buf, hashType)
+	return util.DoubleHash(buf.Bytes())
+}
+
+// Outpoint returns the output spent by input idx as txid:vout, the txid in display byte order
+func (tx *Tx) Outpoint(idx int) string {
+	in := tx.Inputs[idx]
+	txid := make([]byte, 32)
+	for n := range txid {
+		txid[n] = in.PrevHash[31-n]
+	}
+	return hex.EncodeToString(txid) + ":" + strconv.FormatUint(uint64(in.PrevIndex), 10)
+}
+
+// WitnessSigHash computes the BIP143 signature digest of input idx, spending
+// an output of the given amount locked by scriptCode.
+func (tx *Tx) WitnessSigHash(idx int, scriptCode []byte, amount uint64, hashType uint32) []byte {
+	var prevouts, sequences, outputs bytes.Buffer
+	for _, in := range tx.Inputs {
+		writeOutpoint(&prevouts, in)
+		writeUint32(&sequences, in.Sequence)
+	}
+	for _, out := range tx.Outputs {
+		writeOutput(&outputs, out)
+	}
+
+	in := tx.Inputs[idx]
+	buf := new(bytes.Buffer)
+	writeUint32(buf, tx.Version)
+	buf.Write(util.DoubleHash(prevouts.Bytes()))
+	buf.Write(util.DoubleHash(sequences.Bytes()))
+	writeOutpoint(buf, in)
+	writeVarBytes(buf, scriptCode)
+	writeUint64(buf, amount)
+	writeUint32(buf, in.Sequence)
+	buf.Write(util.DoubleHash(outputs.Bytes()))
+	writeUint32(buf, tx.LockTime)
+	writeUint32(buf, hashType)
+	return util.DoubleHash(buf.Bytes())
+}
+
+// P2PKHScript builds a pay to public key hash output script
+func P2PKHScript(hash160 []byte) []byte {
+	script := []byte{118, 169, 20} // OP_DUP OP_HASH160 PUSH20
+	script = append(script, hash160...)
+	return append(script, 136, 172) // OP_EQUALVERIFY OP_CHECKSIG
+}
+
+// P2WPKHScript builds a pay to witness public key hash output script
+func P2WPKHScript(hash160 []byte) []byte {
+	return append([]byte{0, 20}, hash160...)
+}
+
+func writeOutpoint(buf *bytes.Buffer, in *TxIn) {
+	buf.Write(in.PrevHash[:])
+	writeUint32(buf, in.PrevIndex)
+}
+
+func writeOutput(buf *bytes.Buffer, out *TxOut) {
+	writeUint64(buf, out.Value)
+	writeVarBytes(buf, out.Script)
+}
+
+func writeUint32(buf *bytes.Buffer, v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	buf.Write(b[:])
+}
+
+func writeUint64(buf *bytes.Buffer, v uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	buf.Write(b[:])
+}
+
+func writeVarInt(buf *bytes.Buffer, v uint64) {
+	switch {
+	case v < 0xfd:
+		buf.WriteByte(byte(v))
+	case v <= 0xffff:
+		buf.WriteByte(0xfd)
+		var b [2]byte
+		binary.LittleEndian.PutUint16(b[:], uint16(v))
+		buf.Write(b[:])
+	case v <= 0xffffffff:
+		buf.WriteByte(0xfe)
+		writeUint32(buf, uint32(v))
+	default:
+		buf.WriteByte(0xff)
+		writeUint64(buf, v)
+	}
+}
+
+func writeVarBytes(buf *bytes.Buffer, data []byte) {
+	writeVarInt(buf, uint64(len(data)))
+	buf.Write(data)
+}
+
+// txReader reads serialized transaction fields, remembering the first error
+type txReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *txReader) remaining() int {
+	return len(r.data) - r.pos
+}
+
+func (r *txReader) bytes(n int) []byte {
+	if r.err != nil || n > r.remaining() {
+		// keep going with zeroes, the error is reported once parsing is over
+		r.err = errTxTruncated
+		return make([]byte, n)
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *txReader) uint32() uint32 {
+	return binary.LittleEndian.Uint32(r.bytes(4))
+}
+
+func (r *txReader) uint64() uint64 {
+	return binary.LittleEndian.Uint64(r.bytes(8))
+}
+
+func (r *txReader) varInt() uint64 {
+	switch b := r.bytes(1)[0]; b {
+	case 0xfd:
+		return uint64(binary.LittleEndian.Uint16(r.bytes(2)))
+	case 0xfe:
+		return uint64(r.uint32())
+	case 0xff:
+		return r.uint64()
+	default:
+		return uint64(b)
+	}
+}
+
+func (r *txReader) varBytes() []byte {
+	n := r.varInt()
+	if n > uint64(r.remaining()) {
+		r.err = errTxTruncated
+		return nil
+	}
+	return r.bytes(int(n))
+}
@@ -1,11 +1,6 @@
 package signer
 
-import (
-	"strings"
-
-	"github.com/blockcypher/cryptosigner/signer/bitcoin"
-	"github.com/blockcypher/cryptosigner/signer/ethereum"
-)
+import "strings"
 
 const (
 	// SignatureChallenge byte iota
@@ -18,10 +13,14 @@ type Challenge interface {
 	Bytes() []byte
 }
 
-// ReadChallenge reads a challenge from bytes
-func ReadChallenge(data []byte, coinFamily CoinFamily) Challenge {
+// ReadChallenge reads a challenge from bytes. The network is nil for keys stored before networks
+// were recorded.
+func ReadChallenge(data []byte, coinFamily CoinFamily, network *Network) Challenge {
 	if data[0] == SignatureChallenge {
 		addrs := strings.Split(string(data[1:]), "|")
+		if network != nil {
+			return NewNetworkChallenge(addrs, network)
+		}
 		return NewSignatureChallenge(addrs, coinFamily)
 	}
 	panic("Unknown challenge type.")
@@ -33,14 +32,25 @@ func ReadChallenge(data []byte, coinFamily CoinFamily) Challenge {
 type sigChallenge struct {
 	addresses  []string
 	coinFamily CoinFamily
+	network    *Network
 }
 
-// NewSignatureChallenge creates a new signature challenge from a slice of addresses
+// NewSignatureChallenge creates a new signature challenge from a slice of addresses. Bitcoin
+// family outputs are matched leniently, without knowing the network of the addresses.
 func NewSignatureChallenge(addresses []string, coinFamily CoinFamily) Challenge {
 	if len(addresses) > 200 {
 		panic("Too many addresses")
 	}
-	return &sigChallenge{addresses, coinFamily}
+	return &sigChallenge{addresses, coinFamily, nil}
+}
+
+// NewNetworkChallenge creates a new signature challenge for addresses on a given network. Bitcoin
+// family outputs must be exactly the scripts of the addresses, as encoded on that network.
+func NewNetworkChallenge(addresses []string, network *Network) Challenge {
+	if len(addresses) > 200 {
+		panic("Too many addresses")
+	}
+	return &sigChallenge{addresses, network.Family, network}
 }
 
 // Check verify a signature challenge
@@ -49,16 +59,19 @@ func (sC *sigChallenge) Check(toSign []byte) bool {
 		return false
 	}
 
-	switch sC.coinFamily {
-	case BitcoinFamily:
-		return bitcoin.VerifyChallenge(sC.addresses, toSign)
-	case EthereumFamily:
-		return ethereum.VerifyChallenge(sC.addresses, toSign)
+	family, err := LookupFamily(sC.coinFamily)
+	if err != nil {
+		return false
 	}
-	return false
+	return family.VerifyChallenge(sC.addresses, sC.network, toSign)
 }
 
 func (sC *sigChallenge) Bytes() []byte {
 	head := []byte{SignatureChallenge}
 	return append(head, []byte(strings.Join(sC.addresses, "|"))...)
 }
+
+// Addresses returns the addresses the challenge allows paying to
+func (sC *sigChallenge) Addresses() []string {
+	return sC.addresses
+}
@@ -0,0 +1,34 @@
+package signer
+
+import (
+	"github.com/blockcypher/cryptosigner/signer/solana"
+	"github.com/blockcypher/cryptosigner/util"
+)
+
+// Ed25519 family (Solana): addresses are the base58 encoded public key. The data to sign is a
+// transaction message, which may only hold system program transfers to the challenge addresses.
+type ed25519Family struct {
+	Signer
+}
+
+func (eF *ed25519Family) Address(pub []byte, network *Network) (string, error) {
+	return solana.EncodeAddress(pub), nil
+}
+
+func (eF *ed25519Family) PublicKey(priv []byte) []byte {
+	return util.Ed25519PubKeyFromPrivate(priv)
+}
+
+func (eF *ed25519Family) ValidateAddress(addr string, network *Network) (string, error) {
+	_, err := solana.DecodeAddress(addr)
+	return addr, err
+}
+
+func (eF *ed25519Family) VerifyChallenge(addresses []string, network *Network, toSign []byte) bool {
+	return solana.VerifyChallenge(addresses, toSign)
+}
+
+func (eF *ed25519Family) Sign(priv, data []byte, network *Network, spent *SpentOutput) ([]byte, []byte, error) {
+	sig, err := eF.Signer.Sign(priv, data)
+	return sig, util.Ed25519PubKeyFromPrivate(priv), err
+}
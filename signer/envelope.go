@@ -0,0 +1,369 @@
+package signer
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Offline signing through files, for signers without network: the online side writes envelopes,
+// JSON files of requests to sign, in a directory with a manifest of their hashes, carried to the
+// signer on removable media. The signer checks the manifest, signs the envelopes, challenges
+// checked as for the API, and writes a signed envelope for each in an output directory with its
+// own manifest, which the online side checks before using the signatures. Hashes only detect
+// accidental changes: manifests are authenticated with an HMAC key shared by both sides, without
+// which anyone able to write the media can rewrite a manifest along with the files.
+
+// EnvelopeVersion is the version of the envelope and manifest formats
+const EnvelopeVersion = 1
+
+// ManifestName is the name of the manifest of a directory of envelopes
+const ManifestName = "manifest.json"
+
+// maxEnvelopeSize bounds the size of an envelope file
+const maxEnvelopeSize = 1 << 20
+
+// maxManifestSize bounds the size of a manifest file
+const maxManifestSize = 16 << 20
+
+// signedSuffix replaces .json in the names of signed envelopes
+const signedSuffix = ".signed.json"
+
+// Manifest errors
+var (
+	// ErrManifestMismatch is returned when the files of a directory don't match its manifest
+	ErrManifestMismatch = errors.New("files don't match the manifest")
+	// ErrManifestUnauthenticated is returned when the MAC of a manifest is missing or wrong, or
+	// when checking an authenticated manifest without the key
+	ErrManifestUnauthenticated = errors.New("manifest not authenticated")
+)
+
+// errFileTooLarge is returned when reading an envelope or manifest over its maximum size
+var errFileTooLarge = errors.New("file too large")
+
+// Envelope is a request to sign from a file, either of an input or of a batch of inputs
+type Envelope struct {
+	Version int `json:"version"`
+	// ID identifies the request, 1 to 64 letters, digits, '.', '_' or '-'
+	ID    string            `json:"id"`
+	Sign  *SignRequest      `json:"sign,omitempty"`
+	Batch *BatchSignRequest `json:"batch,omitempty"`
+	// Metadata is copied to the signed envelope, for the online side
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// SignedEnvelope is the response to an envelope, the signatures or the error
+type SignedEnvelope struct {
+	Version int    `json:"version"`
+	ID      string `json:"id,omitempty"`
+	// Request is the name of the envelope file, RequestHash its SHA-256
+	Request     string             `json:"request"`
+	RequestHash string             `json:"requestHash"`
+	Metadata    map[string]string  `json:"metadata,omitempty"`
+	Sign        *SignResponse      `json:"sign,omitempty"`
+	Batch       *BatchSignResponse `json:"batch,omitempty"`
+	Error       *APIError          `json:"error,omitempty"`
+	Signed      time.Time          `json:"signed"`
+}
+
+// Manifest lists the files of a directory of envelopes with their SHA-256, hex encoded. MAC is
+// the hex encoded HMAC-SHA256 of the manifest JSON without MAC, written with a manifest key.
+type Manifest struct {
+	Version int             `json:"version"`
+	Created time.Time       `json:"created"`
+	Files   []*ManifestFile `json:"files"`
+	MAC     string          `json:"mac,omitempty"`
+}
+
+// ManifestFile is a file of a manifest
+type ManifestFile struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+}
+
+// envelopeFiles lists the JSON files of a directory but its manifest, sorted
+func envelopeFiles(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, info := range infos {
+		name := info.Name()
+		if info.Mode().IsRegular() && strings.HasSuffix(name, ".json") && name != ManifestName {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// LoadManifestKey reads a manifest key, at least 32 hex encoded bytes, from a file
+func LoadManifestKey(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) < minHMACSecretLen {
+		return nil, errors.New("Manifest key must be at least 32 hex encoded bytes")
+	}
+	return key, nil
+}
+
+// mac returns the MAC of the manifest with the key
+func (m *Manifest) mac(key []byte) string {
+	unsigned := *m
+	unsigned.MAC = ""
+	data, _ := json.Marshal(&unsigned)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// WriteManifest writes the manifest of the envelopes of a directory, authenticated when the key
+// isn't nil
+func WriteManifest(dir string, key []byte) (*Manifest, error) {
+	names, err := envelopeFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{Version: EnvelopeVersion, Created: time.Now().UTC().Truncate(time.Second)}
+	for _, name := range names {
+		data, err := readFileLimited(filepath.Join(dir, name), maxEnvelopeSize)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Files = append(manifest.Files, &ManifestFile{name, dataHash(data)})
+	}
+	if key != nil {
+		manifest.MAC = manifest.mac(key)
+	}
+	data, _ := json.MarshalIndent(manifest, "", "  ")
+	if err := writeNewFile(filepath.Join(dir, ManifestName), data); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// VerifyManifest checks the envelopes of a directory are the ones of its manifest, unaltered.
+// With a key, the manifest must be authenticated by it.
+func VerifyManifest(dir string, key []byte) (*Manifest, error) {
+	data, err := readFileLimited(filepath.Join(dir, ManifestName), maxManifestSize)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("Invalid manifest: %v", err)
+	}
+	if manifest.Version != EnvelopeVersion {
+		return nil, fmt.Errorf("Unsupported manifest version %d", manifest.Version)
+	}
+	switch {
+	case key == nil && len(manifest.MAC) > 0:
+		return nil, fmt.Errorf("%w: key needed to check its MAC", ErrManifestUnauthenticated)
+	case key != nil && len(manifest.MAC) == 0:
+		return nil, fmt.Errorf("%w: no MAC", ErrManifestUnauthenticated)
+	case key != nil && !hmac.Equal([]byte(manifest.MAC), []byte(manifest.mac(key))):
+		return nil, fmt.Errorf("%w: wrong MAC", ErrManifestUnauthenticated)
+	}
+	names, err := envelopeFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	listed := make(map[string]string)
+	for _, file := range manifest.Files {
+		listed[file.Name] = file.SHA256
+	}
+	if len(listed) != len(names) {
+		return nil, fmt.Errorf("%w: %d files listed, %d found", ErrManifestMismatch, len(listed), len(names))
+	}
+	for _, name := range names {
+		hash, ok := listed[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s not listed", ErrManifestMismatch, name)
+		}
+		data, err := readFileLimited(filepath.Join(dir, name), maxEnvelopeSize)
+		if err != nil {
+			return nil, err
+		}
+		if dataHash(data) != hash {
+			return nil, fmt.Errorf("%w: %s altered", ErrManifestMismatch, name)
+		}
+	}
+	return manifest, nil
+}
+
+// EnvelopeSummary counts the envelopes signed and failed
+type EnvelopeSummary struct {
+	Signed int
+	Failed int
+	// Verified tells whether the input manifest was checked, there may be none
+	Verified bool
+}
+
+// SignEnvelopes signs the envelopes of the input directory and writes the signed envelopes and
+// their manifest in the output directory, created if needed and empty. Nothing is signed unless
+// the envelopes match the manifest of the input directory, which may only be missing when not
+// required. With a manifest key, the input manifest must be authenticated by it and the output
+// manifest is. Envelopes failing are answered with their error.
+func (sh *SigningHandler) SignEnvelopes(client, in, out string, key []byte, requireManifest bool) (*EnvelopeSummary, error) {
+	summary := &EnvelopeSummary{}
+	// hashes of the verified manifest, nil without one
+	var listed map[string]string
+	if _, err := os.Stat(filepath.Join(in, ManifestName)); err == nil || requireManifest {
+		manifest, err := VerifyManifest(in, key)
+		if err != nil {
+			return nil, err
+		}
+		listed = make(map[string]string)
+		for _, file := range manifest.Files {
+			listed[file.Name] = file.SHA256
+		}
+		summary.Verified = true
+	}
+	names, err := envelopeFiles(in)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(out, 0700); err != nil {
+		return nil, err
+	}
+	if existing, err := ioutil.ReadDir(out); err != nil || len(existing) > 0 {
+		if err == nil {
+			err = errors.New("Output directory " + out + " is not empty")
+		}
+		return nil, err
+	}
+
+	ids := make(map[string]bool)
+	for _, name := range names {
+		signed, err := sh.signEnvelopeFile(client, in, name, listed, ids)
+		if err != nil {
+			return summary, err
+		}
+		if signed.Error != nil {
+			summary.Failed++
+			log.Println("envelope |", name, "failed:", signed.Error.Message)
+		} else {
+			summary.Signed++
+			log.Println("envelope |", name, "signed")
+		}
+		result, _ := json.MarshalIndent(signed, "", "  ")
+		if err := writeNewFile(filepath.Join(out, strings.TrimSuffix(name, ".json")+signedSuffix), result); err != nil {
+			return summary, err
+		}
+	}
+	_, err = WriteManifest(out, key)
+	return summary, err
+}
+
+// signEnvelopeFile signs an envelope file of the input directory. With a manifest, the envelope
+// read must be the one listed: the file may have been rewritten since the manifest was verified.
+func (sh *SigningHandler) signEnvelopeFile(client, dir, name string, listed map[string]string, ids map[string]bool) (*SignedEnvelope, error) {
+	data, err := readFileLimited(filepath.Join(dir, name), maxEnvelopeSize)
+	switch {
+	case errors.Is(err, errFileTooLarge):
+		return &SignedEnvelope{Version: EnvelopeVersion, Request: name, Signed: time.Now().UTC().Truncate(time.Second),
+			Error: &APIError{Status: http.StatusRequestEntityTooLarge, Code: CodeTooLarge, Message: "Envelope too large."}}, nil
+	case err != nil:
+		return nil, err
+	case listed != nil && listed[name] != dataHash(data):
+		return &SignedEnvelope{Version: EnvelopeVersion, Request: name, RequestHash: dataHash(data),
+			Signed: time.Now().UTC().Truncate(time.Second),
+			Error:  badRequest(CodeBadRequest, "", "Envelope does not match the manifest.")}, nil
+	}
+	signed := sh.signEnvelope(client, data, ids)
+	signed.Request, signed.RequestHash = name, dataHash(data)
+	return signed, nil
+}
+
+// signEnvelope signs an envelope, ids holding the IDs of the envelopes signed before
+func (sh *SigningHandler) signEnvelope(client string, data []byte, ids map[string]bool) *SignedEnvelope {
+	signed := &SignedEnvelope{Version: EnvelopeVersion}
+	envelope := &Envelope{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var err error
+	switch {
+	case decoder.Decode(envelope) != nil:
+		err = badRequest(CodeBadRequest, "", "Invalid envelope.")
+	case envelope.Version != EnvelopeVersion:
+		err = badRequest(CodeInvalidField, "version", fmt.Sprintf("Unsupported envelope version %d.", envelope.Version))
+	case !ValidRequestID(envelope.ID):
+		err = badRequest(CodeInvalidField, "id", "Invalid envelope ID.")
+	case ids[envelope.ID]:
+		err = badRequest(CodeInvalidField, "id", "Envelope ID already used in the directory.")
+	case (envelope.Sign == nil) == (envelope.Batch == nil):
+		err = badRequest(CodeInvalidField, "sign", "Either sign or batch expected.")
+	}
+	if err == nil {
+		ids[envelope.ID] = true
+		if envelope.Sign != nil {
+			signed.Sign, err = sh.sign(client, envelope.Sign)
+		} else {
+			signed.Batch, err = sh.signBatch(client, envelope.Batch)
+		}
+	}
+	signed.ID, signed.Metadata = envelope.ID, envelope.Metadata
+	signed.Signed = time.Now().UTC().Truncate(time.Second)
+	if err != nil {
+		signed.Error = toAPIError(err)
+		signed.Sign, signed.Batch = nil, nil
+	}
+	return signed
+}
+
+// readFileLimited reads a file of at most max bytes, its size checked before reading it.
+// errFileTooLarge is returned for larger files.
+func readFileLimited(path string, max int64) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > max {
+		return nil, fmt.Errorf("%w: %s over %d bytes", errFileTooLarge, path, max)
+	}
+	// the file may grow after the stat
+	data, err := ioutil.ReadAll(io.LimitReader(file, max+1))
+	if err == nil && int64(len(data)) > max {
+		err = fmt.Errorf("%w: %s over %d bytes", errFileTooLarge, path, max)
+	}
+	return data, err
+}
+
+// writeNewFile writes a file which must not exist, synced
+func writeNewFile(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
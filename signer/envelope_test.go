@@ -0,0 +1,182 @@
+package signer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeEnvelope(t *testing.T, dir, name string, envelope *Envelope) {
+	data, _ := json.Marshal(envelope)
+	if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readSigned(t *testing.T, dir, name string) *SignedEnvelope {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := &SignedEnvelope{}
+	if err := json.Unmarshal(data, signed); err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestSignEnvelopes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envelopes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	in, out := filepath.Join(dir, "in"), filepath.Join(dir, "out")
+	os.Mkdir(in, 0700)
+
+	hold := testHold()
+	addr, _ := hold.NewKey(NewSignatureChallenge([]string{ADDR1}, BitcoinFamily), btcNetwork(), nil)
+	sh := &SigningHandler{hold: hold}
+	writeEnvelope(t, in, "a.json", &Envelope{Version: EnvelopeVersion, ID: "withdrawal-1",
+		Sign: &SignRequest{SourceAddr: addr, TxData: TxData1}, Metadata: map[string]string{"ticket": "42"}})
+	writeEnvelope(t, in, "b.json", &Envelope{Version: EnvelopeVersion, ID: "withdrawal-2",
+		Sign: &SignRequest{SourceAddr: addr, TxData: TxData2}})
+	writeEnvelope(t, in, "c.json", &Envelope{Version: EnvelopeVersion, ID: "withdrawal-1",
+		Sign: &SignRequest{SourceAddr: addr, TxData: TxData1}})
+
+	if _, err := sh.SignEnvelopes("cli", in, out, nil, true); err == nil {
+		t.Error("Envelopes without manifest should not be signed")
+	}
+	if _, err := WriteManifest(in, nil); err != nil {
+		t.Fatal(err)
+	}
+	summary, err := sh.SignEnvelopes("cli", in, out, nil, true)
+	if err != nil || summary.Signed != 1 || summary.Failed != 2 || !summary.Verified {
+		t.Fatal("Unexpected signature of envelopes:", summary, err)
+	}
+	signed := readSigned(t, out, "a.signed.json")
+	if signed.Error != nil || signed.Sign == nil || len(signed.Sign.Signature) == 0 || signed.ID != "withdrawal-1" ||
+		signed.Metadata["ticket"] != "42" || signed.Request != "a.json" || len(signed.RequestHash) != 64 {
+		t.Errorf("Unexpected signed envelope: %+v", signed)
+	}
+	if signed := readSigned(t, out, "b.signed.json"); signed.Error == nil || signed.Error.Code != CodeChallengeFailed {
+		t.Errorf("Envelope failing its challenge should not be signed: %+v", signed)
+	}
+	if signed := readSigned(t, out, "c.signed.json"); signed.Error == nil || signed.Error.Field != "id" {
+		t.Errorf("Envelope reusing an ID should not be signed: %+v", signed)
+	}
+
+	if manifest, err := VerifyManifest(out, nil); err != nil || len(manifest.Files) != 3 {
+		t.Fatal("Output manifest not verified:", err)
+	}
+	ioutil.WriteFile(filepath.Join(out, "a.signed.json"), []byte("{}"), 0600)
+	if _, err := VerifyManifest(out, nil); !errors.Is(err, ErrManifestMismatch) {
+		t.Error("Altered signed envelope not detected:", err)
+	}
+	if _, err := sh.SignEnvelopes("cli", in, out, nil, true); err == nil {
+		t.Error("Output directory not empty should be refused")
+	}
+	writeEnvelope(t, in, "d.json", &Envelope{Version: EnvelopeVersion, ID: "withdrawal-3",
+		Sign: &SignRequest{SourceAddr: addr, TxData: TxData1}})
+	if _, err := sh.SignEnvelopes("cli", in, filepath.Join(dir, "out2"), nil, false); !errors.Is(err, ErrManifestMismatch) {
+		t.Error("Envelope added after the manifest not detected:", err)
+	}
+}
+
+func TestSignEnvelopesAuthenticated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envelopes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	in, out := filepath.Join(dir, "in"), filepath.Join(dir, "out")
+	os.Mkdir(in, 0700)
+	key, other := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+
+	hold := testHold()
+	addr, _ := hold.NewKey(NewSignatureChallenge([]string{ADDR1}, BitcoinFamily), btcNetwork(), nil)
+	sh := &SigningHandler{hold: hold}
+	writeEnvelope(t, in, "a.json", &Envelope{Version: EnvelopeVersion, ID: "withdrawal-1",
+		Sign: &SignRequest{SourceAddr: addr, TxData: TxData1}})
+	ioutil.WriteFile(filepath.Join(in, "b.json"), bytes.Repeat([]byte{' '}, maxEnvelopeSize+1), 0600)
+
+	if _, err := WriteManifest(in, nil); err == nil {
+		t.Error("Manifest of an envelope too large should not be written")
+	}
+	if _, err := sh.SignEnvelopes("cli", in, out, key, false); err != nil {
+		t.Fatal(err)
+	}
+	if signed := readSigned(t, out, "b.signed.json"); signed.Error == nil || signed.Error.Code != CodeTooLarge {
+		t.Errorf("Envelope too large should not be signed: %+v", signed)
+	}
+	os.Remove(filepath.Join(in, "b.json"))
+	os.RemoveAll(out)
+
+	if _, err := WriteManifest(in, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sh.SignEnvelopes("cli", in, out, key, true); !errors.Is(err, ErrManifestUnauthenticated) {
+		t.Error("Manifest without MAC should be refused:", err)
+	}
+	os.Remove(filepath.Join(in, ManifestName))
+	if _, err := WriteManifest(in, key); err != nil {
+		t.Fatal(err)
+	}
+	if summary, err := sh.SignEnvelopes("cli", in, out, key, true); err != nil || summary.Signed != 1 {
+		t.Fatal("Unexpected signature of envelopes:", summary, err)
+	}
+	if _, err := VerifyManifest(out, key); err != nil {
+		t.Error("Output manifest not verified:", err)
+	}
+	for _, k := range [][]byte{nil, other} {
+		if _, err := VerifyManifest(out, k); !errors.Is(err, ErrManifestUnauthenticated) {
+			t.Error("Output manifest verified without its key:", err)
+		}
+	}
+
+	// rewriting a signed envelope along with the manifest
+	ioutil.WriteFile(filepath.Join(out, "a.signed.json"), []byte("{}"), 0600)
+	data, _ := ioutil.ReadFile(filepath.Join(out, ManifestName))
+	manifest := &Manifest{}
+	json.Unmarshal(data, manifest)
+	manifest.Files[0].SHA256 = dataHash([]byte("{}"))
+	data, _ = json.Marshal(manifest)
+	ioutil.WriteFile(filepath.Join(out, ManifestName), data, 0600)
+	if _, err := VerifyManifest(out, key); !errors.Is(err, ErrManifestUnauthenticated) {
+		t.Error("Rewritten manifest not detected:", err)
+	}
+}
+
+func TestSignEnvelopeRewritten(t *testing.T) {
+	in, err := ioutil.TempDir("", "envelopes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(in)
+
+	hold := testHold()
+	addr, _ := hold.NewKey(NewSignatureChallenge([]string{ADDR1}, BitcoinFamily), btcNetwork(), nil)
+	sh := &SigningHandler{hold: hold}
+	writeEnvelope(t, in, "a.json", &Envelope{Version: EnvelopeVersion, ID: "withdrawal-1",
+		Sign: &SignRequest{SourceAddr: addr, TxData: TxData1}})
+	manifest, err := WriteManifest(in, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed := map[string]string{"a.json": manifest.Files[0].SHA256}
+
+	// rewritten between the verification of the manifest and its signature
+	writeEnvelope(t, in, "a.json", &Envelope{Version: EnvelopeVersion, ID: "withdrawal-2",
+		Sign: &SignRequest{SourceAddr: addr, TxData: TxData1}})
+	signed, err := sh.signEnvelopeFile("cli", in, "a.json", listed, make(map[string]bool))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed.Error == nil || signed.Sign != nil || hold.keys[addr].uses != 0 {
+		t.Errorf("Envelope not matching the manifest should not be signed: %+v", signed)
+	}
+}
@@ -0,0 +1,33 @@
+package ethereum
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Address validation errors
+var (
+	ErrAddressFormat = errors.New("invalid address encoding")
+	ErrEIP55Checksum = errors.New("invalid EIP-55 checksum")
+)
+
+// NormalizeAddress checks a hex address, with or without 0x, and returns it the way challenges
+// hold addresses: lowercase without 0x. Mixed case addresses must have a valid EIP-55 checksum.
+func NormalizeAddress(addr string) (string, error) {
+	hexAddr := strings.TrimPrefix(addr, "0x")
+	if len(hexAddr) != 40 {
+		return "", ErrAddressFormat
+	}
+	if _, err := hex.DecodeString(hexAddr); err != nil {
+		return "", ErrAddressFormat
+	}
+	lower := strings.ToLower(hexAddr)
+	if hexAddr != lower && hexAddr != strings.ToUpper(hexAddr) &&
+		common.HexToAddress(lower).Hex()[2:] != hexAddr {
+		return "", ErrEIP55Checksum
+	}
+	return lower, nil
+}
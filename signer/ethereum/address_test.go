@@ -0,0 +1,23 @@
+package ethereum
+
+import "testing"
+
+func TestNormalizeAddress(t *testing.T) {
+	vectors := []struct {
+		addr, normalized string
+		err              error
+	}{
+		{"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", nil},
+		{"fb6916095ca1df60bb79ce92ce3ea74c37c5d359", "fb6916095ca1df60bb79ce92ce3ea74c37c5d359", nil},
+		{"0xDBF03B407C01E7CD3CBEA99509D93F8DDDC8C6FB", "dbf03b407c01e7cd3cbea99509d93f8dddc8c6fb", nil},
+		{"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeD", "", ErrEIP55Checksum},
+		{"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeA", "", ErrAddressFormat},
+		{"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAzz", "", ErrAddressFormat},
+	}
+	for _, v := range vectors {
+		normalized, err := NormalizeAddress(v.addr)
+		if normalized != v.normalized || err != v.err {
+			t.Errorf("%s: got %q, %v", v.addr, normalized, err)
+		}
+	}
+}
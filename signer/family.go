@@ -0,0 +1,254 @@
+package signer
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/blockcypher/cryptosigner/signer/bitcoin"
+	"github.com/blockcypher/cryptosigner/signer/ethereum"
+	"github.com/blockcypher/cryptosigner/util"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Family implements what is specific to a coin family: key generation, address encoding, challenge
+// verification and signing. Networks of the family provide the parameters.
+type Family interface {
+	// NewKey generates a new key pair
+	NewKey() (pub, priv []byte, err error)
+	// Address encodes the address of a public key on the network
+	Address(pub []byte, network *Network) (string, error)
+	// ValidateAddress checks a target address and returns it the way challenges hold it
+	ValidateAddress(addr string, network *Network) (string, error)
+	// VerifyChallenge checks that the data to sign only pays to the addresses. The network is nil
+	// for keys stored before networks were recorded.
+	VerifyChallenge(addresses []string, network *Network, toSign []byte) bool
+	// Sign signs the data, returns the signature and the public key. The network may be nil, the
+	// spent output is nil unless provided by the client.
+	Sign(priv, data []byte, network *Network, spent *SpentOutput) (sig, pub []byte, err error)
+}
+
+// InputSigner is implemented by families able to sign any input of a whole unsigned transaction,
+// as needed for batch signing. The spent output is required.
+type InputSigner interface {
+	SignTxInput(priv, tx []byte, network *Network, spent *SpentOutput) (sig, pub []byte, err error)
+}
+
+// PublicKeyDeriver is implemented by families able to derive the public key of a private key, to
+// verify stored keys
+type PublicKeyDeriver interface {
+	PublicKey(priv []byte) []byte
+}
+
+// AddressTyper is implemented by families able to encode the address of a key with several address
+// types, for keys created with one
+type AddressTyper interface {
+	TypedAddress(pub []byte, network *Network, addrType bitcoin.AddressType) (string, error)
+}
+
+// MessageSigner is implemented by families able to sign arbitrary messages with a key
+type MessageSigner interface {
+	SignMessage(priv []byte, addr string, network *Network, message []byte) ([]byte, error)
+}
+
+var families = map[CoinFamily]Family{
+	BitcoinFamily:     &bitcoinFamily{&util.ECDSASigner{}},
+	BitcoinCashFamily: &bitcoinCashFamily{&util.ECDSASigner{}},
+	EthereumFamily:    &ethereumFamily{&util.ECDSASigner{}},
+	Ed25519Family:     &ed25519Family{&util.Ed25519Signer{}},
+}
+
+// RegisterFamily adds or replaces the implementation of a coin family
+func RegisterFamily(coinFamily CoinFamily, family Family) {
+	families[coinFamily] = family
+}
+
+// LookupFamily finds the implementation of a coin family
+func LookupFamily(coinFamily CoinFamily) (Family, error) {
+	family := families[coinFamily]
+	if family == nil {
+		return nil, errors.New("Unknown coin family")
+	}
+	return family, nil
+}
+
+// Bitcoin family: legacy addresses for new keys unless created P2WPKH, legacy signature digest
+// computed from the transaction data provided with the script code of the input to sign already in
+// place. P2WPKH keys sign the BIP143 digest of the whole transaction.
+type bitcoinFamily struct {
+	Signer
+}
+
+func (bF *bitcoinFamily) Address(pub []byte, network *Network) (string, error) {
+	return bitcoin.EncodeAddress(util.Hash160(pub), network.P2PKHVersion), nil
+}
+
+// TypedAddress encodes a P2PKH or, on networks with segwit, a P2WPKH address
+func (bF *bitcoinFamily) TypedAddress(pub []byte, network *Network, addrType bitcoin.AddressType) (string, error) {
+	switch addrType {
+	case bitcoin.P2PKH:
+		return bF.Address(pub, network)
+	case bitcoin.P2WPKH:
+		if len(network.Bech32HRP) > 0 && network.Supports(bitcoin.P2WPKH) {
+			return bitcoin.EncodeSegwitAddress(network.Bech32HRP, 0, util.Hash160(pub)), nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s on %s", ErrAddressType, addrType, network.Name)
+}
+
+func (bF *bitcoinFamily) PublicKey(priv []byte) []byte {
+	return util.PubKeyFromPrivate(priv)
+}
+
+func (bF *bitcoinFamily) ValidateAddress(addr string, network *Network) (string, error) {
+	_, _, err := bitcoin.DecodeAddress(addr, &network.Params)
+	return addr, err
+}
+
+func (bF *bitcoinFamily) VerifyChallenge(addresses []string, network *Network, toSign []byte) bool {
+	if network != nil {
+		return bitcoin.VerifyOutputs(addresses, &network.Params, toSign)
+	}
+	return bitcoin.VerifyChallenge(addresses, toSign)
+}
+
+func (bF *bitcoinFamily) Sign(priv, data []byte, network *Network, spent *SpentOutput) ([]byte, []byte, error) {
+	if spent != nil && spent.Witness {
+		return bF.SignTxInput(priv, data, network, spent)
+	}
+	// data passed is the digested tx bytes to sign, what we sign is the double-sha of that
+	sigBytes := append(data, []byte{1, 0, 0, 0}...)
+	sig, err := bF.Signer.Sign(priv, util.DoubleHash(sigBytes))
+	return sig, util.PubKeyFromPrivate(priv), err
+}
+
+// SignTxInput signs a P2PKH input with the legacy signature digest, a P2WPKH input with the BIP143
+// one
+func (bF *bitcoinFamily) SignTxInput(priv, data []byte, network *Network, spent *SpentOutput) ([]byte, []byte, error) {
+	tx, err := parseSpendingTx(data, spent)
+	if err != nil {
+		return nil, nil, err
+	}
+	pubkey := util.PubKeyFromPrivate(priv)
+	scriptCode := bitcoin.P2PKHScript(util.Hash160(pubkey))
+	var digest []byte
+	if spent.Witness {
+		digest = tx.WitnessSigHash(spent.InputIndex, scriptCode, spent.Amount, bitcoin.SigHashAll)
+	} else {
+		digest = tx.SigHash(spent.InputIndex, scriptCode, bitcoin.SigHashAll)
+	}
+	sig, err := bF.Signer.Sign(priv, digest)
+	return sig, pubkey, err
+}
+
+func (bF *bitcoinFamily) SignMessage(priv []byte, addr string, network *Network, message []byte) ([]byte, error) {
+	if bitcoin.IsSegwitAddress(addr) {
+		return bitcoin.SignMessageBIP322(priv, addr, message)
+	}
+	return bitcoin.SignMessageCompact(priv, network.MessageMagic, message)
+}
+
+// Bitcoin Cash family: CashAddr addresses for new keys, replay protected BIP143 style signature
+// digest computed from the whole transaction.
+type bitcoinCashFamily struct {
+	Signer
+}
+
+func (bF *bitcoinCashFamily) Address(pub []byte, network *Network) (string, error) {
+	return bitcoin.EncodeCashAddr(network.CashAddrPrefix, util.Hash160(pub), false), nil
+}
+
+func (bF *bitcoinCashFamily) PublicKey(priv []byte) []byte {
+	return util.PubKeyFromPrivate(priv)
+}
+
+func (bF *bitcoinCashFamily) ValidateAddress(addr string, network *Network) (string, error) {
+	_, _, err := bitcoin.DecodeAddress(addr, &network.Params)
+	return addr, err
+}
+
+func (bF *bitcoinCashFamily) VerifyChallenge(addresses []string, network *Network, toSign []byte) bool {
+	return network != nil && bitcoin.VerifyOutputs(addresses, &network.Params, toSign)
+}
+
+func (bF *bitcoinCashFamily) Sign(priv, data []byte, network *Network, spent *SpentOutput) ([]byte, []byte, error) {
+	tx, err := parseSpendingTx(data, spent)
+	if err != nil {
+		return nil, nil, err
+	}
+	pubkey := util.PubKeyFromPrivate(priv)
+	scriptCode := bitcoin.P2PKHScript(util.Hash160(pubkey))
+	digest := tx.WitnessSigHash(spent.InputIndex, scriptCode, spent.Amount,
+		bitcoin.SigHashAll|bitcoin.SigHashForkID)
+	sig, err := bF.Signer.Sign(priv, digest)
+	return sig, pubkey, err
+}
+
+func (bF *bitcoinCashFamily) SignTxInput(priv, data []byte, network *Network, spent *SpentOutput) ([]byte, []byte, error) {
+	return bF.Sign(priv, data, network, spent)
+}
+
+// parseSpendingTx parses a whole unsigned transaction and checks the input of the spent output
+func parseSpendingTx(data []byte, spent *SpentOutput) (*bitcoin.Tx, error) {
+	tx, err := bitcoin.ParseTx(data)
+	if err != nil {
+		return nil, err
+	}
+	if spent == nil || spent.InputIndex < 0 || spent.InputIndex >= len(tx.Inputs) {
+		return nil, fmt.Errorf("%w: missing or invalid input index and amount", ErrInvalidInput)
+	}
+	if len(spent.Outpoint) > 0 && spent.Outpoint != tx.Outpoint(spent.InputIndex) {
+		return nil, fmt.Errorf("%w: input %d does not spend %s", ErrInvalidInput, spent.InputIndex, spent.Outpoint)
+	}
+	return tx, nil
+}
+
+// Ethereum family: addresses are the last 20 bytes of the Keccak-256 of the public key, held
+// lowercase without 0x. Signs RLP encoded transactions with the chain id of the network.
+type ethereumFamily struct {
+	Signer
+}
+
+func (eF *ethereumFamily) Address(pub []byte, network *Network) (string, error) {
+	epub, err := crypto.DecompressPubkey(pub)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(crypto.PubkeyToAddress(*epub).String()[2:]), nil
+}
+
+func (eF *ethereumFamily) PublicKey(priv []byte) []byte {
+	return util.PubKeyFromPrivate(priv)
+}
+
+func (eF *ethereumFamily) ValidateAddress(addr string, network *Network) (string, error) {
+	return ethereum.NormalizeAddress(addr)
+}
+
+func (eF *ethereumFamily) VerifyChallenge(addresses []string, network *Network, toSign []byte) bool {
+	return ethereum.VerifyChallenge(addresses, toSign)
+}
+
+func (eF *ethereumFamily) Sign(priv, data []byte, network *Network, spent *SpentOutput) ([]byte, []byte, error) {
+	var tx *types.Transaction
+	if err := rlp.DecodeBytes(data, &tx); err != nil {
+		return nil, nil, err
+	}
+	epriv, err := crypto.ToECDSA(priv)
+	if err != nil {
+		return nil, nil, err
+	} else if epriv == nil {
+		return nil, nil, errors.New("Invalid private key")
+	}
+	defer util.WipeECDSA(epriv)
+	if network == nil {
+		network = defaultNetwork(EthereumFamily)
+	}
+	s := types.NewEIP155Signer(big.NewInt(network.ChainID))
+	h := s.Hash(tx)
+	sig, err := crypto.Sign(h[:], epriv)
+	return sig, util.PubKeyFromPrivate(priv), err
+}
@@ -0,0 +1,103 @@
+package signer
+
+import (
+	"errors"
+	"log"
+	"os"
+)
+
+// Global freeze: during an incident all signing is refused without stopping the signer, by an
+// admin call or a signal, until an unfreeze by a client granted the unfreeze operation. Keys can
+// still be created. The freeze is saved in the store when it supports flags.
+
+// ErrSignerFrozen is returned by signatures while the signer is frozen
+var ErrSignerFrozen = errors.New("signer is frozen, all signing refused")
+
+// frozenFlag is the store flag of the freeze
+const frozenFlag = "frozen"
+
+// Freeze refuses all signatures until Unfreeze. The freeze applies even if it can't be saved,
+// the error is returned.
+func (h *Hold) Freeze() error {
+	h.keyslock.Lock()
+	h.frozen = true
+	h.keyslock.Unlock()
+	if flags, ok := h.store.(FlagStore); ok {
+		return flags.SetFlag(frozenFlag, true)
+	}
+	return nil
+}
+
+// Unfreeze allows signatures again. The signer stays frozen if the unfreeze can't be saved.
+func (h *Hold) Unfreeze() error {
+	if flags, ok := h.store.(FlagStore); ok {
+		if err := flags.SetFlag(frozenFlag, false); err != nil {
+			return err
+		}
+	}
+	h.keyslock.Lock()
+	h.frozen = false
+	h.keyslock.Unlock()
+	return nil
+}
+
+// Frozen tells whether signing is frozen
+func (h *Hold) Frozen() bool {
+	h.keyslock.RLock()
+	defer h.keyslock.RUnlock()
+	return h.frozen
+}
+
+// FreezeResponse is the state of the freeze after a freeze or unfreeze
+type FreezeResponse struct {
+	Frozen bool `json:"frozen"`
+}
+
+// setFrozen serves admin/freeze and admin/unfreeze
+func (sh *SigningHandler) setFrozen(client string, frozen bool) (resp *FreezeResponse, err error) {
+	entry := &AuditEntry{Event: "unfreeze", Client: client}
+	op, outcome := OpUnfreeze, OutcomeUnfrozen
+	if frozen {
+		entry.Event, op, outcome = "freeze", OpAdmin, OutcomeFrozen
+	}
+	defer func() {
+		if err = sh.record(entry, outcome, err); err != nil {
+			resp = nil
+		}
+	}()
+
+	if err := sh.authorize(client, op, ""); err != nil {
+		return nil, err
+	}
+	if frozen {
+		err = sh.hold.Freeze()
+	} else {
+		err = sh.hold.Unfreeze()
+	}
+	if err != nil {
+		log.Println(entry.Event, "| not saved:", err, clientLog(client))
+		return nil, err
+	}
+	log.Println(entry.Event, "|", clientLog(client))
+	return &FreezeResponse{sh.hold.Frozen()}, nil
+}
+
+// freezeOnSignal freezes the hold on each of the freeze signals, until stopped
+func freezeOnSignal(hold *Hold, audit *AuditLog, sigs <-chan os.Signal, stop <-chan struct{}) {
+	for {
+		select {
+		case sig := <-sigs:
+			entry := &AuditEntry{Event: "freeze", Client: "signal " + sig.String(), Outcome: OutcomeFrozen}
+			log.Println("Received", sig, "signing frozen.")
+			if err := hold.Freeze(); err != nil {
+				log.Println("Freeze not saved:", err)
+				entry.Error = "not saved: " + err.Error()
+			}
+			if err := audit.Record(entry, nil); err != nil {
+				log.Println("Could not audit freeze:", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package signer
+
+import (
+	"os"
+	"syscall"
+)
+
+// freezeSignals freeze the signer
+var freezeSignals = []os.Signal{syscall.SIGUSR1}
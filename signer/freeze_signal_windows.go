@@ -0,0 +1,6 @@
+package signer
+
+import "os"
+
+// freezeSignals freeze the signer, none on Windows
+var freezeSignals []os.Signal
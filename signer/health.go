@@ -0,0 +1,97 @@
+package signer
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/blockcypher/cryptosigner/signer/api"
+)
+
+// Health and status endpoints, for orchestrators and operators. /healthz, /readyz and /metrics
+// are not authenticated and only tell whether the signer is up and able to sign, /status needs a known
+// client and never lists addresses.
+
+// ReadyResponse is the body of /readyz
+type ReadyResponse struct {
+	Ready      bool   `json:"ready"`
+	Unlocked   bool   `json:"unlocked"`
+	StoreOK    bool   `json:"storeOk"`
+	StoreError string `json:"storeError,omitempty"`
+	Keys       int    `json:"keys"`
+	// Frozen signers are ready, to be reachable for an unfreeze, but refuse to sign
+	Frozen bool `json:"frozen"`
+}
+
+// serveProbe serves the unauthenticated endpoints, false if the path isn't one
+func (sh *SigningHandler) serveProbe(w http.ResponseWriter, r *http.Request) bool {
+	switch r.URL.Path {
+	case "/healthz":
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	case "/readyz":
+		ready := sh.ready()
+		status := http.StatusOK
+		if !ready.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, ready)
+	case "/metrics":
+		sh.serveMetrics(w)
+	default:
+		return false
+	}
+	return true
+}
+
+// serveStatus serves /status to known clients
+func (sh *SigningHandler) serveStatus(w http.ResponseWriter, client string) {
+	if err := sh.authorizeKnown(client); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sh.status())
+}
+
+func (sh *SigningHandler) ready() *ReadyResponse {
+	ready := &ReadyResponse{Unlocked: !sh.hold.Locked(), Keys: sh.hold.KeyCount(), Frozen: sh.hold.Frozen()}
+	if err := sh.hold.PingStore(); err != nil {
+		ready.StoreError = err.Error()
+	} else {
+		ready.StoreOK = true
+	}
+	ready.Ready = ready.Unlocked && ready.StoreOK
+	return ready
+}
+
+func (sh *SigningHandler) status() *StatusResponse {
+	ready := sh.ready()
+	status := &StatusResponse{
+		Version:      api.Version,
+		StoreBackend: sh.hold.StoreBackend(),
+		Ready:        ready.Ready,
+		Frozen:       ready.Frozen,
+		Keys:         ready.Keys,
+	}
+	if !sh.started.IsZero() {
+		status.UptimeSeconds = int64(time.Since(sh.started) / time.Second)
+	}
+	for coinFamily := range families {
+		status.CoinFamilies = append(status.CoinFamilies, coinFamily.String())
+	}
+	sort.Strings(status.CoinFamilies)
+	for _, network := range networks {
+		if !network.Disabled {
+			status.CoinPrefixes = append(status.CoinPrefixes, network.Name)
+		}
+	}
+	return status
+}
+
+// authorizeKnown checks the client is authenticated, and in the authorization table when one is
+// configured
+func (sh *SigningHandler) authorizeKnown(client string) error {
+	if len(client) > 0 && (sh.auth == nil || sh.auth[client] != nil) {
+		return nil
+	}
+	return sh.authorize(client, OpAdmin, "")
+}
@@ -0,0 +1,154 @@
+package signer
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/blockcypher/cryptosigner/signer/api"
+)
+
+// HMAC request authentication, for clients that can't present a certificate (behind TLS
+// terminating proxies). Each request carries a key ID, a timestamp, a nonce and the HMAC-SHA256
+// with the key secret of:
+//
+//	METHOD \n REQUEST-URI \n TIMESTAMP \n NONCE \n hex(SHA256(body))
+//
+// The key ID is the client identity in the authorization table.
+
+// Headers of HMAC signed requests
+const (
+	HeaderKeyID     = api.HeaderKeyID
+	HeaderTimestamp = api.HeaderTimestamp
+	HeaderNonce     = api.HeaderNonce
+	HeaderSignature = api.HeaderSignature
+)
+
+// DefaultHMACWindow is the maximum difference between the timestamp of a request and the signer
+// clock
+const DefaultHMACWindow = 5 * time.Minute
+
+const minHMACSecretLen = 32
+
+// HMACAuth verifies HMAC signed requests against the secrets of their key ID, refusing stale
+// timestamps and nonces already seen.
+type HMACAuth struct {
+	secrets map[string][]byte
+	window  time.Duration
+	now     func() time.Time
+
+	lock sync.Mutex
+	// nonces seen within the window, by key ID and nonce, with their expiry
+	nonces map[string]time.Time
+	purged time.Time
+}
+
+// NewHMACAuth creates the verifier of requests signed with the secrets, timestamps may be off by
+// the window
+func NewHMACAuth(secrets map[string][]byte, window time.Duration) *HMACAuth {
+	return &HMACAuth{secrets: secrets, window: window, now: time.Now, nonces: make(map[string]time.Time)}
+}
+
+// LoadHMACSecrets reads hex encoded secrets of at least 32 bytes from a JSON file, an object keyed
+// by key ID
+func LoadHMACSecrets(path string) (map[string][]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var hexSecrets map[string]string
+	if err := json.Unmarshal(data, &hexSecrets); err != nil {
+		return nil, err
+	}
+	secrets := make(map[string][]byte)
+	for keyID, hexSecret := range hexSecrets {
+		secret, err := hex.DecodeString(hexSecret)
+		if err != nil || len(secret) < minHMACSecretLen {
+			return nil, errors.New("Secret of key " + keyID + " must be at least 32 hex encoded bytes")
+		}
+		secrets[keyID] = secret
+	}
+	return secrets, nil
+}
+
+// SignHMAC returns the hex encoded signature of a request
+func SignHMAC(secret []byte, method, uri, timestamp, nonce string, body []byte) string {
+	return api.SignHMAC(secret, method, uri, timestamp, nonce, body)
+}
+
+func unauthenticated(msg string) *APIError {
+	return apiError(http.StatusUnauthorized, CodeUnauthenticated, msg, "")
+}
+
+// Authenticate returns the key ID of a signed request, or an empty string if the request isn't
+// signed. The body is read and replaced.
+func (a *HMACAuth) Authenticate(r *http.Request) (string, error) {
+	keyID := r.Header.Get(HeaderKeyID)
+	if len(keyID) == 0 {
+		return "", nil
+	}
+	timestamp := r.Header.Get(HeaderTimestamp)
+	nonce := r.Header.Get(HeaderNonce)
+	sig, err := hex.DecodeString(r.Header.Get(HeaderSignature))
+	if len(timestamp) == 0 || len(nonce) < 8 || len(nonce) > 64 || err != nil || len(sig) == 0 {
+		return "", unauthenticated("Missing or malformed request signature headers.")
+	}
+
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", unauthenticated("Invalid request timestamp.")
+	}
+	now := a.now()
+	if skew := now.Sub(time.Unix(secs, 0)); skew > a.window || skew < -a.window {
+		return "", unauthenticated("Stale request timestamp.")
+	}
+
+	secret := a.secrets[keyID]
+	if secret == nil {
+		return "", unauthenticated("Unknown key ID.")
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if bodyTooLarge(err) {
+		return "", errBodyTooLarge()
+	}
+	if err != nil {
+		return "", err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	expected, _ := hex.DecodeString(SignHMAC(secret, r.Method, r.URL.RequestURI(), timestamp, nonce, body))
+	if !hmac.Equal(sig, expected) {
+		return "", unauthenticated("Invalid request signature.")
+	}
+
+	if !a.useNonce(keyID+" "+nonce, now) {
+		return "", unauthenticated("Replayed request nonce.")
+	}
+	return keyID, nil
+}
+
+// useNonce records a nonce, false if it was already seen. Nonces are kept until a request with
+// them would be stale anyway.
+func (a *HMACAuth) useNonce(nonce string, now time.Time) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if now.Sub(a.purged) > a.window {
+		for n, expiry := range a.nonces {
+			if now.After(expiry) {
+				delete(a.nonces, n)
+			}
+		}
+		a.purged = now
+	}
+	if _, seen := a.nonces[nonce]; seen {
+		return false
+	}
+	a.nonces[nonce] = now.Add(2 * a.window)
+	return true
+}
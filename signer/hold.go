@@ -4,23 +4,20 @@ import (
 	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/sha256"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"log"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/blockcypher/cryptosigner/signer/bitcoin"
 	"github.com/blockcypher/cryptosigner/util"
-	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/ethereum/go-ethereum/params"
-	"github.com/ethereum/go-ethereum/rlp"
 )
 
-// Signer interface
+// Signer interface, the key generation and signature primitives of a family
 type Signer interface {
 	NewKey() (pub, priv []byte, err error)
 	Sign(private, data []byte) ([]byte, error)
@@ -30,11 +27,75 @@ type Signer interface {
 type KeyHold interface {
 	// Creates and keeps a new key pair. Unlocking it to produce a signature will require that the data
 	// to sign pass the provided challenge.
-	NewKey(challenge Challenge, prefix byte) (addr string, err error)
+	NewKey(challenge Challenge, network *Network, opts *KeyOptions) (addr string, err error)
 
 	// For a given source address and data that should pass the challenge provided when address keys were
 	// created, signs that data.
-	Sign(addr string, data []byte)
+	Sign(addr string, data []byte) (sig, pubkey []byte, err error)
+}
+
+// Errors returned when signing
+var (
+	ErrUnknownAddress         = errors.New("Unknown address")
+	ErrChallengeFailed        = errors.New("challenge failed")
+	ErrMessageSigningDisabled = errors.New("message signing not allowed for address")
+	ErrDecrypt                = errors.New("private key decryption failed")
+	// ErrRequestConflict is returned when a request ID is reused for a different key
+	ErrRequestConflict = errors.New("request ID already used with different parameters")
+	// ErrInvalidInput is returned when the inputs to sign don't fit the transaction or each other
+	ErrInvalidInput = errors.New("invalid input")
+	// ErrAddressType is returned when a key is requested with an address type the network lacks
+	ErrAddressType = errors.New("address type not supported")
+)
+
+// MessagePolicy controls which keys may sign arbitrary messages in addition to transactions
+type MessagePolicy uint8
+
+const (
+	// MessagesDisabled never signs messages, the default
+	MessagesDisabled MessagePolicy = iota
+	// MessagesPerKey signs messages with keys created with AllowMessages
+	MessagesPerKey
+	// MessagesAll signs messages with any key of a family supporting it
+	MessagesAll
+)
+
+// ParseMessagePolicy reads a message policy name (off, per-key or all)
+func ParseMessagePolicy(name string) (MessagePolicy, error) {
+	switch strings.ToLower(name) {
+	case "", "off":
+		return MessagesDisabled, nil
+	case "per-key":
+		return MessagesPerKey, nil
+	case "all":
+		return MessagesAll, nil
+	}
+	return MessagesDisabled, errors.New("Unknown message signing policy: " + name)
+}
+
+// KeyOptions are optional settings for a new key
+type KeyOptions struct {
+	// AllowMessages lets the key sign messages under the MessagesPerKey policy
+	AllowMessages bool
+	// RequestID makes creation idempotent: a new key with the same ID and parameters is the key
+	// already created
+	RequestID string
+	// AddressType selects the address of the key for families with several, P2PKH when empty.
+	// Bitcoin networks with segwit also create P2WPKH keys.
+	AddressType bitcoin.AddressType
+}
+
+// ValidRequestID checks a request ID: 1 to 64 letters, digits, '.', '_' or '-'
+func ValidRequestID(id string) bool {
+	if len(id) == 0 || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
 }
 
 // Internal representation of the coin family, address, public key and private key trifecta. The private key
@@ -44,29 +105,81 @@ type key struct {
 	address          string
 	encryptedPrivate []byte
 	challenge        Challenge
+	allowMessages    bool
+	// nil for keys stored before networks were recorded
+	network *Network
+	// zero for keys stored before creation times were recorded
+	created time.Time
+	uses    uint64
+	// empty unless created with one
+	requestID string
+	// empty for the default address type of the family
+	addressType bitcoin.AddressType
+	// empty for active keys
+	state string
+	// when the key was deleted, purged after the grace period
+	deleted time.Time
+	// length of the record last saved
+	size int
+	// held from taking a snapshot of the record to saving it, so saves land in order
+	savelock sync.Mutex
 }
 
 func readKey(data []byte) *key {
 	parts := bytes.Split(data, []byte{32}) // space
 	// check for old format
-	var k key
+	k := key{size: len(data)}
 	if len(parts) == 3 {
 		k.coinFamily = BitcoinFamily
 		k.address = string(parts[0])
 		k.encryptedPrivate, _ = hex.DecodeString(string(parts[1]))
 		challng, _ := hex.DecodeString(string(parts[2]))
-		k.challenge = ReadChallenge(challng, k.coinFamily)
+		k.challenge = ReadChallenge(challng, k.coinFamily, nil)
 	} else {
-		coinFamily, _ := strconv.Atoi(string(data[0]))
+		coinFamily, _ := strconv.Atoi(string(parts[0]))
 		k.coinFamily = CoinFamily(uint8(coinFamily))
 		k.address = string(parts[1])
 		k.encryptedPrivate, _ = hex.DecodeString(string(parts[2]))
+		k.readAttributes(parts[4:])
 		challng, _ := hex.DecodeString(string(parts[3]))
-		k.challenge = ReadChallenge(challng, k.coinFamily)
+		k.challenge = ReadChallenge(challng, k.coinFamily, k.network)
 	}
 	return &k
 }
 
+// Optional attributes follow the positional fields as name=value pairs, only
+// written when set so records stay readable by older versions.
+func (k *key) readAttributes(attrs [][]byte) {
+	for _, attr := range attrs {
+		kv := strings.SplitN(string(attr), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "msg":
+			k.allowMessages = kv[1] == "1"
+		case "net":
+			k.network, _ = LookupNetwork(kv[1])
+		case "created":
+			if secs, err := strconv.ParseInt(kv[1], 10, 64); err == nil {
+				k.created = time.Unix(secs, 0).UTC()
+			}
+		case "uses":
+			k.uses, _ = strconv.ParseUint(kv[1], 10, 64)
+		case "req":
+			k.requestID = kv[1]
+		case "type":
+			k.addressType = bitcoin.AddressType(kv[1])
+		case "state":
+			k.state = kv[1]
+		case "deleted":
+			if secs, err := strconv.ParseInt(kv[1], 10, 64); err == nil {
+				k.deleted = time.Unix(secs, 0).UTC()
+			}
+		}
+	}
+}
+
 func (k *key) bytes() []byte {
 	data := new(bytes.Buffer)
 	data.WriteString(strconv.Itoa(int(k.coinFamily)))
@@ -76,127 +189,502 @@ func (k *key) bytes() []byte {
 	data.WriteString(hex.EncodeToString(k.encryptedPrivate))
 	data.WriteString(" ")
 	data.WriteString(hex.EncodeToString(k.challenge.Bytes()))
+	if k.network != nil {
+		data.WriteString(" net=" + k.network.Name)
+	}
+	if k.allowMessages {
+		data.WriteString(" msg=1")
+	}
+	if !k.created.IsZero() {
+		data.WriteString(" created=" + strconv.FormatInt(k.created.Unix(), 10))
+	}
+	if k.uses > 0 {
+		data.WriteString(" uses=" + strconv.FormatUint(k.uses, 10))
+	}
+	if len(k.requestID) > 0 {
+		data.WriteString(" req=" + k.requestID)
+	}
+	if len(k.addressType) > 0 {
+		data.WriteString(" type=" + string(k.addressType))
+	}
+	if len(k.state) > 0 {
+		data.WriteString(" state=" + k.state)
+	}
+	if !k.deleted.IsZero() {
+		data.WriteString(" deleted=" + strconv.FormatInt(k.deleted.Unix(), 10))
+	}
 	return data.Bytes()
 }
 
 // Hold holds the keys and handles their lifecycle. Decrypts the private key just for the time of
 // computing a signature.
 type Hold struct {
-	cipher     cipher.Block
+	// master key the cipher is made from for each operation, in a secret buffer wiped on lock,
+	// nil while locked
+	masterKey  []byte
 	cipherlock *sync.Mutex
-	store      Store
-	signer     Signer
-	keys       map[string]*key
+	// last use of the master key, for the idle lock
+	lastUsed      time.Time
+	store         Store
+	keys          map[string]*key
+	keyslock      *sync.RWMutex
+	messagePolicy MessagePolicy
+	// addresses of keys created with a request ID, by ID
+	requests map[string]string
+	// serializes creations with a request ID
+	requestlock *sync.Mutex
+	// refuses all signatures
+	frozen bool
+	// size of the key records saved, in bytes
+	storeSize int64
+}
+
+// MakeHold create the hold structure, unlocked with the password
+func MakeHold(pass []byte, store Store) (*Hold, error) {
+	hold, err := MakeLockedHold(store)
+	if err != nil {
+		return nil, err
+	}
+	if err := hold.Unlock(pass); err != nil {
+		return nil, err
+	}
+	return hold, nil
 }
 
-// MakeHold create the hold structure
-func MakeHold(pass string, store Store, signer Signer) (*Hold, error) {
-	// hash the password to make a 32-bytes cipher key
-	passh := sha256.Sum256([]byte(pass))
-	cipher, err := aes.NewCipher(passh[:])
+// MakeHoldFromKey create the hold structure, unlocked with the master key, for master keys
+// combined from shares
+func MakeHoldFromKey(masterKey []byte, store Store) (*Hold, error) {
+	hold, err := MakeLockedHold(store)
 	if err != nil {
 		return nil, err
 	}
+	if err := hold.UnlockWithKey(masterKey); err != nil {
+		return nil, err
+	}
+	return hold, nil
+}
+
+// MakeLockedHold loads the keys of the store in a locked hold, unable to create or sign until
+// unlocked with the password
+func MakeLockedHold(store Store) (*Hold, error) {
 	data, err := store.ReadAll()
 	if err != nil {
 		return nil, err
 	}
 
 	keys := readKeyData(data)
-	return &Hold{cipher, new(sync.Mutex), store, signer, keys}, nil
+	storeSize := 0
+	for _, kd := range data {
+		storeSize += len(kd)
+	}
+	requests := make(map[string]string)
+	for addr, key := range keys {
+		if len(key.requestID) > 0 {
+			requests[key.requestID] = addr
+		}
+	}
+	frozen := false
+	if flags, ok := store.(FlagStore); ok {
+		if frozen, err = flags.Flag(frozenFlag); err != nil {
+			return nil, err
+		}
+		if frozen {
+			log.Println("Signing frozen, unfreeze to sign.")
+		}
+	}
+	return &Hold{
+		cipherlock:    new(sync.Mutex),
+		store:         store,
+		keys:          keys,
+		keyslock:      new(sync.RWMutex),
+		messagePolicy: MessagesDisabled,
+		requests:      requests,
+		requestlock:   new(sync.Mutex),
+		frozen:        frozen,
+		storeSize:     int64(storeSize),
+	}, nil
 }
 
-// NewKey creates a new keypair and save it in the hold
-func (h *Hold) NewKey(challenge Challenge, prefix byte, family CoinFamily) (string, error) {
-	pub, priv, err := h.signer.NewKey()
+// SetMessagePolicy changes which keys are allowed to sign messages
+func (h *Hold) SetMessagePolicy(policy MessagePolicy) {
+	h.messagePolicy = policy
+}
+
+// NewKey creates a new keypair for an address on the network and save it in the hold
+func (h *Hold) NewKey(challenge Challenge, network *Network, opts *KeyOptions) (string, error) {
+	if opts == nil {
+		opts = &KeyOptions{}
+	}
+	addrType := opts.AddressType
+	if addrType == bitcoin.P2PKH {
+		addrType = ""
+	}
+	if len(opts.RequestID) > 0 {
+		if !ValidRequestID(opts.RequestID) {
+			return "", errors.New("Invalid request ID")
+		}
+		h.requestlock.Lock()
+		defer h.requestlock.Unlock()
+		if addr, ok := h.requestedKey(opts.RequestID, challenge, network, opts, addrType); ok {
+			return addr, nil
+		} else if len(addr) > 0 {
+			return "", ErrRequestConflict
+		}
+	}
+	family, err := LookupFamily(network.Family)
+	if err != nil {
+		return "", err
+	}
+	pub, priv, err := family.NewKey()
 	if err != nil {
 		return "", err
 	}
 	var addr string
-	switch family {
-	case BitcoinFamily:
-		addr = bitcoin.EncodeAddress(util.Hash160(pub), prefix)
-	case EthereumFamily:
-		// Ethereum addresses are the last 20 bytes of the SHA3-256 of the pubkey
-		epriv, err := crypto.ToECDSA(priv)
-		if err != nil {
-			return "", err
-		} else if epriv == nil {
-			return "", errors.New("Invalid private key")
+	if len(opts.AddressType) > 0 {
+		typer, ok := family.(AddressTyper)
+		if !ok {
+			util.Wipe(priv)
+			return "", fmt.Errorf("%w: %s on %s", ErrAddressType, opts.AddressType, network.Name)
 		}
-		addr = strings.ToLower(crypto.PubkeyToAddress(epriv.PublicKey).String()[2:])
-	default:
-		return "", errors.New("Unknown coin family")
+		addr, err = typer.TypedAddress(pub, network, opts.AddressType)
+	} else {
+		addr, err = family.Address(pub, network)
+	}
+	if err != nil {
+		util.Wipe(priv)
+		return "", err
 	}
 
-	enc, err := util.Encrypt(h.cipher, priv)
+	enc, err := h.encrypt(priv)
+	util.Wipe(priv)
 	if err != nil {
 		return "", err
 	}
 	newkey := &key{
-		coinFamily:       family,
+		coinFamily:       network.Family,
 		address:          addr,
 		encryptedPrivate: enc,
-		challenge:        challenge}
+		challenge:        challenge,
+		allowMessages:    opts.AllowMessages,
+		network:          network,
+		created:          time.Now().UTC().Truncate(time.Second),
+		requestID:        opts.RequestID,
+		addressType:      addrType}
+	record := newkey.bytes()
+	newkey.size = len(record)
+	newkey.savelock.Lock()
+	defer newkey.savelock.Unlock()
+	h.keyslock.Lock()
 	h.keys[addr] = newkey
-	return addr, h.store.Save(string(addr), newkey.bytes())
+	if len(opts.RequestID) > 0 {
+		h.requests[opts.RequestID] = addr
+	}
+	h.storeSize += int64(len(record))
+	h.keyslock.Unlock()
+	return addr, h.store.Save(string(addr), record)
 }
 
-// Sign an address iff the challenge pass
-func (h *Hold) Sign(addr string, data []byte) ([]byte, []byte, error) {
+// requestedKey finds the key already created for a request ID. Returns its address, and whether
+// it was created with the same parameters.
+func (h *Hold) requestedKey(id string, challenge Challenge, network *Network, opts *KeyOptions, addrType bitcoin.AddressType) (string, bool) {
+	h.keyslock.RLock()
+	defer h.keyslock.RUnlock()
+	addr := h.requests[id]
 	key := h.keys[addr]
 	if key == nil {
-		return nil, nil, errors.New("Unknown address: " + addr)
+		return addr, false
 	}
-	if !key.challenge.Check(data) {
-		return nil, nil, errors.New("challenge failed")
+	same := key.network == network && key.allowMessages == opts.AllowMessages && key.addressType == addrType &&
+		bytes.Equal(key.challenge.Bytes(), challenge.Bytes())
+	return addr, same
+}
+
+// Flush flushes the store if it buffers writes
+func (h *Hold) Flush() error {
+	if flusher, ok := h.store.(Flusher); ok {
+		return flusher.Flush()
 	}
+	return nil
+}
 
+// Locked tells whether the hold is unable to decrypt keys
+func (h *Hold) Locked() bool {
 	h.cipherlock.Lock()
 	defer h.cipherlock.Unlock()
+	return h.masterKey == nil
+}
 
-	clone := make([]byte, len(key.encryptedPrivate))
-	copy(clone, key.encryptedPrivate)
+// KeyCount returns the number of keys loaded
+func (h *Hold) KeyCount() int {
+	h.keyslock.RLock()
+	defer h.keyslock.RUnlock()
+	return len(h.keys)
+}
+
+// StoreSize returns the size of the key records in the store, in bytes
+func (h *Hold) StoreSize() int64 {
+	h.keyslock.RLock()
+	defer h.keyslock.RUnlock()
+	return h.storeSize
+}
+
+// PingStore checks the store is reachable, when the store supports it
+func (h *Hold) PingStore() error {
+	if pinger, ok := h.store.(Pinger); ok {
+		return pinger.Ping()
+	}
+	return nil
+}
+
+// StoreBackend names the type of the store
+func (h *Hold) StoreBackend() string {
+	if namer, ok := h.store.(interface{ Backend() string }); ok {
+		return namer.Backend()
+	}
+	return "custom"
+}
+
+// lookup returns the key of an address, nil if unknown
+func (h *Hold) lookup(addr string) *key {
+	h.keyslock.RLock()
+	defer h.keyslock.RUnlock()
+	return h.keys[addr]
+}
 
-	priv, err := util.Decrypt(h.cipher, clone)
+// KeyNetwork returns the network of the key of an address. Keys stored before networks were
+// recorded are on the default network of their family.
+func (h *Hold) KeyNetwork(addr string) (*Network, error) {
+	key := h.lookup(addr)
+	if key == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAddress, addr)
+	}
+	if key.network == nil {
+		return defaultNetwork(key.coinFamily), nil
+	}
+	return key.network, nil
+}
+
+// SpentOutput identifies the input to sign and the amount of the output it spends, for coins whose
+// signature digest commits to them (Bitcoin Cash, segwit)
+type SpentOutput struct {
+	InputIndex int
+	Amount     uint64
+	// Outpoint optionally identifies the output spent as txid:vout, checked against the input
+	Outpoint string
+	// Witness selects the BIP143 digest of segwit inputs, set by the hold for P2WPKH keys
+	Witness bool
+}
+
+// Sign an address iff the challenge pass
+func (h *Hold) Sign(addr string, data []byte) ([]byte, []byte, error) {
+	return h.SignInput(addr, data, nil)
+}
+
+// SignInput signs an input of a transaction for an address iff the challenge pass. The spent output
+// is only required for Bitcoin Cash and P2WPKH keys, where data is the whole unsigned transaction.
+func (h *Hold) SignInput(addr string, data []byte, spent *SpentOutput) ([]byte, []byte, error) {
+	key, err := h.signingKey(addr)
 	if err != nil {
 		return nil, nil, err
 	}
+	if spent != nil {
+		input := *spent
+		input.Witness = key.addressType == bitcoin.P2WPKH
+		spent = &input
+	} else if key.addressType == bitcoin.P2WPKH {
+		return nil, nil, fmt.Errorf("%w: input index and amount required for segwit keys", ErrInvalidInput)
+	}
+	if !key.challenge.Check(data) {
+		return nil, nil, ErrChallengeFailed
+	}
 
-	pubkey := util.PubKeyFromPrivate(priv)
+	family, err := LookupFamily(key.coinFamily)
+	if err != nil {
+		return nil, nil, err
+	}
+	priv, err := h.decrypt(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	sig, pubkey, err := family.Sign(priv, data, key.network, spent)
+	util.Wipe(priv)
+	if err == nil {
+		h.countUse(key)
+	}
+	return sig, pubkey, err
+}
+
+// SignMessage signs an arbitrary message with the key of an address, to prove control of the address
+// without moving funds. Only for families able to, for Bitcoin legacy addresses produce a BIP137
+// compact signature with the message magic of the network, segwit addresses a BIP322 simple
+// signature. Requires the message policy to
+// allow it for the key.
+func (h *Hold) SignMessage(addr string, message []byte) ([]byte, error) {
+	key, err := h.signingKey(addr)
+	if err != nil {
+		return nil, err
+	}
+	family, err := LookupFamily(key.coinFamily)
+	if err != nil {
+		return nil, err
+	}
+	messageSigner, ok := family.(MessageSigner)
+	if !ok {
+		return nil, errors.New("Message signing not supported for coin family")
+	}
+	if h.messagePolicy == MessagesDisabled || (h.messagePolicy == MessagesPerKey && !key.allowMessages) {
+		return nil, ErrMessageSigningDisabled
+	}
 
-	switch key.coinFamily {
-	case BitcoinFamily:
-		// data passed is the digested tx bytes to sign, what we sign is the double-sha of that
-		sigBytes := append(data, []byte{1, 0, 0, 0}...)
-		sig, err := h.signer.Sign(priv, util.DoubleHash(sigBytes))
-		return sig, pubkey, err
-	case EthereumFamily:
-		var tx *types.Transaction
-		if err := rlp.DecodeBytes(data, &tx); err != nil {
+	priv, err := h.decrypt(key)
+	if err != nil {
+		return nil, err
+	}
+	network := key.network
+	if network == nil {
+		network = defaultNetwork(key.coinFamily)
+	}
+	sig, err := messageSigner.SignMessage(priv, addr, network, message)
+	util.Wipe(priv)
+	if err == nil {
+		h.countUse(key)
+	}
+	return sig, err
+}
+
+// BatchInput is an input of a transaction to sign with the key of an address
+type BatchInput struct {
+	Address string
+	SpentOutput
+}
+
+// SignBatch signs inputs of a whole unsigned transaction with the keys of their addresses, all of
+// them or none. The challenge of each key is checked once against the transaction. Returns the
+// signatures and public keys in the order of the inputs.
+func (h *Hold) SignBatch(data []byte, inputs []*BatchInput) ([][]byte, [][]byte, error) {
+	if len(inputs) == 0 {
+		return nil, nil, fmt.Errorf("%w: no input to sign", ErrInvalidInput)
+	}
+	keys := make([]*key, len(inputs))
+	checked := make(map[string]bool)
+	indexes := make(map[int]bool)
+	for n, input := range inputs {
+		key, err := h.signingKey(input.Address)
+		if err != nil {
 			return nil, nil, err
 		}
-		epriv, err := crypto.ToECDSA(priv)
+		if n > 0 && key.coinFamily != keys[0].coinFamily {
+			return nil, nil, fmt.Errorf("%w: inputs of a batch must be of one coin family", ErrInvalidInput)
+		}
+		if indexes[input.InputIndex] {
+			return nil, nil, fmt.Errorf("%w: input %d listed twice", ErrInvalidInput, input.InputIndex)
+		}
+		indexes[input.InputIndex] = true
+		if !checked[input.Address] {
+			if !key.challenge.Check(data) {
+				return nil, nil, fmt.Errorf("%w: %s", ErrChallengeFailed, input.Address)
+			}
+			checked[input.Address] = true
+		}
+		keys[n] = key
+	}
+	family, err := LookupFamily(keys[0].coinFamily)
+	if err != nil {
+		return nil, nil, err
+	}
+	inputSigner, ok := family.(InputSigner)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: batch signing not supported for coin family", ErrInvalidInput)
+	}
+
+	sigs, pubs := make([][]byte, len(inputs)), make([][]byte, len(inputs))
+	for n, input := range inputs {
+		priv, err := h.decrypt(keys[n])
 		if err != nil {
 			return nil, nil, err
-		} else if epriv == nil {
-			return nil, nil, errors.New("Invalid private key")
 		}
-		config := params.MainnetChainConfig
-		s := types.MakeSigner(config, config.EIP158Block)
-		h := s.Hash(tx)
-		sig, err := crypto.Sign(h[:], epriv)
-		return sig, pubkey, err
-	default:
-		return nil, nil, errors.New("Unknown coin family")
+		spent := input.SpentOutput
+		spent.Witness = keys[n].addressType == bitcoin.P2WPKH
+		sigs[n], pubs[n], err = inputSigner.SignTxInput(priv, data, keys[n].network, &spent)
+		util.Wipe(priv)
+		if err != nil {
+			return nil, nil, fmt.Errorf("input %d: %w", input.InputIndex, err)
+		}
+	}
+	for _, key := range keys {
+		h.countUse(key)
+	}
+	return sigs, pubs, nil
+}
+
+// countUse counts a signature by a key in its record. The signature stands if the record can't
+// be saved. The record isn't saved for a key retired or purged meanwhile.
+func (h *Hold) countUse(key *key) {
+	key.savelock.Lock()
+	defer key.savelock.Unlock()
+	h.keyslock.Lock()
+	key.uses++
+	if h.keys[key.address] != key {
+		h.keyslock.Unlock()
+		return
+	}
+	record := key.bytes()
+	h.storeSize += int64(len(record) - key.size)
+	key.size = len(record)
+	h.keyslock.Unlock()
+	if err := h.store.Save(key.address, record); err != nil {
+		log.Println("Could not save use count of", key.address, err)
+	}
+}
+
+// decrypt returns the private key of a key, to be wiped once used
+func (h *Hold) decrypt(key *key) ([]byte, error) {
+	h.cipherlock.Lock()
+	defer h.cipherlock.Unlock()
+	ciph, err := h.cipher()
+	if err != nil {
+		return nil, err
+	}
+
+	clone := make([]byte, len(key.encryptedPrivate))
+	copy(clone, key.encryptedPrivate)
+
+	priv, err := util.Decrypt(ciph, clone)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
 	}
+	return priv, nil
+}
+
+// encrypt encrypts a new private key
+func (h *Hold) encrypt(priv []byte) ([]byte, error) {
+	h.cipherlock.Lock()
+	defer h.cipherlock.Unlock()
+	ciph, err := h.cipher()
+	if err != nil {
+		return nil, err
+	}
+	return util.Encrypt(ciph, priv)
+}
 
+// cipher makes the cipher of the master key for an operation, left to the garbage collector once
+// done. Called with the cipher lock held.
+func (h *Hold) cipher() (cipher.Block, error) {
+	if h.masterKey == nil {
+		return nil, ErrLocked
+	}
+	h.lastUsed = time.Now()
+	return aes.NewCipher(h.masterKey)
 }
 
 func readKeyData(data [][]byte) map[string]*key {
 	keys := make(map[string]*key)
 	for _, kd := range data {
 		key := readKey(kd)
+		if key.state == StateRetired {
+			// left in stores unable to archive
+			continue
+		}
 		log.Println("Loaded address", key.address, "family", key.coinFamily)
 		keys[key.address] = key
 	}
@@ -1,20 +1,39 @@
 package signer
 
 import (
+	"bytes"
+	"crypto/aes"
+	"crypto/ed25519"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+
+	"github.com/blockcypher/cryptosigner/signer/bitcoin"
+	"github.com/blockcypher/cryptosigner/signer/solana"
 	"github.com/blockcypher/cryptosigner/util"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
 )
 
 // Test-only in-memory key store
 type TestStore struct {
-	store map[string][]byte
+	store   map[string][]byte
+	flushes int
 }
 
 func MakeTestStore() *TestStore {
-	return &TestStore{make(map[string][]byte)}
+	return &TestStore{store: make(map[string][]byte)}
 }
 
 func (ts *TestStore) ReadAll() ([][]byte, error) {
@@ -35,6 +54,11 @@ func (ts *TestStore) Delete(key string) error {
 	return nil
 }
 
+func (ts *TestStore) Flush() error {
+	ts.flushes++
+	return nil
+}
+
 const (
 	ADDR1   = "15qx9ug952GWGTNn7Uiv6vode4RcGrRemh"
 	ADDR2   = "1GGwoLVX9XVPfmpyPbkXxmXMBQDJSBai42"
@@ -109,19 +133,605 @@ func TestSigBech322(t *testing.T) {
 	}
 }
 
+func TestSigNetwork(t *testing.T) {
+	hold := testHold()
+	network := btcNetwork()
+	for _, vector := range [][]string{{ADDR1, TxData1}, {ADDR3, TxData3}} {
+		addr, err := hold.NewKey(NewNetworkChallenge([]string{vector[0]}, network), network, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		txData, _ := hex.DecodeString(vector[1])
+		if sig, _, err := hold.Sign(addr, txData); err != nil || len(sig) < 50 {
+			t.Error("Could not sign.", err)
+		}
+	}
+
+	// a litecoin challenge does not accept bitcoin addresses
+	ltc, _ := LookupNetwork("ltc")
+	addr, _ := hold.NewKey(NewNetworkChallenge([]string{ADDR1}, ltc), ltc, nil)
+	if addr[0] != 'L' {
+		t.Error("Not a litecoin address", addr)
+	}
+	txData, _ := hex.DecodeString(TxData1)
+	if _, _, err := hold.Sign(addr, txData); err == nil {
+		t.Error("Challenge should have failed.")
+	}
+}
+
+func TestSigBitcoinCash(t *testing.T) {
+	hold := testHold()
+	bch, _ := LookupNetwork("bch")
+	target := "bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a"
+	addr, err := hold.NewKey(NewNetworkChallenge([]string{target}, bch), bch, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(addr, "bitcoincash:q") {
+		t.Error("Not a CashAddr", addr)
+	}
+
+	_, script, _ := bitcoin.DecodeAddress(target, &bch.Params)
+	tx := &bitcoin.Tx{
+		Version: 2,
+		Inputs:  []*bitcoin.TxIn{{PrevIndex: 1, Sequence: 0xffffffff}},
+		Outputs: []*bitcoin.TxOut{{Value: 90000, Script: script}},
+	}
+	if _, _, err := hold.Sign(addr, tx.Bytes()); err == nil {
+		t.Error("Input index and amount should be required.")
+	}
+	sig, pubkey, err := hold.SignInput(addr, tx.Bytes(), &SpentOutput{InputIndex: 0, Amount: 100000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ecdsa.ParseDERSignature(sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _ := btcec.ParsePubKey(pubkey)
+	digest := tx.WitnessSigHash(0, bitcoin.P2PKHScript(util.Hash160(pubkey)), 100000,
+		bitcoin.SigHashAll|bitcoin.SigHashForkID)
+	if !parsed.Verify(digest, pub) {
+		t.Error("Signature does not verify.")
+	}
+}
+
+func TestSigEthereum(t *testing.T) {
+	hold := testHold()
+	eth, _ := LookupNetwork("eth")
+	target, _ := eth.ValidateAddress("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed")
+	addr, err := hold.NewKey(NewNetworkChallenge([]string{target}, eth), eth, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	to := common.HexToAddress(target)
+	tx := types.NewTransaction(1, to, big.NewInt(1000), 21000, big.NewInt(1), nil)
+	txData, _ := rlp.EncodeToBytes(tx)
+	sig, _, err := hold.Sign(addr, txData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := types.NewEIP155Signer(big.NewInt(eth.ChainID))
+	signed, _ := tx.WithSignature(signer, sig)
+	if from, err := types.Sender(signer, signed); err != nil || strings.ToLower(from.Hex()[2:]) != addr {
+		t.Error("Signature is not from the key.", err)
+	}
+
+	other := types.NewTransaction(1, common.HexToAddress("0xfb6916095ca1df60bb79ce92ce3ea74c37c5d359"), big.NewInt(1000), 21000, big.NewInt(1), nil)
+	txData, _ = rlp.EncodeToBytes(other)
+	if _, _, err := hold.Sign(addr, txData); err == nil {
+		t.Error("Challenge should have failed.")
+	}
+}
+
+func TestSigEd25519(t *testing.T) {
+	hold := testHold()
+	sol, _ := LookupNetwork("sol")
+	target := solana.EncodeAddress(bytes.Repeat([]byte{2}, 32))
+	addr, err := hold.NewKey(NewNetworkChallenge([]string{target}, sol), sol, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, _ := solana.DecodeAddress(addr)
+
+	msg := solana.TransferMessage(from, bytes.Repeat([]byte{2}, 32), 1000, make([]byte, 32))
+	sig, pubkey, err := hold.Sign(addr, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pubkey, from) || !ed25519.Verify(pubkey, msg, sig) {
+		t.Error("Signature does not verify.")
+	}
+	if k := readKey(hold.keys[addr].bytes()); k.coinFamily != Ed25519Family || k.address != addr {
+		t.Error("Key record not read back:", k.coinFamily, k.address)
+	}
+
+	msg[4+32] = 3 // other destination
+	if _, _, err := hold.Sign(addr, msg); err == nil {
+		t.Error("Challenge should have failed.")
+	}
+}
+
+func TestCoinFamilyValues(t *testing.T) {
+	// saved in key records, they must not change
+	values := map[CoinFamily]uint8{BitcoinFamily: 0, EthereumFamily: 1, UnknownCoinFamily: 2,
+		BitcoinCashFamily: 3, Ed25519Family: 4}
+	for family, value := range values {
+		if uint8(family) != value || ParseCoinFamily(family.String()) != family {
+			t.Error("Unexpected value of", family, uint8(family))
+		}
+	}
+}
+
+func TestSignMessage(t *testing.T) {
+	hold := testHold()
+	challenge := NewSignatureChallenge([]string{ADDR1}, BitcoinFamily)
+	addr, _ := hold.NewKey(challenge, btcNetwork(), &KeyOptions{AllowMessages: true})
+	other, _ := hold.NewKey(challenge, btcNetwork(), nil)
+	msg := []byte("proof of reserves")
+
+	if _, err := hold.SignMessage(addr, msg); err != ErrMessageSigningDisabled {
+		t.Error("Message signing should be disabled by default.")
+	}
+
+	hold.SetMessagePolicy(MessagesPerKey)
+	sig, err := hold.SignMessage(addr, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sig) != 65 {
+		t.Error("Invalid compact signature.")
+	}
+	if _, err := hold.SignMessage(other, msg); err != ErrMessageSigningDisabled {
+		t.Error("Key was not allowed to sign messages.")
+	}
+	if k := readKey(hold.keys[addr].bytes()); !k.allowMessages {
+		t.Error("Message flag not persisted.")
+	}
+
+	hold.SetMessagePolicy(MessagesAll)
+	if _, err := hold.SignMessage(other, msg); err != nil {
+		t.Error(err)
+	}
+
+	// with the message magic of the network of the key
+	ltc, _ := LookupNetwork("ltc")
+	ltcAddr, _ := hold.NewKey(NewNetworkChallenge([]string{"LM2WMpR1Rp6j3Sa59cMXMs1SPzj9eXpGc1"}, ltc), ltc, nil)
+	sig, err = hold.SignMessage(ltcAddr, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubkey, _, err := ecdsa.RecoverCompact(sig, bitcoin.MessageHash("Litecoin Signed Message:\n", msg))
+	if err != nil || bitcoin.EncodeAddress(util.Hash160(pubkey.SerializeCompressed()), ltc.P2PKHVersion) != ltcAddr {
+		t.Error("Message not signed with the Litecoin magic.", err)
+	}
+}
+
+func TestSegwitKey(t *testing.T) {
+	hold := testHold()
+	hold.SetMessagePolicy(MessagesPerKey)
+	network := btcNetwork()
+	addr, err := hold.NewKey(NewNetworkChallenge([]string{ADDR1}, network), network,
+		&KeyOptions{AllowMessages: true, AddressType: bitcoin.P2WPKH})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bitcoin.IsSegwitAddress(addr) || !strings.HasPrefix(addr, "bc1q") {
+		t.Fatal("Not a P2WPKH address:", addr)
+	}
+	if k := readKey(hold.keys[addr].bytes()); k.addressType != bitcoin.P2WPKH {
+		t.Error("Address type not persisted.")
+	}
+
+	// BIP322 message signature, the witness of the to_sign transaction
+	msg := []byte("proof of reserves")
+	witness, err := hold.SignMessage(addr, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if witness[0] != 2 || int(witness[1])+int(witness[witness[1]+2])+3 != len(witness) {
+		t.Fatal("Invalid witness.", hex.EncodeToString(witness))
+	}
+	pub := witness[3+witness[1]:]
+	if bitcoin.EncodeSegwitAddress(network.Bech32HRP, 0, util.Hash160(pub)) != addr {
+		t.Error("Witness public key is not the key of the address.")
+	}
+	priv, _ := hold.decrypt(hold.keys[addr])
+	expected, _ := bitcoin.SignMessageBIP322(priv, addr, msg)
+	if !bytes.Equal(witness, expected) {
+		t.Error("Not a BIP322 signature of the message.")
+	}
+
+	// inputs are signed with the BIP143 digest, which commits to the amount
+	_, script, _ := bitcoin.DecodeAddress(ADDR1, &network.Params)
+	tx := &bitcoin.Tx{
+		Version: 2,
+		Inputs:  []*bitcoin.TxIn{{PrevHash: [32]byte{1}, PrevIndex: 0, Sequence: 0xffffffff}},
+		Outputs: []*bitcoin.TxOut{{Value: 90000, Script: script}},
+	}
+	if _, _, err := hold.Sign(addr, tx.Bytes()); !errors.Is(err, ErrInvalidInput) {
+		t.Error("Segwit key should require the spent output.", err)
+	}
+	sig, pubkey, err := hold.SignInput(addr, tx.Bytes(), &SpentOutput{InputIndex: 0, Amount: 100000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ecdsa.ParseDERSignature(sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := btcec.ParsePubKey(pubkey)
+	digest := tx.WitnessSigHash(0, bitcoin.P2PKHScript(util.Hash160(pubkey)), 100000, bitcoin.SigHashAll)
+	if !parsed.Verify(digest, key) {
+		t.Error("Invalid segwit input signature.")
+	}
+
+	// networks and families without segwit
+	doge, _ := LookupNetwork("doge")
+	eth, _ := LookupNetwork("eth")
+	for _, network := range []*Network{doge, eth} {
+		_, err := hold.NewKey(NewNetworkChallenge(nil, network), network, &KeyOptions{AddressType: bitcoin.P2WPKH})
+		if !errors.Is(err, ErrAddressType) {
+			t.Error("P2WPKH key should not be created on", network.Name, err)
+		}
+	}
+}
+
 func testHold() *Hold {
-	signer := &util.ECDSASigner{}
 	store := MakeTestStore()
-	hold, _ := MakeHold("test", store, signer)
+	hold, _ := MakeHold([]byte("test"), store)
 	return hold
 }
 
+func btcNetwork() *Network {
+	network, _ := LookupNetwork("btc")
+	return network
+}
+
 func testNewAndSign(t *testing.T, hold *Hold, targetAddr string, txhex string) ([]byte, []byte, error) {
 	targetAddrs := []string{targetAddr}
-	addr, err := hold.NewKey(NewSignatureChallenge(targetAddrs, BitcoinFamily), 0, BitcoinFamily)
+	addr, err := hold.NewKey(NewSignatureChallenge(targetAddrs, BitcoinFamily), btcNetwork(), nil)
 	if err != nil {
 		t.Error(err)
 	}
 	txData, _ := hex.DecodeString(txhex)
 	return hold.Sign(addr, txData)
 }
+
+func TestSignBatch(t *testing.T) {
+	hold := testHold()
+	network := btcNetwork()
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		addr, err := hold.NewKey(NewNetworkChallenge([]string{ADDR1}, network), network, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, addr)
+	}
+	other, _ := hold.NewKey(NewNetworkChallenge([]string{ADDR2}, network), network, nil)
+
+	_, script, _ := bitcoin.DecodeAddress(ADDR1, &network.Params)
+	tx := &bitcoin.Tx{
+		Version: 1,
+		Inputs: []*bitcoin.TxIn{
+			{PrevHash: [32]byte{1}, PrevIndex: 0, Sequence: 0xffffffff},
+			{PrevHash: [32]byte{2}, PrevIndex: 3, Sequence: 0xffffffff},
+			{PrevHash: [32]byte{3}, PrevIndex: 1, Sequence: 0xffffffff}},
+		Outputs: []*bitcoin.TxOut{{Value: 90000, Script: script}},
+	}
+	inputs := []*BatchInput{
+		{addrs[0], SpentOutput{InputIndex: 0}},
+		{addrs[1], SpentOutput{InputIndex: 1, Outpoint: tx.Outpoint(1)}},
+		{addrs[0], SpentOutput{InputIndex: 2}},
+	}
+	sigs, pubkeys, err := hold.SignBatch(tx.Bytes(), inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n, input := range inputs {
+		parsed, err := ecdsa.ParseDERSignature(sigs[n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubkey, _ := btcec.ParsePubKey(pubkeys[n])
+		digest := tx.SigHash(input.InputIndex, bitcoin.P2PKHScript(util.Hash160(pubkeys[n])), bitcoin.SigHashAll)
+		if !parsed.Verify(digest, pubkey) {
+			t.Error("Invalid signature of input", n)
+		}
+	}
+
+	// all or nothing
+	failing := map[string][]*BatchInput{
+		"unknown address":  {inputs[0], {ADDR2, SpentOutput{InputIndex: 1}}},
+		"challenge failed": {inputs[0], {other, SpentOutput{InputIndex: 1}}},
+		"index":            {inputs[0], {addrs[1], SpentOutput{InputIndex: 3}}},
+		"outpoint":         {inputs[0], {addrs[1], SpentOutput{InputIndex: 1, Outpoint: tx.Outpoint(2)}}},
+		"twice":            {inputs[0], {addrs[1], SpentOutput{InputIndex: 0}}},
+	}
+	for name, batch := range failing {
+		if sigs, _, err := hold.SignBatch(tx.Bytes(), batch); err == nil || sigs != nil {
+			t.Error("Batch should have failed:", name)
+		}
+	}
+}
+
+func TestFileStoreNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, _ := MakeFileStore(dir)
+	addr := "bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a"
+	if err := store.Save(addr, []byte("record")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bitcoincash_qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a")); err != nil {
+		t.Error("Record not saved under a portable name:", err)
+	}
+	if data, _ := store.ReadAll(); len(data) != 1 || string(data[0]) != "record" {
+		t.Error("Record not read back:", data)
+	}
+	if err := store.Archive(addr, []byte("record")); err != nil {
+		t.Error("Record not archived:", err)
+	}
+	if data, _ := store.ReadAll(); len(data) != 0 {
+		t.Error("Archived record still read:", data)
+	}
+}
+
+func TestKeyLifecycle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, _ := MakeFileStore(dir)
+	hold, _ := MakeHold([]byte("test"), store)
+	network := btcNetwork()
+	txData, _ := hex.DecodeString(TxData1)
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		addr, _ := hold.NewKey(NewNetworkChallenge([]string{ADDR1}, network), network, nil)
+		addrs = append(addrs, addr)
+	}
+
+	if _, err := hold.SetKeyState(addrs[0], StateFrozen); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := hold.Sign(addrs[0], txData); !errors.Is(err, ErrKeyFrozen) {
+		t.Error("Frozen key should not sign:", err)
+	}
+	if _, err := hold.SetKeyState(addrs[0], StateActive); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := hold.Sign(addrs[0], txData); err != nil {
+		t.Error("Unfrozen key should sign:", err)
+	}
+
+	if _, err := hold.SetKeyState(addrs[1], StateRetired); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ArchiveDirName, addrs[1])); err != nil {
+		t.Error("Retired key not archived:", err)
+	}
+	if _, _, err := hold.Sign(addrs[1], txData); !errors.Is(err, ErrUnknownAddress) {
+		t.Error("Retired key should not be held:", err)
+	}
+	if _, err := hold.SetKeyState(addrs[1], StateActive); !errors.Is(err, ErrUnknownAddress) {
+		t.Error("Retired key should not be restored:", err)
+	}
+
+	info, err := hold.SetKeyState(addrs[2], StateDeleted)
+	if err != nil || info.State != StateDeleted || info.Deleted == nil {
+		t.Fatal("Delete failed:", err, info)
+	}
+	if _, _, err := hold.Sign(addrs[2], txData); !errors.Is(err, ErrKeyDeleted) {
+		t.Error("Deleted key should not sign:", err)
+	}
+	if _, err := hold.SetKeyState(addrs[2], StateRetired); !errors.Is(err, ErrInvalidTransition) {
+		t.Error("Deleted key should not be retired:", err)
+	}
+
+	// records are replaced in place, use counts and states included in the store size
+	if size := storedSize(t, dir); hold.StoreSize() != size {
+		t.Error("Store size", hold.StoreSize(), "but", size, "stored")
+	}
+
+	// states survive a reload
+	hold, _ = MakeHold([]byte("test"), store)
+	if hold.KeyCount() != 2 {
+		t.Error("Unexpected keys after reload:", hold.KeyCount())
+	}
+	if purged, err := hold.PurgeDeleted(time.Hour); err != nil || len(purged) != 0 {
+		t.Error("Key purged within its grace period:", purged, err)
+	}
+	if purged, err := hold.PurgeDeleted(0); err != nil || len(purged) != 1 || purged[0] != addrs[2] {
+		t.Error("Deleted key not purged:", purged, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, addrs[2])); !os.IsNotExist(err) {
+		t.Error("Purged key still stored:", err)
+	}
+	if hold.KeyCount() != 1 {
+		t.Error("Unexpected keys after purge:", hold.KeyCount())
+	}
+	if size := storedSize(t, dir); hold.StoreSize() != size {
+		t.Error("Store size", hold.StoreSize(), "but", size, "stored after purge")
+	}
+}
+
+// blockingStore blocks the first save of a use count until released
+type blockingStore struct {
+	*TestStore
+	blocked chan struct{}
+	release chan struct{}
+}
+
+func (bs *blockingStore) Save(key string, data []byte) error {
+	if bytes.Contains(data, []byte(" uses=1")) && bs.blocked != nil {
+		close(bs.blocked)
+		bs.blocked = nil
+		<-bs.release
+	}
+	return bs.TestStore.Save(key, data)
+}
+
+func TestKeyStateSaveOrder(t *testing.T) {
+	store := &blockingStore{TestStore: MakeTestStore()}
+	hold, _ := MakeHold([]byte("test"), store)
+	network := btcNetwork()
+	addr, _ := hold.NewKey(NewNetworkChallenge([]string{ADDR1}, network), network, nil)
+	txData, _ := hex.DecodeString(TxData1)
+
+	// the use count of a signature is being saved when the key is frozen
+	store.blocked, store.release = make(chan struct{}), make(chan struct{})
+	blocked := store.blocked
+	signed := make(chan error)
+	go func() {
+		_, _, err := hold.Sign(addr, txData)
+		signed <- err
+	}()
+	<-blocked
+	frozen := make(chan error)
+	go func() {
+		_, err := hold.SetKeyState(addr, StateFrozen)
+		frozen <- err
+	}()
+	select {
+	case <-frozen:
+		t.Fatal("Key state saved while a use count was being saved")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(store.release)
+	if err := <-signed; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-frozen; err != nil {
+		t.Fatal(err)
+	}
+	if record := string(store.store[addr]); !strings.Contains(record, "uses=1") || !strings.Contains(record, "state=frozen") {
+		t.Error("Stale record saved:", record)
+	}
+
+	// a use counted after the key is retired doesn't save it back
+	hold.SetKeyState(addr, StateRetired)
+	key := readKey(store.store[addr])
+	hold.countUse(key)
+	if record := string(store.store[addr]); !strings.Contains(record, "state=retired") || strings.Contains(record, "uses=2") {
+		t.Error("Retired key saved again:", record)
+	}
+}
+
+// storedSize sums the sizes of the key records of a file store
+func storedSize(t *testing.T, dir string) int64 {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var size int64
+	for _, info := range infos {
+		if !info.IsDir() {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// wipeCheckFamily keeps the private keys a family is handed, to check they're wiped after use
+type wipeCheckFamily struct {
+	Family
+	privs [][]byte
+}
+
+func (f *wipeCheckFamily) NewKey() ([]byte, []byte, error) {
+	pub, priv, err := f.Family.NewKey()
+	f.privs = append(f.privs, priv)
+	return pub, priv, err
+}
+
+func (f *wipeCheckFamily) Sign(priv, data []byte, network *Network, spent *SpentOutput) ([]byte, []byte, error) {
+	f.privs = append(f.privs, priv)
+	return f.Family.Sign(priv, data, network, spent)
+}
+
+func TestWipeSecrets(t *testing.T) {
+	bitcoinFamily, _ := LookupFamily(BitcoinFamily)
+	family := &wipeCheckFamily{Family: bitcoinFamily}
+	RegisterFamily(BitcoinFamily, family)
+	defer RegisterFamily(BitcoinFamily, bitcoinFamily)
+
+	if _, _, err := testNewAndSign(t, testHold(), ADDR1, TxData1); err != nil {
+		t.Fatal(err)
+	}
+	if len(family.privs) != 2 {
+		t.Fatal("Expected the private key of the new key and of the signature:", len(family.privs))
+	}
+	for n, priv := range family.privs {
+		if len(priv) == 0 || !bytes.Equal(priv, make([]byte, len(priv))) {
+			t.Errorf("Private key %d not wiped: %x", n, priv)
+		}
+	}
+
+	// the buffer the hold kept the master key in is wiped on lock
+	hold := testHold()
+	held := hold.masterKey
+	if len(held) == 0 || bytes.Equal(held, make([]byte, len(held))) {
+		t.Fatal("Master key not held")
+	}
+	hold.Lock()
+	if hold.masterKey != nil || !bytes.Equal(held, make([]byte, len(held))) {
+		t.Errorf("Master key not wiped on lock: %x", held)
+	}
+
+	ciph, _ := aes.NewCipher(MasterKey([]byte("test")))
+	enc, _ := util.Encrypt(ciph, []byte("secret"))
+	clone := append([]byte(nil), enc...)
+	dec, err := util.Decrypt(ciph, clone)
+	if err != nil || string(dec) != "secret" {
+		t.Fatal("Decryption failed:", err)
+	}
+	if !bytes.Equal(clone[aes.BlockSize:], make([]byte, len(clone)-aes.BlockSize)) {
+		t.Errorf("Decrypted base64 buffer not wiped: %q", clone[aes.BlockSize:])
+	}
+}
+
+func TestVerifyKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, _ := MakeFileStore(dir)
+	if initialized, err := Initialized(store); err != nil || initialized {
+		t.Error("New store should not be initialized:", err)
+	}
+	hold, _ := MakeHold([]byte("test"), store)
+	if initialized, err := Initialized(store); err != nil || !initialized {
+		t.Error("Store should be initialized once unlocked:", err)
+	}
+
+	network := btcNetwork()
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		addr, _ := hold.NewKey(NewNetworkChallenge([]string{ADDR1}, network), network, nil)
+		addrs = append(addrs, addr)
+	}
+	if problems, err := hold.VerifyKeys(); err != nil || len(problems) != 0 {
+		t.Fatal("Unexpected problems:", problems, err)
+	}
+
+	// the private keys of the records swapped
+	hold.keys[addrs[0]].encryptedPrivate, hold.keys[addrs[1]].encryptedPrivate =
+		hold.keys[addrs[1]].encryptedPrivate, hold.keys[addrs[0]].encryptedPrivate
+	problems, err := hold.VerifyKeys()
+	if err != nil || len(problems) != 2 || !strings.Contains(problems[0].Problem, "private key of") {
+		t.Error("Swapped keys not found:", problems, err)
+	}
+	hold.Lock()
+	if _, err := hold.VerifyKeys(); !errors.Is(err, ErrLocked) {
+		t.Error("Locked hold should not verify keys:", err)
+	}
+}
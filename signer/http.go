@@ -1,20 +1,90 @@
 package signer
 
 import (
-	"encoding/hex"
+	"context"
+	"crypto/tls"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 // SigningHandler signing handler
 type SigningHandler struct {
 	hold *Hold
+	// nil when clients are not authorized individually
+	auth AuthTable
+	// nil when requests can't be HMAC signed
+	hmac *HMACAuth
+	// maximum size of request bodies, unlimited when 0
+	maxBody int64
+	started time.Time
+	// nil when not collected
+	metrics *Metrics
+	// nil when operations are not audited
+	audit *AuditLog
+	// shares submitted to unlock
+	shares shareCollector
+}
+
+// NewSigningHandler creates the handler of the signer endpoints
+func NewSigningHandler(hold *Hold, config *ServerConfig) *SigningHandler {
+	return &SigningHandler{
+		hold:    hold,
+		auth:    config.Auth,
+		hmac:    config.HMAC,
+		maxBody: config.MaxBodyBytes,
+		started: time.Now(),
+		metrics: NewMetrics(),
+		audit:   config.Audit,
+	}
 }
 
 func (sh *SigningHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if sh.serveProbe(w, r) {
+		return
+	}
+	if sh.maxBody > 0 {
+		if r.ContentLength > sh.maxBody {
+			err := errBodyTooLarge()
+			if strings.HasPrefix(r.URL.Path, APIPrefix) {
+				writeAPIError(w, err)
+			} else {
+				formError(w, err)
+			}
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, sh.maxBody)
+	}
+
+	client, err := sh.identify(r)
+	if strings.HasPrefix(r.URL.Path, APIPrefix) || r.URL.Path == "/status" {
+		switch {
+		case err != nil:
+			writeAPIError(w, err)
+		case r.URL.Path == "/status":
+			sh.serveStatus(w, client)
+		default:
+			sh.serveAPI(w, r, client)
+		}
+		return
+	}
+	if err != nil {
+		formError(w, err)
+		return
+	}
+
 	if r.Method == "POST" {
 		err := r.ParseForm()
+		if bodyTooLarge(err) {
+			formError(w, errBodyTooLarge())
+			return
+		}
 		if err != nil {
 			r400(w, "Invalid form data.")
 			return
@@ -22,88 +92,264 @@ func (sh *SigningHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 		switch r.URL.Path {
 		case "/transfer":
-			coinPrefix := r.FormValue("coinPrefix")
-			targetAddr := r.FormValue("targetAddr")
-			feeAddr := r.FormValue("feeAddr")
-			prefixVal := r.FormValue("prefix")
-
-			coinFamily := CoinPrefixToCoinFamily(coinPrefix)
-			// to maintain legacy support
-			if coinFamily == UnknownCoinFamily {
-				coinFamily = BitcoinFamily
-			}
-
-			// Ethereum does not have change addresses
-			if coinFamily == EthereumFamily && len(feeAddr) != 0 {
-				r400(w, "Invalid change address param for EthereumFamily")
-				return
-			}
-
-			if len(targetAddr) == 0 {
-				r400(w, "Missing target address for transfer.")
-				return
-			}
-
-			prefix := byte(0)
-			if len(prefixVal) > 0 {
-				preint, err := strconv.Atoi(prefixVal)
-				if err != nil {
-					r400(w, "Invalid prefix.")
-					return
-				}
-				prefix = byte(preint)
-			}
-			addrs := []string{targetAddr}
-			if len(feeAddr) > 0 {
-				addrs = append(addrs, feeAddr)
-			}
-			log.Println(addrs)
-			addr, err := sh.hold.NewKey(NewSignatureChallenge(addrs, coinFamily), prefix, coinFamily)
+			allowMessages := r.FormValue("allowMessages") == "1" || r.FormValue("allowMessages") == "true"
+			resp, err := sh.transfer(client, &TransferRequest{
+				CoinPrefix:    r.FormValue("coinPrefix"),
+				TargetAddr:    r.FormValue("targetAddr"),
+				FeeAddr:       r.FormValue("feeAddr"),
+				AllowMessages: allowMessages,
+				RequestID:     r.FormValue("requestId"),
+				AddressType:   r.FormValue("addressType"),
+				Prefix:        r.FormValue("prefix"),
+			})
 			if err != nil {
-				r500(w, err)
+				formError(w, err)
 				return
 			}
-			log.Println("transfer |", addr, "->", targetAddr)
-			w.Write([]byte(addr))
+			w.Write([]byte(resp.Address))
 			return
 
 		case "/sign":
-			sourceAddr := r.FormValue("sourceAddr")
-			txDataStr := r.FormValue("txData")
-			if len(sourceAddr) == 0 || len(txDataStr) == 0 {
-				r400(w, "Missing source address or tx data to sign.")
-				return
+			req := &SignRequest{SourceAddr: r.FormValue("sourceAddr"), TxData: r.FormValue("txData")}
+			if len(r.FormValue("inputIndex")) > 0 || len(r.FormValue("amount")) > 0 {
+				index, err1 := strconv.Atoi(r.FormValue("inputIndex"))
+				amount, err2 := strconv.ParseUint(r.FormValue("amount"), 10, 64)
+				if err1 != nil || err2 != nil {
+					r400(w, "Invalid input index or amount.")
+					return
+				}
+				req.InputIndex, req.Amount = &index, &amount
 			}
-			log.Println("sign     |", sourceAddr)
-
-			txData, err := hex.DecodeString(txDataStr)
+			resp, err := sh.sign(client, req)
 			if err != nil {
-				r400(w, "Bad hex encoding.")
+				formError(w, err)
 				return
 			}
+			w.Write([]byte(resp.Signature + "|" + resp.PublicKey))
+			return
 
-			sig, pubkey, err := sh.hold.Sign(sourceAddr, txData)
+		case "/signmessage":
+			resp, err := sh.signMessage(client, &SignMessageRequest{
+				SourceAddr: r.FormValue("sourceAddr"),
+				Message:    r.FormValue("message"),
+			})
 			if err != nil {
-				r500(w, err)
+				formError(w, err)
 				return
 			}
-			w.Write([]byte(hex.EncodeToString(sig) + "|" + hex.EncodeToString(pubkey)))
-			log.Println("sign     | ok")
+			w.Write([]byte(resp.Signature))
 			return
 		}
 	}
 	w.WriteHeader(404)
 }
 
-// StartServer starts the server
-func StartServer(hold *Hold) {
+// identify returns the identity of the client: the key ID of HMAC signed requests, otherwise the
+// common name of the client certificate, if any
+func (sh *SigningHandler) identify(r *http.Request) (string, error) {
+	if sh.hmac != nil && len(r.Header.Get(HeaderKeyID)) > 0 {
+		return sh.hmac.Authenticate(r)
+	}
+	return ClientIdentity(r), nil
+}
+
+// ServerConfig holds the listening address, TLS settings, timeouts and client authentication
+// settings
+type ServerConfig struct {
+	Addr     string
+	CertFile string
+	KeyFile  string
+	// TLS versions and TLS 1.2 cipher suites, Go defaults when zero
+	MinTLSVersion uint16
+	MaxTLSVersion uint16
+	CipherSuites  []uint16
+
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// ShutdownTimeout bounds the time in-flight requests have to complete on shutdown
+	ShutdownTimeout time.Duration
+	// MaxBodyBytes limits the size of request bodies, unlimited when 0
+	MaxBodyBytes int64
+
+	// ClientCAFile is a PEM bundle of the CAs of client certificates, client certificates are
+	// required when set
+	ClientCAFile string
+	// HMAC authenticates signed requests, clients without certificates are then accepted
+	HMAC *HMACAuth
+	// Auth grants operations to client identities, all clients may do everything when nil
+	Auth AuthTable
+	// Audit records key creations and signatures, when not nil
+	Audit *AuditLog
+	// DeleteGrace is how long deleted keys are kept before being purged
+	DeleteGrace time.Duration
+	// IdleLock locks the signer when it hasn't used its keys for that long, never when zero
+	IdleLock time.Duration
+}
+
+// StartServer starts the server and serves until SIGTERM or SIGINT. It then stops accepting
+// connections, lets in-flight requests complete within the shutdown timeout and flushes the store.
+func StartServer(hold *Hold, config *ServerConfig) error {
+	tlsConfig := &tls.Config{
+		MinVersion:   config.MinTLSVersion,
+		MaxVersion:   config.MaxTLSVersion,
+		CipherSuites: config.CipherSuites,
+	}
 	httpServer := &http.Server{
-		Addr:    ":8443",
-		Handler: &SigningHandler{hold},
+		Addr:              config.Addr,
+		Handler:           NewSigningHandler(hold, config),
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		ReadTimeout:       config.ReadTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+	}
+	if len(config.ClientCAFile) > 0 {
+		err := requireClientCerts(tlsConfig, config.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		if config.HMAC != nil {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			log.Println("Client certificates verified, HMAC signed requests accepted.")
+		} else {
+			log.Println("Client certificates required.")
+		}
+	} else if config.HMAC != nil {
+		log.Println("HMAC signed requests accepted.")
+	}
+	if config.Auth != nil {
+		log.Println("Authorization table loaded,", len(config.Auth), "clients.")
 	}
 
+	stop := make(chan struct{})
+	defer close(stop)
+	go purgeLoop(hold, config.Audit, config.DeleteGrace, stop)
+	if config.IdleLock > 0 {
+		go idleLockLoop(hold, config.Audit, config.IdleLock, stop)
+	}
+	if len(freezeSignals) > 0 {
+		freezes := make(chan os.Signal, 1)
+		signal.Notify(freezes, freezeSignals...)
+		defer signal.Stop(freezes)
+		go freezeOnSignal(hold, config.Audit, freezes, stop)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(sigs)
+	served := make(chan error, 1)
+	go func() {
+		served <- httpServer.ListenAndServeTLS(config.CertFile, config.KeyFile)
+	}()
 	log.Println("Server started.")
-	log.Fatal(httpServer.ListenAndServeTLS("signer.crt", "signer.key"))
+
+	select {
+	case err := <-served:
+		return err
+	case sig := <-sigs:
+		log.Println("Received", sig, "shutting down.")
+	}
+	err := shutdown(httpServer, hold, config.ShutdownTimeout)
+	config.Audit.Close()
+	return err
+}
+
+// shutdown stops the server, waiting for in-flight requests until the timeout, and flushes the
+// store whether or not they all completed
+func shutdown(httpServer *http.Server, hold *Hold, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := httpServer.Shutdown(ctx)
+	if err != nil {
+		log.Println("In-flight requests not completed:", err)
+		httpServer.Close()
+	}
+	if ferr := hold.Flush(); ferr != nil {
+		log.Println("Store flush failed:", ferr)
+		return ferr
+	}
+	log.Println("Server stopped.")
+	return err
+}
+
+// transferNetwork resolves the network of a transfer from its coin prefix. Legacy clients may only
+// send a raw P2PKH prefix byte, or nothing at all for bitcoin.
+func transferNetwork(coinPrefix, prefixVal string) (*Network, error) {
+	prefix := -1
+	if len(prefixVal) > 0 {
+		preint, err := strconv.Atoi(prefixVal)
+		if err != nil || preint < 0 || preint > 255 {
+			return nil, badRequest(CodeInvalidField, "prefix", "Invalid prefix.")
+		}
+		prefix = preint
+	}
+
+	if len(coinPrefix) == 0 {
+		if prefix < 0 {
+			return LookupNetwork("btc")
+		}
+		network, err := NetworkForPrefix(byte(prefix))
+		if err != nil {
+			return nil, badRequest(CodeUnknownNetwork, "prefix", "Unknown prefix.")
+		}
+		if network.Disabled {
+			return nil, badRequest(CodeUnknownNetwork, "prefix", "Coin prefix disabled.")
+		}
+		return network, nil
+	}
+
+	network, err := LookupNetwork(coinPrefix)
+	if err != nil {
+		return nil, badRequest(CodeUnknownNetwork, "coinPrefix", "Unknown coin prefix.")
+	}
+	if network.Disabled {
+		return nil, badRequest(CodeUnknownNetwork, "coinPrefix", "Coin prefix disabled.")
+	}
+	if prefix >= 0 && network.Family == BitcoinFamily && byte(prefix) != network.P2PKHVersion {
+		return nil, badRequest(CodeInvalidField, "prefix", "Prefix does not match coin prefix.")
+	}
+	return network, nil
+}
+
+// errBodyTooLarge is the error of a request body over the maximum size
+func errBodyTooLarge() *APIError {
+	return apiError(http.StatusRequestEntityTooLarge, CodeTooLarge, "Request body too large.", "")
+}
+
+// bodyTooLarge tells whether reading a body failed on the maximum size, for bodies without
+// content length. http.MaxBytesReader errors are matched by message, their type is too recent.
+func bodyTooLarge(err error) bool {
+	return err != nil && strings.HasSuffix(err.Error(), "request body too large")
+}
+
+// formError writes an error the way the form endpoints always have: validation failures with
+// their message, other failures as a 500 with the error
+func formError(w http.ResponseWriter, err error) {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		w.WriteHeader(apiErr.Status)
+		w.Write([]byte(apiErr.Message))
+		return
+	}
+	if err == ErrMessageSigningDisabled {
+		w.WriteHeader(403)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err == ErrRequestConflict {
+		w.WriteHeader(409)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if errors.Is(err, ErrKeyFrozen) || errors.Is(err, ErrKeyDeleted) || errors.Is(err, ErrSignerFrozen) ||
+		errors.Is(err, ErrLocked) {
+		w.WriteHeader(toAPIError(err).Status)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	r500(w, err)
 }
 
 func r400(w http.ResponseWriter, msg string) {
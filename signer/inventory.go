@@ -0,0 +1,253 @@
+package signer
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Key inventory: metadata of the keys held, never their encrypted material
+
+// Key states
+const (
+	StateActive  = "active"
+	StateFrozen  = "frozen"
+	StateRetired = "retired"
+	StateDeleted = "deleted"
+)
+
+// KeyInfo is the metadata of a key
+type KeyInfo struct {
+	Address       string         `json:"address"`
+	CoinFamily    string         `json:"coinFamily"`
+	CoinPrefix    string         `json:"coinPrefix"`
+	State         string         `json:"state"`
+	Challenge     *ChallengeInfo `json:"challenge"`
+	Created       *time.Time     `json:"created,omitempty"`
+	Uses          uint64         `json:"uses"`
+	AllowMessages bool           `json:"allowMessages"`
+	// AddressType is set for keys created with an address type other than the default
+	AddressType string `json:"addressType,omitempty"`
+	// Deleted is when a deleted key was deleted, it is purged after the grace period
+	Deleted *time.Time `json:"deleted,omitempty"`
+}
+
+// ChallengeInfo is a decoded challenge
+type ChallengeInfo struct {
+	Type      string   `json:"type"`
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+// KeyFilter selects keys of a listing, zero fields select all keys
+type KeyFilter struct {
+	CoinFamily    *CoinFamily
+	CoinPrefixes  []string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	State         string
+	// Target selects keys whose challenge allows paying to the address
+	Target string
+}
+
+func (f *KeyFilter) matches(info *KeyInfo, k *key) bool {
+	if f.CoinFamily != nil && k.coinFamily != *f.CoinFamily {
+		return false
+	}
+	if f.CoinPrefixes != nil {
+		allowed := false
+		for _, prefix := range f.CoinPrefixes {
+			allowed = allowed || prefix == info.CoinPrefix
+		}
+		if !allowed {
+			return false
+		}
+	}
+	if !f.CreatedAfter.IsZero() && (info.Created == nil || !info.Created.After(f.CreatedAfter)) {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && (info.Created == nil || !info.Created.Before(f.CreatedBefore)) {
+		return false
+	}
+	if len(f.State) > 0 && info.State != f.State {
+		return false
+	}
+	if len(f.Target) > 0 {
+		found := false
+		for _, addr := range info.Challenge.Addresses {
+			found = found || addr == f.Target
+		}
+		return found
+	}
+	return true
+}
+
+// info returns the metadata of a key, the keys lock held
+func (k *key) info() *KeyInfo {
+	network := k.network
+	if network == nil {
+		network = defaultNetwork(k.coinFamily)
+	}
+	info := &KeyInfo{
+		Address:       k.address,
+		CoinFamily:    k.coinFamily.String(),
+		CoinPrefix:    network.Name,
+		State:         k.currentState(),
+		Challenge:     &ChallengeInfo{Type: "unknown"},
+		Uses:          k.uses,
+		AllowMessages: k.allowMessages,
+		AddressType:   string(k.addressType),
+	}
+	if !k.created.IsZero() {
+		created := k.created
+		info.Created = &created
+	}
+	if !k.deleted.IsZero() {
+		deleted := k.deleted
+		info.Deleted = &deleted
+	}
+	if challenge, ok := k.challenge.(interface{ Addresses() []string }); ok {
+		info.Challenge = &ChallengeInfo{"signature", challenge.Addresses()}
+	}
+	return info
+}
+
+// KeyInfo returns the metadata of the key of an address
+func (h *Hold) KeyInfo(addr string) (*KeyInfo, error) {
+	h.keyslock.RLock()
+	defer h.keyslock.RUnlock()
+	key := h.keys[addr]
+	if key == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAddress, addr)
+	}
+	return key.info(), nil
+}
+
+// ListKeys lists keys matching the filter by address, up to limit of them after the address
+// after. Returns the address to list the next ones after, empty on the last page.
+func (h *Hold) ListKeys(filter *KeyFilter, after string, limit int) ([]*KeyInfo, string) {
+	h.keyslock.RLock()
+	addrs := make([]string, 0, len(h.keys))
+	for addr := range h.keys {
+		if addr > after {
+			addrs = append(addrs, addr)
+		}
+	}
+	h.keyslock.RUnlock()
+	sort.Strings(addrs)
+
+	var infos []*KeyInfo
+	for _, addr := range addrs {
+		h.keyslock.RLock()
+		key := h.keys[addr]
+		var info *KeyInfo
+		if key != nil {
+			info = key.info()
+			if !filter.matches(info, key) {
+				info = nil
+			}
+		}
+		h.keyslock.RUnlock()
+		if info == nil {
+			continue
+		}
+		if len(infos) == limit {
+			return infos, infos[limit-1].Address
+		}
+		infos = append(infos, info)
+	}
+	return infos, ""
+}
+
+// Paging of key listings
+const (
+	DefaultKeysLimit = 100
+	MaxKeysLimit     = 1000
+)
+
+// KeyListResponse is a page of keys, Next is the cursor of the next page
+type KeyListResponse struct {
+	Keys []*KeyInfo `json:"keys"`
+	Next string     `json:"next,omitempty"`
+}
+
+// serveAdminKeys serves the key inventory: admin/keys lists keys, admin/keys/<address> gets one
+// and admin/keys/<address>/state changes its state
+func (sh *SigningHandler) serveAdminKeys(r *http.Request, client, path string) (interface{}, error) {
+	if strings.HasPrefix(path, "admin/keys/") && strings.HasSuffix(path, "/state") {
+		addr := strings.TrimSuffix(strings.TrimPrefix(path, "admin/keys/"), "/state")
+		if err := sh.authorize(client, OpAdmin, ""); err != nil {
+			return nil, err
+		}
+		req := &KeyStateRequest{}
+		if err := decodeJSON(r, req); err != nil {
+			return nil, err
+		}
+		return sh.setKeyState(client, addr, req)
+	}
+	if r.Method != "GET" {
+		return nil, apiError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed.", "")
+	}
+	if err := sh.authorize(client, OpAdmin, ""); err != nil {
+		return nil, err
+	}
+
+	if addr := strings.TrimPrefix(path, "admin/keys/"); addr != path {
+		if err := sh.authorizeKey(client, OpAdmin, addr); err != nil {
+			return nil, err
+		}
+		return sh.hold.KeyInfo(addr)
+	}
+
+	filter, after, limit, err := keysQuery(r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	if sh.auth != nil {
+		// only the networks the client administers
+		filter.CoinPrefixes = []string{}
+		for _, network := range networks {
+			if sh.auth[client].Allows(OpAdmin, network.Name) {
+				filter.CoinPrefixes = append(filter.CoinPrefixes, network.Name)
+			}
+		}
+	}
+	keys, next := sh.hold.ListKeys(filter, after, limit)
+	if keys == nil {
+		keys = []*KeyInfo{}
+	}
+	return &KeyListResponse{keys, next}, nil
+}
+
+func keysQuery(query url.Values) (*KeyFilter, string, int, error) {
+	filter := &KeyFilter{State: query.Get("state"), Target: query.Get("target")}
+	if name := query.Get("coinFamily"); len(name) > 0 {
+		coinFamily := ParseCoinFamily(name)
+		if coinFamily == UnknownCoinFamily {
+			return nil, "", 0, badRequest(CodeInvalidField, "coinFamily", "Unknown coin family.")
+		}
+		filter.CoinFamily = &coinFamily
+	}
+	for field, t := range map[string]*time.Time{"createdAfter": &filter.CreatedAfter,
+		"createdBefore": &filter.CreatedBefore} {
+		if v := query.Get(field); len(v) > 0 {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, "", 0, badRequest(CodeInvalidField, field, "Invalid time, RFC 3339 expected.")
+			}
+			*t = parsed
+		}
+	}
+	limit := DefaultKeysLimit
+	if v := query.Get("limit"); len(v) > 0 {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > MaxKeysLimit {
+			return nil, "", 0, badRequest(CodeInvalidField, "limit", "Invalid limit, 1 to 1000.")
+		}
+	}
+	return filter, query.Get("after"), limit, nil
+}
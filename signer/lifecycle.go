@@ -0,0 +1,236 @@
+package signer
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Key lifecycle: an active key signs, a frozen key is kept loaded but refuses to sign, a retired
+// key is archived out of the hold and a deleted key refuses to sign until it is purged from the
+// store once its grace period is over. Deleted and frozen keys can be made active again.
+
+// Lifecycle errors
+var (
+	ErrKeyFrozen  = errors.New("key is frozen")
+	ErrKeyDeleted = errors.New("key is deleted")
+	// ErrInvalidTransition is returned when a key can't be moved to a state from its current one
+	ErrInvalidTransition = errors.New("invalid key state transition")
+)
+
+// DefaultDeleteGrace is how long deleted keys are kept before being purged
+const DefaultDeleteGrace = 7 * 24 * time.Hour
+
+// purgeInterval is how often deleted keys past their grace period are looked for
+const purgeInterval = time.Hour
+
+// transitions lists the states a key can move to, by state
+var transitions = map[string][]string{
+	StateActive:  {StateFrozen, StateRetired, StateDeleted},
+	StateFrozen:  {StateActive, StateRetired, StateDeleted},
+	StateDeleted: {StateActive, StateFrozen},
+}
+
+// ValidKeyState checks a state name
+func ValidKeyState(state string) bool {
+	_, ok := transitions[state]
+	return ok || state == StateRetired
+}
+
+// currentState returns the state of a key, the keys lock held
+func (k *key) currentState() string {
+	if len(k.state) == 0 {
+		return StateActive
+	}
+	return k.state
+}
+
+// signingKey finds the key of an address, if it is allowed to sign
+func (h *Hold) signingKey(addr string) (*key, error) {
+	h.keyslock.RLock()
+	defer h.keyslock.RUnlock()
+	if h.frozen {
+		return nil, ErrSignerFrozen
+	}
+	key := h.keys[addr]
+	if key == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAddress, addr)
+	}
+	switch key.currentState() {
+	case StateFrozen:
+		return nil, fmt.Errorf("%w: %s", ErrKeyFrozen, addr)
+	case StateDeleted:
+		return nil, fmt.Errorf("%w: %s", ErrKeyDeleted, addr)
+	}
+	return key, nil
+}
+
+// SetKeyState moves the key of an address to a state. Retired keys are archived when the store
+// supports it and no longer held. Returns the metadata of the key in its new state.
+func (h *Hold) SetKeyState(addr, state string) (*KeyInfo, error) {
+	if !ValidKeyState(state) {
+		return nil, errors.New("Unknown key state " + state)
+	}
+	h.keyslock.RLock()
+	key := h.keys[addr]
+	h.keyslock.RUnlock()
+	if key == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAddress, addr)
+	}
+	// no other save of the record until this one is done
+	key.savelock.Lock()
+	defer key.savelock.Unlock()
+	h.keyslock.Lock()
+	if h.keys[addr] != key {
+		h.keyslock.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAddress, addr)
+	}
+	from := key.currentState()
+	allowed := false
+	for _, to := range transitions[from] {
+		allowed = allowed || to == state
+	}
+	if !allowed {
+		h.keyslock.Unlock()
+		return nil, fmt.Errorf("%w: %s to %s", ErrInvalidTransition, from, state)
+	}
+	previous, deleted, size := key.state, key.deleted, key.size
+	key.state, key.deleted = state, time.Time{}
+	if state == StateActive {
+		key.state = ""
+	}
+	if state == StateDeleted {
+		key.deleted = time.Now().UTC().Truncate(time.Second)
+	}
+	record, info := key.bytes(), key.info()
+	if state == StateRetired {
+		delete(h.keys, addr)
+		delete(h.requests, key.requestID)
+		h.storeSize -= int64(size)
+	} else {
+		h.storeSize += int64(len(record) - size)
+		key.size = len(record)
+	}
+	h.keyslock.Unlock()
+
+	var err error
+	if archiver, ok := h.store.(Archiver); ok && state == StateRetired {
+		err = archiver.Archive(addr, record)
+	} else {
+		err = h.store.Save(addr, record)
+	}
+	if err != nil {
+		// back to the previous state, the record wasn't changed
+		h.keyslock.Lock()
+		key.state, key.deleted = previous, deleted
+		if state == StateRetired {
+			h.keys[addr] = key
+			if len(key.requestID) > 0 {
+				h.requests[key.requestID] = addr
+			}
+			h.storeSize += int64(size)
+		} else {
+			h.storeSize -= int64(len(record) - size)
+			key.size = size
+		}
+		h.keyslock.Unlock()
+		return nil, err
+	}
+	return info, nil
+}
+
+// PurgeDeleted removes the keys deleted for longer than the grace period from the hold and the
+// store. Returns the addresses purged.
+func (h *Hold) PurgeDeleted(grace time.Duration) ([]string, error) {
+	h.keyslock.RLock()
+	var expired []*key
+	for _, key := range h.keys {
+		if key.state == StateDeleted && time.Since(key.deleted) >= grace {
+			expired = append(expired, key)
+		}
+	}
+	h.keyslock.RUnlock()
+
+	var purged []string
+	for _, key := range expired {
+		if ok, err := h.purge(key, grace); err != nil {
+			return purged, err
+		} else if ok {
+			log.Println("purged |", key.address)
+			purged = append(purged, key.address)
+		}
+	}
+	return purged, nil
+}
+
+// purge removes a deleted key past its grace period, unless restored meanwhile
+func (h *Hold) purge(key *key, grace time.Duration) (bool, error) {
+	// held while deleting so a key can't be restored or saved again halfway
+	key.savelock.Lock()
+	defer key.savelock.Unlock()
+	h.keyslock.Lock()
+	defer h.keyslock.Unlock()
+	if h.keys[key.address] != key || key.state != StateDeleted || time.Since(key.deleted) < grace {
+		return false, nil
+	}
+	if err := h.store.Delete(key.address); err != nil {
+		return false, err
+	}
+	delete(h.keys, key.address)
+	delete(h.requests, key.requestID)
+	h.storeSize -= int64(key.size)
+	return true, nil
+}
+
+// purgeLoop purges deleted keys past their grace period until stopped, recording purges in the
+// audit log
+func purgeLoop(hold *Hold, audit *AuditLog, grace time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+	for {
+		purged, err := hold.PurgeDeleted(grace)
+		for _, addr := range purged {
+			if aerr := audit.Record(&AuditEntry{Event: "purge", Source: addr, Outcome: OutcomePurged}, nil); aerr != nil {
+				log.Println("Could not audit purge of", addr, aerr)
+			}
+		}
+		if err != nil {
+			log.Println("Purge of deleted keys failed:", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// KeyStateRequest moves a key to a state
+type KeyStateRequest struct {
+	State string `json:"state"`
+}
+
+// setKeyState serves admin/keys/<address>/state
+func (sh *SigningHandler) setKeyState(client, addr string, req *KeyStateRequest) (info *KeyInfo, err error) {
+	entry := &AuditEntry{Event: "state", Client: client, Source: addr, State: req.State}
+	defer func() {
+		if err = sh.record(entry, OutcomeStateChanged, err); err != nil {
+			info = nil
+		}
+	}()
+
+	if err := sh.authorizeKey(client, OpAdmin, addr); err != nil {
+		return nil, err
+	}
+	if !ValidKeyState(req.State) {
+		return nil, badRequest(CodeInvalidField, "state", "Unknown key state.")
+	}
+	info, err = sh.hold.SetKeyState(addr, req.State)
+	if err != nil {
+		return nil, err
+	}
+	entry.CoinPrefix = info.CoinPrefix
+	log.Println("state |", addr, "->", req.State, clientLog(client))
+	return info, nil
+}
@@ -0,0 +1,239 @@
+package signer
+
+import (
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/blockcypher/cryptosigner/util"
+)
+
+// Runtime locking: the hold can start locked and be unlocked with the password over the admin
+// API, and locked again on demand or after an idle timeout. The master key is held in a secret
+// buffer while unlocked and wiped on lock, the cipher is made from it for each operation. Keys
+// stay loaded so unlocking doesn't read the store.
+
+// Lock errors
+var (
+	ErrLocked        = errors.New("signer is locked")
+	ErrWrongPassword = errors.New("wrong password")
+	// ErrNotInitialized is returned when unlocking over the API a store without password
+	// verifier nor keys, which would take any password: run init first
+	ErrNotInitialized = errors.New("store not initialised, run init first")
+)
+
+// verifierMeta names the password verifier in stores supporting metadata
+const verifierMeta = "verifier"
+
+// PasswordVerifier derives from the master key a value checking the password on unlock, which
+// can't be used to recover the key
+func PasswordVerifier(masterKey []byte) []byte {
+	verifier := make([]byte, 32)
+	io.ReadFull(hkdf.New(sha256.New, masterKey, nil, []byte("cryptosigner password verifier")), verifier)
+	return verifier
+}
+
+// Initialized tells whether a store was unlocked once, its password verifier saved, or holds keys
+func Initialized(store Store) (bool, error) {
+	if metas, ok := store.(MetaStore); ok {
+		verifier, err := metas.Meta(verifierMeta)
+		if err != nil || verifier != nil {
+			return verifier != nil, err
+		}
+	}
+	records, err := store.ReadAll()
+	return len(records) > 0, err
+}
+
+// Unlock derives the master key from the password, refused when the master key is split in shares
+func (h *Hold) Unlock(pass []byte) error {
+	if settings, err := LoadShareSettings(h.store); err != nil || settings != nil {
+		if err == nil {
+			err = ErrSplit
+		}
+		return err
+	}
+	masterKey := MasterKey(pass)
+	defer util.Wipe(masterKey)
+	return h.UnlockWithKey(masterKey)
+}
+
+// UnlockWithKey keeps a copy of the master key, the caller wipes its own. The key is checked against the verifier
+// saved in the store, or failing one by decrypting a key, and the verifier saved when the store
+// supports metadata.
+func (h *Hold) UnlockWithKey(masterKey []byte) error {
+	verifier := PasswordVerifier(masterKey)
+	ciph, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return err
+	}
+
+	metas, hasMeta := h.store.(MetaStore)
+	var saved []byte
+	if hasMeta {
+		if saved, err = metas.Meta(verifierMeta); err != nil {
+			return err
+		}
+	}
+	if saved != nil {
+		if !hmac.Equal(saved, verifier) {
+			return ErrWrongPassword
+		}
+	} else if key := h.anyKey(); key != nil {
+		clone := append([]byte(nil), key.encryptedPrivate...)
+		priv, err := util.Decrypt(ciph, clone)
+		if err != nil {
+			return ErrWrongPassword
+		}
+		util.Wipe(priv)
+	}
+	if hasMeta && saved == nil {
+		if err := metas.SaveMeta(verifierMeta, verifier); err != nil {
+			return err
+		}
+	}
+
+	held := util.NewSecret(len(masterKey))
+	copy(held, masterKey)
+	h.cipherlock.Lock()
+	h.wipeMasterKey()
+	h.masterKey, h.lastUsed = held, time.Now()
+	h.cipherlock.Unlock()
+	return nil
+}
+
+// Lock wipes the master key, keys can't be created nor used until unlocked again
+func (h *Hold) Lock() {
+	h.cipherlock.Lock()
+	h.wipeMasterKey()
+	h.cipherlock.Unlock()
+}
+
+// LockIfIdle locks the hold if the master key hasn't been used for the timeout. Returns whether
+// it locked it.
+func (h *Hold) LockIfIdle(timeout time.Duration) bool {
+	h.cipherlock.Lock()
+	defer h.cipherlock.Unlock()
+	if h.masterKey == nil || time.Since(h.lastUsed) < timeout {
+		return false
+	}
+	h.wipeMasterKey()
+	return true
+}
+
+// wipeMasterKey zeroes and drops the master key, called with the cipher lock held
+func (h *Hold) wipeMasterKey() {
+	util.Wipe(h.masterKey)
+	h.masterKey = nil
+}
+
+// anyKey returns a key of the hold, nil if empty
+func (h *Hold) anyKey() *key {
+	h.keyslock.RLock()
+	defer h.keyslock.RUnlock()
+	for _, key := range h.keys {
+		return key
+	}
+	return nil
+}
+
+// idleLockLoop locks the hold after the idle timeout until stopped
+func idleLockLoop(hold *Hold, audit *AuditLog, timeout time.Duration, stop <-chan struct{}) {
+	interval := timeout / 10
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if hold.LockIfIdle(timeout) {
+				log.Println("Idle for", timeout, "locked.")
+				entry := &AuditEntry{Event: "lock", Client: "idle", Outcome: OutcomeLocked}
+				if err := audit.Record(entry, nil); err != nil {
+					log.Println("Could not audit lock:", err)
+				}
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// UnlockRequest unlocks the signer with its password. Decoded as a string, it's wiped only as
+// the request is collected.
+type UnlockRequest struct {
+	Password string `json:"password"`
+}
+
+// LockResponse is the state of the lock after a lock or unlock
+type LockResponse struct {
+	Locked bool `json:"locked"`
+}
+
+// unlock serves admin/unlock
+func (sh *SigningHandler) unlock(client string, req *UnlockRequest) (resp *LockResponse, err error) {
+	entry := &AuditEntry{Event: "unlock", Client: client}
+	defer func() {
+		if err = sh.record(entry, OutcomeUnlocked, err); err != nil {
+			resp = nil
+		}
+	}()
+
+	if err := sh.authorize(client, OpUnlock, ""); err != nil {
+		return nil, err
+	}
+	if len(req.Password) == 0 {
+		return nil, badRequest(CodeMissingField, "password", "Missing password.")
+	}
+	if settings, err := LoadShareSettings(sh.hold.store); err != nil || settings != nil {
+		if err == nil {
+			err = ErrSplit
+		}
+		return nil, err
+	}
+	if initialized, err := Initialized(sh.hold.store); err != nil || !initialized {
+		if err == nil {
+			err = ErrNotInitialized
+		}
+		return nil, err
+	}
+	pass := []byte(req.Password)
+	masterKey := MasterKey(pass)
+	util.Wipe(pass)
+	defer util.Wipe(masterKey)
+	if err := sh.hold.UnlockWithKey(masterKey); err != nil {
+		log.Println("unlock | failed:", err, clientLog(client))
+		return nil, err
+	}
+	if err := sh.audit.Open(masterKey); err != nil {
+		sh.hold.Lock()
+		return nil, err
+	}
+	log.Println("unlock |", clientLog(client))
+	return &LockResponse{false}, nil
+}
+
+// lock serves admin/lock
+func (sh *SigningHandler) lock(client string) (resp *LockResponse, err error) {
+	entry := &AuditEntry{Event: "lock", Client: client}
+	defer func() {
+		if err = sh.record(entry, OutcomeLocked, err); err != nil {
+			resp = nil
+		}
+	}()
+
+	if err := sh.authorize(client, OpAdmin, ""); err != nil {
+		return nil, err
+	}
+	sh.hold.Lock()
+	log.Println("lock |", clientLog(client))
+	return &LockResponse{true}, nil
+}
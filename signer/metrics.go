@@ -0,0 +1,185 @@
+package signer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prometheus metrics of the signing activity, in the text exposition format. Requests are counted
+// by operation, coin family and outcome, their latency observed by operation and coin family.
+
+// Outcomes of requests
+const (
+	OutcomeCreated         = "created"
+	OutcomeSigned          = "signed"
+	OutcomeChallengeFailed = "challenge_failed"
+	OutcomeUnknownAddress  = "unknown_address"
+	OutcomeDecryptError    = "decrypt_error"
+	OutcomeBadRequest      = "bad_request"
+	OutcomeUnauthorized    = "unauthorized"
+	OutcomeKeyInactive     = "key_inactive"
+	OutcomeStateChanged    = "state_changed"
+	OutcomePurged          = "purged"
+	OutcomeSignerFrozen    = "signer_frozen"
+	OutcomeFrozen          = "frozen"
+	OutcomeUnfrozen        = "unfrozen"
+	OutcomeSignerLocked    = "signer_locked"
+	OutcomeLocked          = "locked"
+	OutcomeUnlocked        = "unlocked"
+	OutcomeShareSubmitted  = "share_submitted"
+	OutcomeError           = "error"
+)
+
+// latency buckets, in seconds
+var latencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+type requestLabels struct {
+	operation, coinFamily, outcome string
+}
+
+type histogram struct {
+	counts []uint64 // by bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// Metrics collects the request metrics
+type Metrics struct {
+	lock      sync.Mutex
+	requests  map[requestLabels]uint64
+	latencies map[requestLabels]*histogram // outcome unset
+}
+
+// NewMetrics creates an empty metrics collector
+func NewMetrics() *Metrics {
+	return &Metrics{requests: make(map[requestLabels]uint64), latencies: make(map[requestLabels]*histogram)}
+}
+
+// Observe records a request, its outcome and latency. Nil metrics record nothing.
+func (m *Metrics) Observe(operation string, coinFamily CoinFamily, outcome string, latency time.Duration) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.requests[requestLabels{operation, coinFamily.String(), outcome}]++
+
+	labels := requestLabels{operation, coinFamily.String(), ""}
+	h := m.latencies[labels]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.latencies[labels] = h
+	}
+	seconds := latency.Seconds()
+	for n, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[n]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// requestOutcome classifies the result of a request
+func requestOutcome(success string, err error) string {
+	var apiErr *APIError
+	switch {
+	case err == nil:
+		return success
+	case errors.Is(err, ErrChallengeFailed):
+		return OutcomeChallengeFailed
+	case errors.Is(err, ErrUnknownAddress):
+		return OutcomeUnknownAddress
+	case errors.Is(err, ErrDecrypt):
+		return OutcomeDecryptError
+	case errors.Is(err, ErrKeyFrozen) || errors.Is(err, ErrKeyDeleted):
+		return OutcomeKeyInactive
+	case errors.Is(err, ErrSignerFrozen):
+		return OutcomeSignerFrozen
+	case errors.Is(err, ErrLocked):
+		return OutcomeSignerLocked
+	case errors.Is(err, ErrWrongPassword) || errors.Is(err, ErrWrongShares):
+		return OutcomeUnauthorized
+	case errors.As(err, &apiErr) && apiErr.Status == http.StatusBadRequest:
+		return OutcomeBadRequest
+	case errors.As(err, &apiErr) && (apiErr.Status == http.StatusUnauthorized || apiErr.Status == http.StatusForbidden):
+		return OutcomeUnauthorized
+	}
+	return OutcomeError
+}
+
+// WriteTo writes the metrics and the gauges of the hold in the Prometheus text format. Nil metrics
+// write the gauges only.
+func (m *Metrics) WriteTo(w io.Writer, hold *Hold) {
+	var requests, latencies []string
+	if m != nil {
+		requests, latencies = m.lines()
+	}
+	sort.Strings(requests)
+	sort.Strings(latencies)
+
+	io.WriteString(w, "# HELP signer_requests_total Requests by operation, coin family and outcome.\n")
+	io.WriteString(w, "# TYPE signer_requests_total counter\n")
+	io.WriteString(w, strings.Join(requests, ""))
+	io.WriteString(w, "# HELP signer_request_duration_seconds Request latency by operation and coin family.\n")
+	io.WriteString(w, "# TYPE signer_request_duration_seconds histogram\n")
+	io.WriteString(w, strings.Join(latencies, ""))
+	io.WriteString(w, "# HELP signer_keys_loaded Keys loaded in the hold.\n")
+	io.WriteString(w, "# TYPE signer_keys_loaded gauge\n")
+	fmt.Fprintf(w, "signer_keys_loaded %d\n", hold.KeyCount())
+	frozen := 0
+	if hold.Frozen() {
+		frozen = 1
+	}
+	locked := 0
+	if hold.Locked() {
+		locked = 1
+	}
+	io.WriteString(w, "# HELP signer_locked Whether the signer is locked.\n")
+	io.WriteString(w, "# TYPE signer_locked gauge\n")
+	fmt.Fprintf(w, "signer_locked %d\n", locked)
+	io.WriteString(w, "# HELP signer_frozen Whether all signing is frozen.\n")
+	io.WriteString(w, "# TYPE signer_frozen gauge\n")
+	fmt.Fprintf(w, "signer_frozen %d\n", frozen)
+	io.WriteString(w, "# HELP signer_store_size_bytes Size of the key records in the store.\n")
+	io.WriteString(w, "# TYPE signer_store_size_bytes gauge\n")
+	fmt.Fprintf(w, "signer_store_size_bytes %d\n", hold.StoreSize())
+}
+
+// lines formats the request counters and latency histograms, unsorted
+func (m *Metrics) lines() (requests, latencies []string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	requests = make([]string, 0, len(m.requests))
+	for labels, count := range m.requests {
+		requests = append(requests, fmt.Sprintf("signer_requests_total{operation=%q,coin_family=%q,outcome=%q} %d\n",
+			labels.operation, labels.coinFamily, labels.outcome, count))
+	}
+	latencies = make([]string, 0, len(m.latencies))
+	for labels, h := range m.latencies {
+		var sb strings.Builder
+		l := fmt.Sprintf("operation=%q,coin_family=%q", labels.operation, labels.coinFamily)
+		cumulative := uint64(0)
+		for n, bound := range latencyBuckets {
+			cumulative += h.counts[n]
+			fmt.Fprintf(&sb, "signer_request_duration_seconds_bucket{%s,le=\"%g\"} %d\n", l, bound, cumulative)
+		}
+		fmt.Fprintf(&sb, "signer_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", l, h.count)
+		fmt.Fprintf(&sb, "signer_request_duration_seconds_sum{%s} %g\n", l, h.sum)
+		fmt.Fprintf(&sb, "signer_request_duration_seconds_count{%s} %d\n", l, h.count)
+		latencies = append(latencies, sb.String())
+	}
+	return requests, latencies
+}
+
+func (sh *SigningHandler) serveMetrics(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	sh.metrics.WriteTo(w, sh.hold)
+}
@@ -0,0 +1,113 @@
+package signer
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/blockcypher/cryptosigner/signer/bitcoin"
+)
+
+// Network describes a coin network, keyed by its coin prefix. Drives address
+// encoding of new keys and validation of challenge addresses.
+type Network struct {
+	Name   string
+	Family CoinFamily
+	// Address versions, bech32 HRP, CashAddr prefix and address types, for Bitcoin family networks
+	bitcoin.Params
+	// ChainID for Ethereum family networks
+	ChainID int64
+	// Disabled networks refuse new keys, existing keys still sign
+	Disabled bool
+}
+
+// RegisterNetwork adds a network to the registry, for instance for a newly registered family
+func RegisterNetwork(network *Network) error {
+	if _, err := LookupNetwork(network.Name); err == nil {
+		return errors.New("Coin prefix already registered: " + network.Name)
+	}
+	networks = append(networks, network)
+	return nil
+}
+
+var (
+	// ErrUnknownNetwork is returned for a coin prefix not in the registry
+	ErrUnknownNetwork = errors.New("Unknown coin prefix")
+
+	allBitcoinTypes = []bitcoin.AddressType{
+		bitcoin.P2PKH, bitcoin.P2SH, bitcoin.P2WPKH, bitcoin.P2WSH, bitcoin.P2TR}
+	segwitV0Types = []bitcoin.AddressType{
+		bitcoin.P2PKH, bitcoin.P2SH, bitcoin.P2WPKH, bitcoin.P2WSH}
+	legacyTypes = []bitcoin.AddressType{bitcoin.P2PKH, bitcoin.P2SH}
+)
+
+// The registry, in lookup order for legacy prefix bytes
+var networks = []*Network{
+	{Name: "btc", Family: BitcoinFamily, Params: bitcoin.Params{
+		P2PKHVersion: 0x00, P2SHVersion: 0x05, Bech32HRP: "bc", AddressTypes: allBitcoinTypes}},
+	{Name: "btc-testnet", Family: BitcoinFamily, Params: bitcoin.Params{
+		P2PKHVersion: 0x6f, P2SHVersion: 0xc4, Bech32HRP: "tb", AddressTypes: allBitcoinTypes}},
+	{Name: "ltc", Family: BitcoinFamily, Params: bitcoin.Params{
+		P2PKHVersion: 0x30, P2SHVersion: 0x32, Bech32HRP: "ltc", AddressTypes: segwitV0Types,
+		MessageMagic: "Litecoin Signed Message:\n"}},
+	{Name: "doge", Family: BitcoinFamily, Params: bitcoin.Params{
+		P2PKHVersion: 0x1e, P2SHVersion: 0x16, AddressTypes: legacyTypes,
+		MessageMagic: "Dogecoin Signed Message:\n"}},
+	{Name: "dash", Family: BitcoinFamily, Params: bitcoin.Params{
+		P2PKHVersion: 0x4c, P2SHVersion: 0x10, AddressTypes: legacyTypes,
+		MessageMagic: "DarkCoin Signed Message:\n"}},
+	// BlockCypher test chain
+	{Name: "bcy", Family: BitcoinFamily, Params: bitcoin.Params{
+		P2PKHVersion: 0x1b, P2SHVersion: 0x1f, AddressTypes: legacyTypes}},
+	{Name: "bch", Family: BitcoinCashFamily, Params: bitcoin.Params{
+		P2PKHVersion: 0x00, P2SHVersion: 0x05, CashAddrPrefix: "bitcoincash", AddressTypes: legacyTypes}},
+	{Name: "bch-testnet", Family: BitcoinCashFamily, Params: bitcoin.Params{
+		P2PKHVersion: 0x6f, P2SHVersion: 0xc4, CashAddrPrefix: "bchtest", AddressTypes: legacyTypes}},
+	{Name: "eth", Family: EthereumFamily, ChainID: 1},
+	{Name: "eth-sepolia", Family: EthereumFamily, ChainID: 11155111},
+	// BlockCypher internal Ethereum testnet, has always been signed with the mainnet chain id
+	{Name: "beth", Family: EthereumFamily, ChainID: 1},
+	{Name: "sol", Family: Ed25519Family},
+	{Name: "sol-devnet", Family: Ed25519Family},
+}
+
+// LookupNetwork finds a network by coin prefix
+func LookupNetwork(coinPrefix string) (*Network, error) {
+	name := strings.ToLower(coinPrefix)
+	for _, n := range networks {
+		if n.Name == name {
+			return n, nil
+		}
+	}
+	return nil, ErrUnknownNetwork
+}
+
+// NetworkForPrefix finds the first Bitcoin family network using prefix as its
+// P2PKH version byte, for clients still passing a raw prefix
+func NetworkForPrefix(prefix byte) (*Network, error) {
+	for _, n := range networks {
+		if n.Family == BitcoinFamily && n.P2PKHVersion == prefix {
+			return n, nil
+		}
+	}
+	return nil, ErrUnknownNetwork
+}
+
+// ValidateAddress checks that addr is a valid address on the network and returns it the way
+// challenges hold it
+func (n *Network) ValidateAddress(addr string) (string, error) {
+	family, err := LookupFamily(n.Family)
+	if err != nil {
+		return "", err
+	}
+	return family.ValidateAddress(addr, n)
+}
+
+// defaultNetwork is assumed for keys stored before networks were recorded
+func defaultNetwork(family CoinFamily) *Network {
+	if family == EthereumFamily {
+		n, _ := LookupNetwork("eth")
+		return n
+	}
+	n, _ := LookupNetwork("btc")
+	return n
+}
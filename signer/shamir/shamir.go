@@ -0,0 +1,116 @@
+package shamir
+
+import (
+	"crypto/rand"
+	"errors"
+)
+
+// Shamir secret sharing over GF(256): each byte of the secret is the constant term of a random
+// polynomial of degree threshold-1, a share holds the values of the polynomials at one non-zero
+// point, followed by the point. Any threshold shares give the secret back, fewer tell nothing.
+
+// Errors of Split and Combine
+var (
+	ErrThreshold   = errors.New("threshold must be between 2 and the number of shares, at most 255")
+	ErrShareFormat = errors.New("shares must be of one length, with distinct non-zero points")
+	ErrNotEnough   = errors.New("at least 2 shares are needed")
+	ErrEmptySecret = errors.New("empty secret")
+)
+
+// Split splits a secret in n shares, any threshold of which give it back
+func Split(secret []byte, n, threshold int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, ErrEmptySecret
+	}
+	if threshold < 2 || threshold > n || n > 255 {
+		return nil, ErrThreshold
+	}
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][len(secret)] = byte(i + 1)
+	}
+	coeffs := make([]byte, threshold)
+	defer wipe(coeffs)
+	for j, b := range secret {
+		coeffs[0] = b
+		if _, err := rand.Read(coeffs[1:]); err != nil {
+			return nil, err
+		}
+		for _, share := range shares {
+			share[j] = evaluate(coeffs, share[len(secret)])
+		}
+	}
+	return shares, nil
+}
+
+// Combine gives back the secret of shares. Shares of another secret or too few shares give a
+// wrong secret, which has to be checked by the caller.
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, ErrNotEnough
+	}
+	size := len(shares[0])
+	points := make(map[byte]bool)
+	for _, share := range shares {
+		if len(share) != size || size < 2 || share[size-1] == 0 || points[share[size-1]] {
+			return nil, ErrShareFormat
+		}
+		points[share[size-1]] = true
+	}
+
+	// Lagrange interpolation at 0
+	secret := make([]byte, size-1)
+	for i, share := range shares {
+		xi := share[size-1]
+		basis := byte(1)
+		for k, other := range shares {
+			if k == i {
+				continue
+			}
+			xk := other[size-1]
+			basis = mul(basis, div(xk, xk^xi))
+		}
+		for j := range secret {
+			secret[j] ^= mul(share[j], basis)
+		}
+	}
+	return secret, nil
+}
+
+// evaluate evaluates a polynomial at x, by Horner's method
+func evaluate(coeffs []byte, x byte) byte {
+	y := byte(0)
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		y = mul(y, x) ^ coeffs[i]
+	}
+	return y
+}
+
+// mul multiplies in GF(256) with the AES polynomial, without branching on the operands
+func mul(a, b byte) byte {
+	p := byte(0)
+	for i := 0; i < 8; i++ {
+		p ^= -(b & 1) & a
+		carry := -(a >> 7)
+		a = (a << 1) ^ (carry & 0x1b)
+		b >>= 1
+	}
+	return p
+}
+
+// div divides in GF(256), b isn't zero
+func div(a, b byte) byte {
+	// b^254 is the inverse of b
+	inv := b
+	for i := 0; i < 6; i++ {
+		inv = mul(mul(inv, inv), b)
+	}
+	return mul(a, mul(inv, inv))
+}
+
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
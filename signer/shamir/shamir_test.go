@@ -0,0 +1,57 @@
+package shamir
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSplitCombine(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	shares, err := Split(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, picked := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		var subset [][]byte
+		for _, i := range picked {
+			subset = append(subset, shares[i])
+		}
+		combined, err := Combine(subset)
+		if err != nil || !bytes.Equal(combined, secret) {
+			t.Error("Combination failed for shares", picked, err)
+		}
+	}
+	if combined, _ := Combine(shares[:2]); bytes.Equal(combined, secret) {
+		t.Error("Below threshold shares gave the secret")
+	}
+}
+
+func TestField(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if mul(byte(a), div(1, byte(a))) != 1 {
+			t.Fatal("No inverse for", a)
+		}
+	}
+	if mul(0x57, 0x83) != 0xc1 {
+		t.Error("Unexpected product")
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	for _, params := range [][2]int{{3, 1}, {3, 4}, {256, 3}} {
+		if _, err := Split([]byte{1}, params[0], params[1]); err != ErrThreshold {
+			t.Error("Split should have failed for", params, err)
+		}
+	}
+	shares, _ := Split([]byte{1, 2}, 3, 2)
+	for name, bad := range map[string][][]byte{
+		"one":       shares[:1],
+		"duplicate": {shares[0], shares[0]},
+		"length":    {shares[0], shares[1][1:]},
+		"zero":      {shares[0], {1, 2, 0}},
+	} {
+		if _, err := Combine(bad); err == nil {
+			t.Error("Combine should have failed:", name)
+		}
+	}
+}
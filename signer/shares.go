@@ -0,0 +1,215 @@
+package signer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/blockcypher/cryptosigner/signer/shamir"
+	"github.com/blockcypher/cryptosigner/util"
+)
+
+// M-of-N unlock: the master key of the store is split in N Shamir shares at init, and M
+// operators submit theirs, from the console or one per admin request, to unlock the signer. Shares
+// are never logged nor audited, only who submitted one.
+
+// Share errors
+var (
+	ErrNotSplit = errors.New("master key not split in shares")
+	// ErrSplit is returned when unlocking with the password a store whose master key is split
+	ErrSplit          = errors.New("master key split in shares, unlock with the shares")
+	ErrShareSubmitted = errors.New("share already submitted")
+	// ErrWrongShares is returned when the shares submitted don't combine into the master key,
+	// they are all discarded
+	ErrWrongShares = errors.New("shares don't give the master key, submit them again")
+)
+
+// sharesMeta names the share settings in the store metadata
+const sharesMeta = "shares"
+
+// ShareSettings are the threshold and number of shares of a split master key
+type ShareSettings struct {
+	Threshold int `json:"threshold"`
+	Shares    int `json:"shares"`
+}
+
+// NewMasterKey generates a random master key, for stores unlocked with shares only
+func NewMasterKey() ([]byte, error) {
+	masterKey := make([]byte, 32)
+	if _, err := rand.Read(masterKey); err != nil {
+		return nil, err
+	}
+	return masterKey, nil
+}
+
+// SplitMasterKey splits the master key of a store in shares, any threshold of which unlock it.
+// The password verifier and share settings are saved in the store. The master key must be random,
+// not derived from a password which would unlock the store alone.
+func SplitMasterKey(store Store, masterKey []byte, shares, threshold int) ([]string, error) {
+	metas, ok := store.(MetaStore)
+	if !ok {
+		return nil, errors.New("Store can't save share settings")
+	}
+	split, err := shamir.Split(masterKey, shares, threshold)
+	if err != nil {
+		return nil, err
+	}
+	if err := metas.SaveMeta(verifierMeta, PasswordVerifier(masterKey)); err != nil {
+		return nil, err
+	}
+	settings, _ := json.Marshal(&ShareSettings{threshold, shares})
+	if err := metas.SaveMeta(sharesMeta, settings); err != nil {
+		return nil, err
+	}
+	encoded := make([]string, len(split))
+	for n, share := range split {
+		encoded[n] = hex.EncodeToString(share)
+		util.Wipe(share)
+	}
+	return encoded, nil
+}
+
+// LoadShareSettings reads the share settings of a store, nil if its master key isn't split
+func LoadShareSettings(store Store) (*ShareSettings, error) {
+	metas, ok := store.(MetaStore)
+	if !ok {
+		return nil, nil
+	}
+	data, err := metas.Meta(sharesMeta)
+	if data == nil || err != nil {
+		return nil, err
+	}
+	settings := &ShareSettings{}
+	return settings, json.Unmarshal(data, settings)
+}
+
+// CombineShares decodes hex encoded shares and combines them into the master key
+func CombineShares(encoded [][]byte) ([]byte, error) {
+	shares := make([][]byte, len(encoded))
+	for n, share := range encoded {
+		shares[n] = util.NewSecret(hex.DecodedLen(len(share)))
+		if _, err := hex.Decode(shares[n], share); err != nil {
+			for _, share := range shares[:n+1] {
+				util.Wipe(share)
+			}
+			return nil, errors.New("Invalid share encoding")
+		}
+	}
+	masterKey, err := shamir.Combine(shares)
+	for _, share := range shares {
+		util.Wipe(share)
+	}
+	return masterKey, err
+}
+
+// shareCollector keeps the shares submitted until there are enough to unlock
+type shareCollector struct {
+	lock       sync.Mutex
+	shares     [][]byte
+	submitters map[string]bool
+}
+
+// add adds the share of a submitter. Returns the master key once the threshold is reached, and
+// the number of shares submitted.
+func (c *shareCollector) add(submitter string, share []byte, threshold int) ([]byte, int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.submitters == nil {
+		c.submitters = make(map[string]bool)
+	}
+	if len(submitter) > 0 && c.submitters[submitter] {
+		return nil, len(c.shares), ErrShareSubmitted
+	}
+	for _, submitted := range c.shares {
+		if bytes.Equal(submitted, share) {
+			return nil, len(c.shares), ErrShareSubmitted
+		}
+	}
+	kept := util.NewSecret(len(share))
+	copy(kept, share)
+	c.shares = append(c.shares, kept)
+	c.submitters[submitter] = true
+	if len(c.shares) < threshold {
+		return nil, len(c.shares), nil
+	}
+	masterKey, err := CombineShares(c.shares)
+	for _, submitted := range c.shares {
+		util.Wipe(submitted)
+	}
+	c.shares, c.submitters = nil, nil
+	return masterKey, threshold, err
+}
+
+// ShareRequest submits a hex encoded share of the master key
+type ShareRequest struct {
+	Share string `json:"share"`
+}
+
+// ShareResponse tells how many shares were submitted of the threshold, and whether the signer
+// is still locked
+type ShareResponse struct {
+	Locked    bool `json:"locked"`
+	Submitted int  `json:"submitted"`
+	Threshold int  `json:"threshold"`
+}
+
+// submitShare serves admin/shares
+func (sh *SigningHandler) submitShare(client string, req *ShareRequest) (resp *ShareResponse, err error) {
+	entry := &AuditEntry{Event: "share", Client: client}
+	defer func() {
+		if err = sh.record(entry, OutcomeShareSubmitted, err); err != nil {
+			resp = nil
+		}
+	}()
+
+	if err := sh.authorize(client, OpUnlock, ""); err != nil {
+		return nil, err
+	}
+	settings, err := LoadShareSettings(sh.hold.store)
+	if err != nil {
+		return nil, err
+	}
+	if settings == nil {
+		return nil, ErrNotSplit
+	}
+	if len(req.Share) == 0 {
+		return nil, badRequest(CodeMissingField, "share", "Missing share.")
+	}
+	if _, err := hex.DecodeString(req.Share); err != nil {
+		return nil, badRequest(CodeInvalidField, "share", "Invalid share, hex expected.")
+	}
+	if !sh.hold.Locked() {
+		return &ShareResponse{false, 0, settings.Threshold}, nil
+	}
+
+	share := []byte(req.Share)
+	masterKey, submitted, err := sh.shares.add(client, share, settings.Threshold)
+	util.Wipe(share)
+	if err != nil {
+		log.Println("share | refused:", err, clientLog(client))
+		return nil, err
+	}
+	log.Println("share |", submitted, "of", settings.Threshold, clientLog(client))
+	if masterKey == nil {
+		return &ShareResponse{true, submitted, settings.Threshold}, nil
+	}
+	defer util.Wipe(masterKey)
+	if err := sh.hold.UnlockWithKey(masterKey); err != nil {
+		if errors.Is(err, ErrWrongPassword) {
+			err = ErrWrongShares
+		}
+		log.Println("unlock | failed:", err)
+		return nil, err
+	}
+	if err := sh.audit.Open(masterKey); err != nil {
+		sh.hold.Lock()
+		return nil, err
+	}
+	entry.Event = "unlock"
+	log.Println("unlock | with shares", clientLog(client))
+	return &ShareResponse{false, submitted, settings.Threshold}, nil
+}
@@ -0,0 +1,225 @@
+package solana
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/blockcypher/cryptosigner/signer/bitcoin"
+)
+
+// Address and transaction message logic for Solana
+
+// ErrAddressFormat is returned for an address that is not a base58 encoded 32 bytes public key
+var ErrAddressFormat = errors.New("invalid address encoding")
+
+const (
+	systemTransfer  = 2
+	computeBudgetID = "ComputeBudget111111111111111111111111111111"
+
+	// compute budget instructions
+	setComputeUnitLimit = 2
+	setComputeUnitPrice = 3
+	// maxComputeUnits is the compute unit limit of a transaction, assumed when not set
+	maxComputeUnits = 1400000
+)
+
+// MaxPriorityFee bounds, in lamports, the priority fee a message may pay with its compute budget
+// instructions: its compute unit price, in micro-lamports, times its compute unit limit
+var MaxPriorityFee uint64 = 5000000
+
+var (
+	systemProgram        = make([]byte, 32)
+	computeBudgetProgram = bitcoin.Base58Decode(computeBudgetID)
+)
+
+// EncodeAddress encodes an Ed25519 public key as an address
+func EncodeAddress(pub []byte) string {
+	return bitcoin.Base58Encode(pub)
+}
+
+// DecodeAddress returns the public key of an address
+func DecodeAddress(addr string) ([]byte, error) {
+	pub := bitcoin.Base58Decode(addr)
+	if len(pub) != 32 {
+		return nil, ErrAddressFormat
+	}
+	return pub, nil
+}
+
+// TransferMessage builds the legacy message of a system program transfer of lamports from an
+// account to another
+func TransferMessage(from, to []byte, lamports uint64, recentBlockhash []byte) []byte {
+	msg := new(bytes.Buffer)
+	msg.Write([]byte{1, 0, 1}) // header
+	msg.WriteByte(3)           // accounts
+	msg.Write(from)
+	msg.Write(to)
+	msg.Write(systemProgram)
+	msg.Write(recentBlockhash)
+	msg.WriteByte(1) // instructions
+	msg.Write([]byte{2, 2, 0, 1, 12})
+	data := make([]byte, 12)
+	binary.LittleEndian.PutUint32(data, systemTransfer)
+	binary.LittleEndian.PutUint64(data[4:], lamports)
+	msg.Write(data)
+	return msg.Bytes()
+}
+
+type instruction struct {
+	program  []byte
+	accounts [][]byte
+	data     []byte
+}
+
+// VerifyChallenge checks that the message only transfers to the addresses: every instruction is
+// a system program transfer to one of them, but compute unit limit and price instructions paying
+// at most MaxPriorityFee.
+func VerifyChallenge(addresses []string, message []byte) bool {
+	instructions, err := parseMessage(message)
+	if err != nil {
+		return false
+	}
+
+	var budget []instruction
+	transfers := 0
+	for _, ins := range instructions {
+		if bytes.Equal(ins.program, computeBudgetProgram) {
+			budget = append(budget, ins)
+			continue
+		}
+		if !bytes.Equal(ins.program, systemProgram) || len(ins.data) != 12 || len(ins.accounts) != 2 ||
+			binary.LittleEndian.Uint32(ins.data) != systemTransfer {
+			return false
+		}
+		to := EncodeAddress(ins.accounts[1])
+		allowed := false
+		for _, addr := range addresses {
+			allowed = allowed || addr == to
+		}
+		if !allowed {
+			return false
+		}
+		transfers++
+	}
+	return transfers > 0 && verifyComputeBudget(budget)
+}
+
+// verifyComputeBudget checks compute budget instructions only set the compute unit limit and
+// price, once each, for a priority fee of at most MaxPriorityFee
+func verifyComputeBudget(instructions []instruction) bool {
+	var limit, price uint64
+	seen := make(map[byte]bool)
+	for _, ins := range instructions {
+		if len(ins.accounts) > 0 || len(ins.data) == 0 || seen[ins.data[0]] {
+			return false
+		}
+		seen[ins.data[0]] = true
+		switch {
+		case ins.data[0] == setComputeUnitLimit && len(ins.data) == 5:
+			limit = uint64(binary.LittleEndian.Uint32(ins.data[1:]))
+		case ins.data[0] == setComputeUnitPrice && len(ins.data) == 9:
+			price = binary.LittleEndian.Uint64(ins.data[1:])
+		default:
+			return false
+		}
+	}
+	if !seen[setComputeUnitLimit] || limit > maxComputeUnits {
+		limit = maxComputeUnits
+	}
+	// price in micro-lamports per compute unit
+	return limit == 0 || price <= MaxPriorityFee*1000000/limit
+}
+
+// parseMessage reads the instructions of a legacy or version 0 message, resolving their
+// program and account indexes. Address table lookups would hide accounts and are refused.
+func parseMessage(message []byte) ([]instruction, error) {
+	r := &msgReader{data: message}
+	versioned := len(message) > 0 && message[0]&0x80 != 0
+	if versioned && r.byte()&0x7f != 0 {
+		return nil, errors.New("unsupported message version")
+	}
+
+	r.bytes(3) // header
+	accounts := make([][]byte, r.count(32))
+	for n := range accounts {
+		accounts[n] = r.bytes(32)
+	}
+	r.bytes(32) // recent blockhash
+
+	instructions := make([]instruction, r.count(3))
+	for n := range instructions {
+		ins := &instructions[n]
+		ins.program = r.account(accounts)
+		ins.accounts = make([][]byte, r.count(1))
+		for i := range ins.accounts {
+			ins.accounts[i] = r.account(accounts)
+		}
+		ins.data = r.bytes(r.compactU16())
+	}
+
+	if versioned && r.compactU16() != 0 {
+		return nil, errors.New("address table lookups not supported")
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.pos != len(message) {
+		return nil, errors.New("trailing data after message")
+	}
+	return instructions, nil
+}
+
+// msgReader reads message fields, remembering the first error
+type msgReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *msgReader) bytes(n int) []byte {
+	if r.err != nil || n > len(r.data)-r.pos {
+		r.err = errors.New("message data truncated")
+		return make([]byte, n)
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *msgReader) byte() byte {
+	return r.bytes(1)[0]
+}
+
+func (r *msgReader) compactU16() int {
+	v := 0
+	for shift := uint(0); shift < 21; shift += 7 {
+		b := r.byte()
+		v |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return v
+}
+
+// count reads a number of items of at least size bytes each, bounded by the remaining data
+func (r *msgReader) count(size int) int {
+	n := r.compactU16()
+	if n*size > len(r.data)-r.pos {
+		r.err = errors.New("message data truncated")
+		return 0
+	}
+	return n
+}
+
+func (r *msgReader) account(accounts [][]byte) []byte {
+	idx := int(r.byte())
+	if idx >= len(accounts) {
+		if r.err == nil {
+			r.err = errors.New("account index out of range")
+		}
+		return nil
+	}
+	return accounts[idx]
+}
@@ -0,0 +1,110 @@
+package solana
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// budgetMessage builds a legacy message with a system transfer and compute budget instructions
+func budgetMessage(from, to []byte, budget ...[]byte) []byte {
+	msg := new(bytes.Buffer)
+	msg.Write([]byte{1, 0, 2}) // header
+	msg.WriteByte(4)           // accounts
+	msg.Write(from)
+	msg.Write(to)
+	msg.Write(systemProgram)
+	msg.Write(computeBudgetProgram)
+	msg.Write(make([]byte, 32)) // recent blockhash
+	msg.WriteByte(byte(1 + len(budget)))
+	for _, data := range budget {
+		msg.Write([]byte{3, 0, byte(len(data))})
+		msg.Write(data)
+	}
+	msg.Write([]byte{2, 2, 0, 1, 12})
+	data := make([]byte, 12)
+	binary.LittleEndian.PutUint32(data, systemTransfer)
+	binary.LittleEndian.PutUint64(data[4:], 1000)
+	msg.Write(data)
+	return msg.Bytes()
+}
+
+func unitLimit(units uint32) []byte {
+	data := []byte{setComputeUnitLimit, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(data[1:], units)
+	return data
+}
+
+func unitPrice(microLamports uint64) []byte {
+	data := make([]byte, 9)
+	data[0] = setComputeUnitPrice
+	binary.LittleEndian.PutUint64(data[1:], microLamports)
+	return data
+}
+
+func TestVerifyChallenge(t *testing.T) {
+	from, to, other := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32), bytes.Repeat([]byte{3}, 32)
+	targets := []string{EncodeAddress(to)}
+	blockhash := make([]byte, 32)
+
+	if !VerifyChallenge(targets, TransferMessage(from, to, 1000, blockhash)) {
+		t.Error("Transfer to target should pass.")
+	}
+	if VerifyChallenge(targets, TransferMessage(from, other, 1000, blockhash)) {
+		t.Error("Transfer to another address should fail.")
+	}
+
+	// version 0 message without address table lookups
+	v0 := append([]byte{0x80}, TransferMessage(from, to, 1000, blockhash)...)
+	if !VerifyChallenge(targets, append(v0, 0)) {
+		t.Error("Version 0 transfer to target should pass.")
+	}
+	if VerifyChallenge(targets, append(v0, 1)) {
+		t.Error("Address table lookups should fail.")
+	}
+
+	// not a transfer: same accounts, CreateAccount instruction
+	msg := TransferMessage(from, to, 1000, blockhash)
+	msg[len(msg)-12] = 0
+	if VerifyChallenge(targets, msg) {
+		t.Error("Other instructions should fail.")
+	}
+	msg = TransferMessage(from, to, 1000, blockhash)
+	if VerifyChallenge(targets, msg[:len(msg)-1]) {
+		t.Error("Truncated message should fail.")
+	}
+}
+
+func TestVerifyComputeBudget(t *testing.T) {
+	from, to := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	targets := []string{EncodeAddress(to)}
+	tests := []struct {
+		budget [][]byte
+		ok     bool
+	}{
+		{nil, true},
+		{[][]byte{unitLimit(200000), unitPrice(25000000)}, true}, // 5000000 lamports
+		{[][]byte{unitLimit(200000), unitPrice(25000001)}, false},
+		{[][]byte{unitPrice(3571428)}, true}, // default limit of 1400000 units
+		{[][]byte{unitPrice(3571429)}, false},
+		{[][]byte{unitLimit(0), unitPrice(1 << 63)}, true},
+		{[][]byte{unitLimit(1 << 31), unitPrice(3571429)}, false},
+		{[][]byte{unitPrice(1), unitPrice(1)}, false},
+		{[][]byte{{1, 0, 0, 4, 0}}, false}, // heap frame
+		{[][]byte{unitPrice(1)[:8]}, false},
+	}
+	for n, test := range tests {
+		if VerifyChallenge(targets, budgetMessage(from, to, test.budget...)) != test.ok {
+			t.Error("Unexpected challenge result of budget", n)
+		}
+	}
+}
+
+func TestAddress(t *testing.T) {
+	if pub, err := DecodeAddress("11111111111111111111111111111111"); err != nil || !bytes.Equal(pub, systemProgram) {
+		t.Error("Could not decode system program address.", err)
+	}
+	if _, err := DecodeAddress("1111111111111111111111111111111"); err != ErrAddressFormat {
+		t.Error("Short address should fail.")
+	}
+}
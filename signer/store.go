@@ -1,13 +1,16 @@
 package signer
 
 import (
+	"errors"
 	"io/ioutil"
 	"log"
 	"os"
 	"path"
+	"runtime"
+	"strings"
 )
 
-// DirName is the storage directory name
+// DirName is the default storage directory name
 const DirName = ".store"
 
 // Store inteface
@@ -17,22 +20,65 @@ type Store interface {
 	ReadAll() ([][]byte, error)
 }
 
+// Flusher is implemented by stores buffering writes, flushed before the signer exits
+type Flusher interface {
+	Flush() error
+}
+
+// Pinger is implemented by stores able to check they are reachable
+type Pinger interface {
+	Ping() error
+}
+
+// Archiver is implemented by stores able to move a record out of the ones ReadAll returns
+type Archiver interface {
+	Archive(key string, data []byte) error
+}
+
+// FlagStore is implemented by stores able to save flags of the signer, apart from key records
+type FlagStore interface {
+	SetFlag(name string, set bool) error
+	Flag(name string) (bool, error)
+}
+
+// MetaStore is implemented by stores able to save metadata of the signer, apart from key records
+type MetaStore interface {
+	SaveMeta(name string, data []byte) error
+	// Meta returns nil for metadata never saved
+	Meta(name string) ([]byte, error)
+}
+
+// MetaDirName is the directory of the file store holding metadata
+const MetaDirName = "meta"
+
+// FlagsDirName is the directory of the file store holding flags, as empty files
+const FlagsDirName = "flags"
+
+// ArchiveDirName is the directory of the file store holding archived records
+const ArchiveDirName = "archive"
+
+// TmpDirName is the directory of the file store where files are written before being renamed
+// in place
+const TmpDirName = "tmp"
+
 // FileStore saves key data in files under a given directory
-type FileStore struct{}
+type FileStore struct {
+	dir string
+}
 
-// MakeFileStore creates the file store
-func MakeFileStore() (*FileStore, error) {
-	err := os.MkdirAll(DirName, 0700)
+// MakeFileStore creates the file store in a directory
+func MakeFileStore(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, 0700)
 	if err != nil {
 		return nil, err
 	}
-	return &FileStore{}, nil
+	return &FileStore{dir}, nil
 }
 
 // ReadAll reads all the data in the file store
 func (fs *FileStore) ReadAll() ([][]byte, error) {
 	log.Println("reading dir")
-	files, err := ioutil.ReadDir(DirName)
+	files, err := ioutil.ReadDir(fs.dir)
 	if err != nil {
 		return nil, err
 	}
@@ -45,7 +91,7 @@ func (fs *FileStore) ReadAll() ([][]byte, error) {
 		if n%1000 == 0 {
 			log.Println(n)
 		}
-		content, err := ioutil.ReadFile(path.Join(DirName, f.Name()))
+		content, err := ioutil.ReadFile(path.Join(fs.dir, f.Name()))
 		if err != nil {
 			return nil, err
 		}
@@ -55,12 +101,162 @@ func (fs *FileStore) ReadAll() ([][]byte, error) {
 	return data, nil
 }
 
-// Save saves data with a key
+// Save saves data with a key, replacing the previous data atomically
 func (fs *FileStore) Save(key string, data []byte) error {
-	return ioutil.WriteFile(path.Join(DirName, key), data, 0600)
+	return fs.writeFile(fs.dir, fileName(key), data)
+}
+
+// fileName returns the name of the file of a key. The ':' of CashAddr addresses, invalid in
+// Windows file names, is replaced by '_', found in no address encoding.
+func fileName(key string) string {
+	return strings.Replace(key, ":", "_", -1)
 }
 
-// Delete deletes some data
+// writeFile writes a file of a directory of the store atomically: the data is written and synced
+// in a temporary file, renamed over the file, and the directory synced. A crash leaves either the
+// previous or the new data.
+func (fs *FileStore) writeFile(dir, name string, data []byte) error {
+	tmpDir := path.Join(fs.dir, TmpDirName)
+	if err := os.MkdirAll(tmpDir, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(tmpDir, name+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path.Join(dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir syncs a directory, making the creation and renaming of its files durable. Windows
+// can't sync directories, renames are journaled there.
+func syncDir(name string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	dir, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// Delete deletes some data, overwriting it first
 func (fs *FileStore) Delete(key string) error {
-	return os.Remove(path.Join(DirName, key))
+	name := path.Join(fs.dir, fileName(key))
+	file, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err == nil {
+		_, err = file.Write(make([]byte, info.Size()))
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+// Archive moves data to the archive directory, where ReadAll doesn't look
+func (fs *FileStore) Archive(key string, data []byte) error {
+	dir := path.Join(fs.dir, ArchiveDirName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := fs.writeFile(dir, fileName(key), data); err != nil {
+		return err
+	}
+	return os.Remove(path.Join(fs.dir, fileName(key)))
+}
+
+// SetFlag sets or clears a flag
+func (fs *FileStore) SetFlag(name string, set bool) error {
+	dir := path.Join(fs.dir, FlagsDirName)
+	if !set {
+		err := os.Remove(path.Join(dir, name))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path.Join(dir, name), os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Flag tells whether a flag is set
+func (fs *FileStore) Flag(name string) (bool, error) {
+	_, err := os.Stat(path.Join(fs.dir, FlagsDirName, name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// SaveMeta saves metadata
+func (fs *FileStore) SaveMeta(name string, data []byte) error {
+	dir := path.Join(fs.dir, MetaDirName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	return fs.writeFile(dir, name, data)
+}
+
+// Meta reads metadata, nil if never saved
+func (fs *FileStore) Meta(name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path.Join(fs.dir, MetaDirName, name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// Flush syncs the store directory, making the creation of key files durable
+func (fs *FileStore) Flush() error {
+	return syncDir(fs.dir)
+}
+
+// Ping checks the store directory is still there
+func (fs *FileStore) Ping() error {
+	info, err := os.Stat(fs.dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New(fs.dir + " is not a directory")
+	}
+	return nil
+}
+
+// Backend names the store type
+func (fs *FileStore) Backend() string {
+	return "file"
 }
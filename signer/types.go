@@ -1,10 +1,9 @@
 package signer
 
-import "strings"
-
 // CoinFamily is an enum to describe the family of coin
 type CoinFamily uint8
 
+// Values are saved in key records: new families are appended, never inserted.
 const (
 	// BitcoinFamily type (btc, ltc, bcy,doge ...)
 	BitcoinFamily CoinFamily = iota
@@ -12,16 +11,36 @@ const (
 	EthereumFamily
 	// UnknownCoinFamily for error purpose
 	UnknownCoinFamily
+	// BitcoinCashFamily type (bch), CashAddr addresses and replay protected signatures
+	BitcoinCashFamily
+	// Ed25519Family type (sol), Ed25519 keys
+	Ed25519Family
 )
 
-// CoinPrefixToCoinFamily convert a coin family to a prefix
+var coinFamilyNames = []string{"bitcoin", "ethereum", "unknown", "bitcoincash", "ed25519"}
+
+func (cf CoinFamily) String() string {
+	if int(cf) < len(coinFamilyNames) {
+		return coinFamilyNames[cf]
+	}
+	return "unknown"
+}
+
+// ParseCoinFamily reads a coin family name, UnknownCoinFamily if unknown
+func ParseCoinFamily(name string) CoinFamily {
+	for n, familyName := range coinFamilyNames {
+		if familyName == name {
+			return CoinFamily(n)
+		}
+	}
+	return UnknownCoinFamily
+}
+
+// CoinPrefixToCoinFamily convert a coin prefix to its coin family
 func CoinPrefixToCoinFamily(coinPrefix string) CoinFamily {
-	switch strings.ToLower(coinPrefix) {
-	case "btc", "ltc", "doge", "dash":
-		return BitcoinFamily
-	case "eth", "beth":
-		return EthereumFamily
-	default:
+	network, err := LookupNetwork(coinPrefix)
+	if err != nil {
 		return UnknownCoinFamily
 	}
+	return network.Family
 }
@@ -0,0 +1,69 @@
+package signer
+
+import (
+	"sort"
+
+	"github.com/blockcypher/cryptosigner/util"
+)
+
+// KeyProblem is a key failing the store check
+type KeyProblem struct {
+	Address string `json:"address"`
+	Problem string `json:"problem"`
+}
+
+// VerifyKeys checks every key held: its family and state are known and its private key decrypts to the key of its address. Returns the problems found, by address. Requires the
+// hold to be unlocked.
+func (h *Hold) VerifyKeys() ([]*KeyProblem, error) {
+	if h.Locked() {
+		return nil, ErrLocked
+	}
+	h.keyslock.RLock()
+	keys := make([]*key, 0, len(h.keys))
+	for _, key := range h.keys {
+		keys = append(keys, key)
+	}
+	h.keyslock.RUnlock()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].address < keys[j].address })
+
+	var problems []*KeyProblem
+	for _, key := range keys {
+		if problem := h.verifyKey(key); len(problem) > 0 {
+			problems = append(problems, &KeyProblem{key.address, problem})
+		}
+	}
+	return problems, nil
+}
+
+// verifyKey checks a key, returns its problem, empty if none
+func (h *Hold) verifyKey(key *key) string {
+	family, err := LookupFamily(key.coinFamily)
+	if err != nil {
+		return "unknown coin family " + key.coinFamily.String()
+	}
+	if !ValidKeyState(key.currentState()) {
+		return "unknown state " + key.state
+	}
+	deriver, ok := family.(PublicKeyDeriver)
+	if !ok {
+		return ""
+	}
+	priv, err := h.decrypt(key)
+	if err != nil {
+		return err.Error()
+	}
+	pub := deriver.PublicKey(priv)
+	util.Wipe(priv)
+	network := key.network
+	if network == nil {
+		network = defaultNetwork(key.coinFamily)
+	}
+	addr, err := family.Address(pub, network)
+	if err != nil {
+		return "address encoding failed: " + err.Error()
+	}
+	if addr != key.address {
+		return "private key of " + addr + ", not of the address"
+	}
+	return ""
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/blockcypher/cryptosigner/signer"
+	"github.com/blockcypher/cryptosigner/util"
+)
+
+// initStore initialises a new store: saves the verifier of the password, confirmed, or splits a
+// new random master key in shares
+func initStore(args []string) {
+	fs, flags := newFlagSet("init", "")
+	shares := fs.Int("shares", 0, "split a random master key in that many shares instead of using a password")
+	threshold := fs.Int("threshold", 3, "number of shares unlocking the signer, with -shares")
+	conf := loadConfig(fs, flags, args, 0)
+
+	store, err := conf.OpenStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+	initialized, err := signer.Initialized(store)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if initialized {
+		log.Fatal("Store ", conf.Store.Path, " already initialised.")
+	}
+
+	if *shares > 0 {
+		masterKey, err := signer.NewMasterKey()
+		if err != nil {
+			log.Fatal(err)
+		}
+		printShares(store, masterKey, *shares, *threshold)
+		return
+	}
+	pwd, err := conf.ReadPassword(true)
+	if err != nil {
+		log.Fatal("Could not read password: ", err)
+	}
+	masterKey := signer.MasterKey(pwd)
+	util.Wipe(pwd)
+	_, err = signer.MakeHoldFromKey(masterKey, store)
+	util.Wipe(masterKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Store", conf.Store.Path, "initialised.")
+}
+
+// splitKey splits a new random master key of a store without keys in shares, printed one per
+// line. The password-derived key is never split, its password would still unlock the store alone.
+func splitKey(args []string) {
+	fs, flags := newFlagSet("split-key", "")
+	shares := fs.Int("shares", 5, "number of shares")
+	threshold := fs.Int("threshold", 3, "number of shares unlocking the signer")
+	conf := loadConfig(fs, flags, args, 0)
+	store, err := conf.OpenStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	hold, err := signer.MakeLockedHold(store)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if hold.KeyCount() > 0 {
+		log.Fatal("The store holds keys encrypted with the current master key, split only a new store.")
+	}
+	masterKey, err := signer.NewMasterKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+	printShares(store, masterKey, *shares, *threshold)
+}
+
+// printShares splits the master key in shares and prints them, the master key wiped
+func printShares(store signer.Store, masterKey []byte, shares, threshold int) {
+	split, err := signer.SplitMasterKey(store, masterKey, shares, threshold)
+	util.Wipe(masterKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Give each share to one operator,", threshold, "of them unlock the signer:")
+	for n, share := range split {
+		fmt.Printf("share %d: %s\n", n+1, share)
+	}
+}
+
+// verifyStore checks every key of the store decrypts to the key of its address, exits with 1 on
+// any problem
+func verifyStore(args []string) {
+	fs, flags := newFlagSet("verify-store", "")
+	conf := loadConfig(fs, flags, args, 0)
+
+	hold, audit := openHold(conf)
+	audit.Close()
+	problems, err := hold.VerifyKeys()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, problem := range problems {
+		fmt.Println("FAILED", problem.Address+":", problem.Problem)
+	}
+	if len(problems) > 0 {
+		fmt.Println(len(problems), "of", hold.KeyCount(), "keys failed.")
+		os.Exit(1)
+	}
+	fmt.Println("OK,", hold.KeyCount(), "keys verified.")
+}
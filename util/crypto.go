@@ -26,13 +26,18 @@ func (eS *ECDSASigner) NewKey() ([]byte, []byte, error) {
 	if err != nil {
 		return nil, nil, err
 	}
-	pubkey := priv.PubKey()
-	return pubkey.SerializeCompressed(), priv.ToECDSA().D.Bytes(), nil
+	defer priv.Zero()
+	scalar := priv.Key.Bytes()
+	private := NewSecret(len(scalar))
+	copy(private, scalar[:])
+	Wipe(scalar[:])
+	return priv.PubKey().SerializeCompressed(), private, nil
 }
 
 // Sign data with a private key
 func (eS *ECDSASigner) Sign(private, data []byte) ([]byte, error) {
 	privkey, _ := btcec.PrivKeyFromBytes(private)
+	defer privkey.Zero()
 
 	sig := ecdsa.Sign(privkey, data)
 	if sig == nil {
@@ -43,8 +48,8 @@ func (eS *ECDSASigner) Sign(private, data []byte) ([]byte, error) {
 
 // PubKeyFromPrivate retrieve public key from a private key
 func PubKeyFromPrivate(private []byte) []byte {
-	_, pubkey := btcec.PrivKeyFromBytes(private)
-	//pubkeyaddr  := &pubkey
+	privkey, pubkey := btcec.PrivKeyFromBytes(private)
+	privkey.Zero()
 	return pubkey.SerializeCompressed()
 }
 
@@ -66,20 +71,23 @@ func DoubleHash(data []byte) []byte {
 	return h2[:]
 }
 
-// Encrypt data
+// Encrypt data, the base64 encoded plaintext is wiped
 func Encrypt(ciph cipher.Block, text []byte) ([]byte, error) {
-	b := base64.StdEncoding.EncodeToString(text)
+	b := NewSecret(base64.StdEncoding.EncodedLen(len(text)))
+	defer Wipe(b)
+	base64.StdEncoding.Encode(b, text)
 	ciphertext := make([]byte, aes.BlockSize+len(b))
 	iv := ciphertext[:aes.BlockSize]
 	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
 		return nil, err
 	}
 	cfb := cipher.NewCFBEncrypter(ciph, iv)
-	cfb.XORKeyStream(ciphertext[aes.BlockSize:], []byte(b))
+	cfb.XORKeyStream(ciphertext[aes.BlockSize:], b)
 	return ciphertext, nil
 }
 
-// Decrypt data
+// Decrypt data, decrypted in place in ciphertext where the base64 encoded plaintext is wiped. The
+// data returned is to be wiped by the caller.
 func Decrypt(ciph cipher.Block, ciphertext []byte) ([]byte, error) {
 	if len(ciphertext) < aes.BlockSize {
 		return nil, errors.New("ciphertext too short")
@@ -88,9 +96,12 @@ func Decrypt(ciph cipher.Block, ciphertext []byte) ([]byte, error) {
 	cfb := cipher.NewCFBDecrypter(ciph, iv)
 	text := ciphertext[aes.BlockSize:]
 	cfb.XORKeyStream(text, text)
-	data, err := base64.StdEncoding.DecodeString(string(text))
+	defer Wipe(text)
+	data := NewSecret(base64.StdEncoding.DecodedLen(len(text)))
+	n, err := base64.StdEncoding.Decode(data, text)
 	if err != nil {
+		Wipe(data)
 		return nil, err
 	}
-	return data, nil
+	return data[:n], nil
 }
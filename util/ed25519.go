@@ -0,0 +1,41 @@
+package util
+
+// Ed25519 signer implementation
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+)
+
+// Ed25519Signer the Ed25519 signer struct
+type Ed25519Signer struct{}
+
+// NewKey Generates a new keypair, the private key is the 32 bytes seed
+func (eS *Ed25519Signer) NewKey() ([]byte, []byte, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer Wipe(priv)
+	seed := NewSecret(ed25519.SeedSize)
+	copy(seed, priv[:ed25519.SeedSize])
+	return pub, seed, nil
+}
+
+// Sign data with a private key seed
+func (eS *Ed25519Signer) Sign(private, data []byte) ([]byte, error) {
+	if len(private) != ed25519.SeedSize {
+		return nil, errors.New("invalid private key")
+	}
+	key := ed25519.NewKeyFromSeed(private)
+	defer Wipe(key)
+	return ed25519.Sign(key, data), nil
+}
+
+// Ed25519PubKeyFromPrivate retrieve public key from a private key seed
+func Ed25519PubKeyFromPrivate(private []byte) []byte {
+	key := ed25519.NewKeyFromSeed(private)
+	defer Wipe(key)
+	return key.Public().(ed25519.PublicKey)
+}
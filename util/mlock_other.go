@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package util
+
+import (
+	"errors"
+	"runtime"
+)
+
+func mlock(b []byte) error {
+	return errors.New("mlock not supported on " + runtime.GOOS)
+}
@@ -0,0 +1,10 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package util
+
+import "syscall"
+
+func mlock(b []byte) error {
+	return syscall.Mlock(b)
+}
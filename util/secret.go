@@ -0,0 +1,56 @@
+package util
+
+// Secret handling: buffers holding private keys, passwords and master keys are allocated with
+// NewSecret and zeroed with Wipe as soon as they're no longer needed, and never converted to
+// strings, which can't be wiped. Once LockMemory is called the pages of the buffers allocated are
+// also locked in memory so secrets are never swapped to disk. They stay locked, the heap reuses
+// them for later buffers.
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"runtime"
+)
+
+var lockMemory bool
+
+// LockMemory locks the pages of the secret buffers allocated from now on, fails where mlock isn't
+// supported or allowed (RLIMIT_MEMLOCK). Called once at startup.
+func LockMemory() error {
+	if err := mlock(make([]byte, 32)); err != nil {
+		return fmt.Errorf("Could not lock memory: %w", err)
+	}
+	lockMemory = true
+	return nil
+}
+
+// NewSecret allocates a buffer for secret material
+func NewSecret(size int) []byte {
+	b := make([]byte, size)
+	if lockMemory && size > 0 {
+		// best effort, LockMemory checked it works
+		mlock(b)
+	}
+	return b
+}
+
+// Wipe zeroes a buffer holding secret material
+func Wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+	runtime.KeepAlive(b)
+}
+
+// WipeECDSA zeroes the private scalar of an ECDSA key
+func WipeECDSA(priv *ecdsa.PrivateKey) {
+	if priv == nil || priv.D == nil {
+		return
+	}
+	words := priv.D.Bits()
+	for i := range words {
+		words[i] = 0
+	}
+	priv.D.SetInt64(0)
+	runtime.KeepAlive(words)
+}